@@ -0,0 +1,230 @@
+package utl
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	l "github.com/stevenb256/log"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Encoder writes objects one after another to a stream
+type Encoder interface {
+	Encode(object interface{}) error
+}
+
+// Decoder reads objects one after another from a stream
+type Decoder interface {
+	Decode(object interface{}) error
+}
+
+// Codec turns objects into bytes and back in one format
+type Codec interface {
+	Name() string
+	ContentType() string
+	Marshal(object interface{}) ([]byte, error)
+	Unmarshal(data []byte, object interface{}) error
+	NewEncoder(w io.Writer) Encoder
+	NewDecoder(r io.Reader) Decoder
+}
+
+// built in codecs
+var (
+	GobCodec     Codec = gobCodec{}
+	JSONCodec    Codec = jsonCodec{}
+	MsgPackCodec Codec = msgPackCodec{}
+	CBORCodec    Codec = cborCodec{}
+)
+
+// registry of codecs by name, content type and file extension
+var codecs = struct {
+	sync.RWMutex
+	names      map[string]Codec
+	types      map[string]Codec
+	extensions map[string]Codec
+}{names: make(map[string]Codec), types: make(map[string]Codec), extensions: make(map[string]Codec)}
+
+// register the built in codecs
+func init() {
+	RegisterCodec(GobCodec, ".gob")
+	RegisterCodec(JSONCodec, ".json")
+	RegisterCodec(MsgPackCodec, ".msgpack", ".mpk")
+	RegisterCodec(CBORCodec, ".cbor")
+	codecs.types["application/x-msgpack"] = MsgPackCodec
+}
+
+// RegisterCodec adds or replaces a codec by its name and content type and
+// uses it for files with extensions such as ".json"
+func RegisterCodec(codec Codec, extensions ...string) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.names[strings.ToLower(codec.Name())] = codec
+	codecs.types[strings.ToLower(codec.ContentType())] = codec
+	for _, extension := range extensions {
+		codecs.extensions[strings.ToLower(extension)] = codec
+	}
+}
+
+// CodecByName returns the codec registered as name, such as "json"
+func CodecByName(name string) (Codec, error) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	codec, found := codecs.names[strings.ToLower(name)]
+	if !found {
+		return nil, l.Fail(l.ErrInvalidArg, fmt.Sprintf("no codec named '%s'", name))
+	}
+	return codec, nil
+}
+
+// CodecByContentType returns the codec for a mime type; parameters such as
+// "; charset=utf-8" are ignored
+func CodecByContentType(contentType string) (Codec, error) {
+	t, _, err := mime.ParseMediaType(contentType)
+	if nil != err {
+		t = strings.TrimSpace(contentType)
+	}
+	codecs.RLock()
+	defer codecs.RUnlock()
+	codec, found := codecs.types[strings.ToLower(t)]
+	if !found {
+		return nil, l.Fail(l.ErrInvalidArg, fmt.Sprintf("no codec for content type '%s'", contentType))
+	}
+	return codec, nil
+}
+
+// CodecForPath returns the codec for the extension of path
+func CodecForPath(path string) (Codec, error) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	codec, found := codecs.extensions[strings.ToLower(filepath.Ext(path))]
+	if !found {
+		return nil, l.Fail(l.ErrInvalidArg, fmt.Sprintf("no codec for file '%s'", path))
+	}
+	return codec, nil
+}
+
+// LoadObject reads object from path with the codec picked by its extension
+func LoadObject(path string, object interface{}) error {
+	codec, err := CodecForPath(path)
+	if l.Check(err) {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if nil != err {
+		return l.Fail(err)
+	}
+	err = codec.Unmarshal(data, object)
+	if nil != err {
+		return l.Fail(err)
+	}
+	return nil
+}
+
+// SaveObject writes object to path with the codec picked by its extension
+func SaveObject(path string, object interface{}) error {
+	codec, err := CodecForPath(path)
+	if l.Check(err) {
+		return err
+	}
+	data, err := codec.Marshal(object)
+	if nil != err {
+		return l.Fail(err)
+	}
+	return WriteFile(path, data)
+}
+
+// gobCodec is golang gob
+type gobCodec struct{}
+
+func (gobCodec) Name() string        { return "gob" }
+func (gobCodec) ContentType() string { return "application/x-gob" }
+
+func (gobCodec) Marshal(object interface{}) ([]byte, error) {
+	return GobEncode(object)
+}
+
+func (gobCodec) Unmarshal(data []byte, object interface{}) error {
+	return GobDecode(data, object)
+}
+
+func (gobCodec) NewEncoder(w io.Writer) Encoder {
+	return gob.NewEncoder(w)
+}
+
+func (gobCodec) NewDecoder(r io.Reader) Decoder {
+	return gob.NewDecoder(r)
+}
+
+// jsonCodec is json; the encoder writes a line per object
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string        { return "json" }
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Marshal(object interface{}) ([]byte, error) {
+	return json.Marshal(object)
+}
+
+func (jsonCodec) Unmarshal(data []byte, object interface{}) error {
+	return json.Unmarshal(data, object)
+}
+
+func (jsonCodec) NewEncoder(w io.Writer) Encoder {
+	return json.NewEncoder(w)
+}
+
+func (jsonCodec) NewDecoder(r io.Reader) Decoder {
+	return json.NewDecoder(r)
+}
+
+// msgPackCodec is messagepack
+type msgPackCodec struct{}
+
+func (msgPackCodec) Name() string        { return "msgpack" }
+func (msgPackCodec) ContentType() string { return "application/msgpack" }
+
+func (msgPackCodec) Marshal(object interface{}) ([]byte, error) {
+	return msgpack.Marshal(object)
+}
+
+func (msgPackCodec) Unmarshal(data []byte, object interface{}) error {
+	return msgpack.Unmarshal(data, object)
+}
+
+func (msgPackCodec) NewEncoder(w io.Writer) Encoder {
+	return msgpack.NewEncoder(w)
+}
+
+func (msgPackCodec) NewDecoder(r io.Reader) Decoder {
+	return msgpack.NewDecoder(r)
+}
+
+// cborCodec is cbor, rfc 8949
+type cborCodec struct{}
+
+func (cborCodec) Name() string        { return "cbor" }
+func (cborCodec) ContentType() string { return "application/cbor" }
+
+func (cborCodec) Marshal(object interface{}) ([]byte, error) {
+	return cbor.Marshal(object)
+}
+
+func (cborCodec) Unmarshal(data []byte, object interface{}) error {
+	return cbor.Unmarshal(data, object)
+}
+
+func (cborCodec) NewEncoder(w io.Writer) Encoder {
+	return cbor.NewEncoder(w)
+}
+
+func (cborCodec) NewDecoder(r io.Reader) Decoder {
+	return cbor.NewDecoder(r)
+}
@@ -0,0 +1,320 @@
+package utl
+
+import (
+	"encoding"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	l "github.com/stevenb256/log"
+)
+
+// ConfigOptions controls where LoadConfig reads values from
+type ConfigOptions struct {
+	Files     []string     // json, yaml or toml files merged in order; files after the first may be missing
+	EnvPrefix string       // APP reads field "database.host" from APP_DATABASE_HOST; no env when empty
+	JSON      *JSONOptions // checks for the files, such as StrictJSON
+}
+
+// ConfigError is a value that could not be set or failed validation
+type ConfigError struct {
+	Path string // json path of the field such as "database.port"
+	Err  error
+}
+
+// Error returns the error text
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Err.Error())
+}
+
+// Unwrap returns the underlying error
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigErrors is every problem found loading a config
+type ConfigErrors []*ConfigError
+
+// Error returns the error text
+func (e ConfigErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// textUnmarshalerType is a struct that parses itself from text
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// configField is a field found walking a struct type
+type configField struct {
+	field reflect.StructField
+	index []int // field numbers from the top struct, through any pointers
+	path  []string
+}
+
+// LoadConfig fills object, a pointer to a struct, from `default:"..."` tags,
+// then each file, then environment variables, then checks `validate:"..."`
+// tags; returns ConfigErrors listing every problem
+func LoadConfig(object interface{}, options *ConfigOptions) error {
+
+	// must be pointer to struct
+	if !isStructPtr(object) {
+		return l.Fail(l.ErrInvalidArg, "LoadConfig needs a pointer to a struct")
+	}
+	v := reflect.ValueOf(object)
+	o := ConfigOptions{}
+	if nil != options {
+		o = *options
+	}
+
+	// defaults
+	var errs ConfigErrors
+	if err := ApplyDefaults(object); nil != err {
+		errs = append(errs, err.(ConfigErrors)...)
+	}
+
+	// files merge into each other
+	for i, path := range o.Files {
+		if i > 0 && !DoesFileExist(path) {
+			continue
+		}
+		err := LoadConfigFile(path, object, o.JSON)
+		if nil != err {
+			errs = append(errs, &ConfigError{Path: path, Err: err})
+		}
+	}
+
+	// environment
+	if "" != o.EnvPrefix {
+		for _, f := range configFields(v.Elem().Type(), nil, nil, nil) {
+			name := ConfigEnvName(o.EnvPrefix, f.path)
+			if s, found := os.LookupEnv(name); found {
+				value, _ := f.value(v.Elem(), true)
+				if err := setConfigValue(value, s); nil != err {
+					errs = append(errs, &ConfigError{Path: strings.Join(f.path, "."), Err: fmt.Errorf("%s: %s", name, err.Error())})
+				}
+			}
+		}
+	}
+
+	// check it
+	if err := ValidateObject(object); nil != err {
+		errs = append(errs, err.(ConfigErrors)...)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	// done
+	return nil
+}
+
+// ConfigEnvName returns the environment variable for a field path
+func ConfigEnvName(prefix string, path []string) string {
+	name := strings.ToUpper(strings.Join(path, "_"))
+	if "" != prefix {
+		name = strings.ToUpper(prefix) + "_" + name
+	}
+	return strings.NewReplacer("-", "_", ".", "_").Replace(name)
+}
+
+// ApplyDefaults sets fields of object that are still zero from their
+// `default:"..."` tags; slices take comma separated values and sections held
+// by nil pointers are made only when a default is set inside them
+func ApplyDefaults(object interface{}) error {
+	if !isStructPtr(object) {
+		return l.Fail(l.ErrInvalidArg, "ApplyDefaults needs a pointer to a struct")
+	}
+	var errs ConfigErrors
+	v := reflect.ValueOf(object).Elem()
+	for _, f := range configFields(v.Type(), nil, nil, nil) {
+		def, found := f.field.Tag.Lookup("default")
+		if !found {
+			continue
+		}
+		if value, ok := f.value(v, false); ok && !value.IsZero() {
+			continue
+		}
+		value, _ := f.value(v, true)
+		err := setConfigValue(value, def)
+		if nil != err {
+			errs = append(errs, &ConfigError{Path: strings.Join(f.path, "."), Err: fmt.Errorf("default: %s", err.Error())})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ValidateObject checks `validate:"..."` tags of object, a pointer to a struct:
+// required, min=n, max=n and oneof=a|b; min and max are lengths for strings,
+// slices and maps; sections held by nil pointers are not checked; returns
+// ConfigErrors listing every failure
+func ValidateObject(object interface{}) error {
+	if !isStructPtr(object) {
+		return l.Fail(l.ErrInvalidArg, "ValidateObject needs a pointer to a struct")
+	}
+	var errs ConfigErrors
+	v := reflect.ValueOf(object).Elem()
+	for _, f := range configFields(v.Type(), nil, nil, nil) {
+		tag := f.field.Tag.Get("validate")
+		value, ok := f.value(v, false)
+		if "" == tag || !ok {
+			continue
+		}
+		for _, rule := range strings.Split(tag, ",") {
+			if err := validateRule(value, strings.TrimSpace(rule)); nil != err {
+				errs = append(errs, &ConfigError{Path: strings.Join(f.path, "."), Err: err})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateRule checks one rule against v
+func validateRule(v reflect.Value, rule string) error {
+
+	// locals
+	name, arg := rule, ""
+	if i := strings.Index(rule, "="); i >= 0 {
+		name, arg = rule[:i], rule[i+1:]
+	}
+
+	// check
+	switch name {
+	case "":
+		return nil
+	case "required":
+		if v.IsZero() {
+			return fmt.Errorf("is required")
+		}
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if nil != err {
+			return fmt.Errorf("bad %s rule '%s'", name, arg)
+		}
+		n, ok := validateSize(v)
+		if !ok {
+			return fmt.Errorf("%s can't check %s", name, v.Type().String())
+		}
+		if "min" == name && n < limit {
+			return fmt.Errorf("must be at least %s", arg)
+		}
+		if "max" == name && n > limit {
+			return fmt.Errorf("must be at most %s", arg)
+		}
+	case "oneof":
+		s := GetStringFromValue(v)
+		for _, choice := range strings.Split(arg, "|") {
+			if s == choice {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Replace(arg, "|", ", ", -1))
+	default:
+		return fmt.Errorf("unknown validate rule '%s'", name)
+	}
+	return nil
+}
+
+// validateSize returns the number min and max compare: the value of numbers
+// and the length of strings, slices and maps
+func validateSize(v reflect.Value) (float64, bool) {
+	for reflect.Ptr == v.Kind() {
+		if v.IsNil() {
+			return 0, true
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), true
+	}
+	return 0, false
+}
+
+// setConfigValue sets v from text; slices take comma separated values
+func setConfigValue(v reflect.Value, s string) error {
+	if reflect.Slice == v.Kind() && reflect.Uint8 != v.Type().Elem().Kind() {
+		parts := strings.Split(s, ",")
+		slice := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := SetValueFromString(slice.Index(i), part); nil != err {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+	return SetValueFromString(v, s)
+}
+
+// configFields returns the fields of struct type t named by their json path;
+// nested structs are walked rather than returned, stopping at a type already
+// on the path so types that refer to themselves end
+func configFields(t reflect.Type, index []int, path []string, types map[reflect.Type]bool) []configField {
+	var fields []configField
+	for reflect.Ptr == t.Kind() {
+		t = t.Elem()
+	}
+	if nil == types {
+		types = make(map[reflect.Type]bool)
+	}
+	types[t] = true
+	defer delete(types, t)
+	for _, f := range GetTaggedFields(t, "json") {
+		fieldIndex := append(append([]int{}, index...), f.Index...)
+		fieldPath := append(append([]string{}, path...), f.Name)
+		section := f.Type
+		for reflect.Ptr == section.Kind() {
+			section = section.Elem()
+		}
+		if reflect.Struct == section.Kind() && reflect.TypeOf(time.Time{}) != section && !reflect.PtrTo(section).Implements(textUnmarshalerType) {
+			if !types[section] {
+				fields = append(fields, configFields(section, fieldIndex, fieldPath, types)...)
+			}
+			continue
+		}
+		fields = append(fields, configField{field: t.FieldByIndex(f.Index), index: fieldIndex, path: fieldPath})
+	}
+	return fields
+}
+
+// value returns the field in struct v; sections held by nil pointers are
+// made when alloc is true, otherwise ok is false
+func (f *configField) value(v reflect.Value, alloc bool) (reflect.Value, bool) {
+	for _, x := range f.index {
+		for reflect.Ptr == v.Kind() {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isStructPtr returns true if object is a non nil pointer to a struct
+func isStructPtr(object interface{}) bool {
+	v := reflect.ValueOf(object)
+	return reflect.Ptr == v.Kind() && !v.IsNil() && reflect.Struct == v.Elem().Kind()
+}
@@ -0,0 +1,583 @@
+package utl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	l "github.com/stevenb256/log"
+	"gopkg.in/yaml.v3"
+)
+
+// config file formats picked by extension
+const (
+	ConfigJSON = "json" // .json, .jsonc and .json5; read as json5, written as json
+	ConfigYAML = "yaml" // .yaml and .yml
+	ConfigTOML = "toml" // .toml
+)
+
+// ConfigFormat returns the format of a config file from its extension
+func ConfigFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".jsonc", ".json5":
+		return ConfigJSON, nil
+	case ".yaml", ".yml":
+		return ConfigYAML, nil
+	case ".toml":
+		return ConfigTOML, nil
+	}
+	return "", l.Fail(l.ErrInvalidArg, fmt.Sprintf("unknown config format '%s'", path))
+}
+
+// LoadConfigFile reads a json, jsonc, json5, yaml or toml file into object
+// using its json tags; yaml and toml go through json so they decode the same
+func LoadConfigFile(path string, object interface{}, options *JSONOptions) error {
+
+	// read it
+	format, err := ConfigFormat(path)
+	if l.Check(err) {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if nil != err {
+		return l.Fail(err)
+	}
+
+	// json keeps its own error locations
+	if ConfigJSON == format {
+		err = decodeJSONC(data, object, options)
+		if nil != err {
+			return l.Fail(fmt.Errorf("%s: %w", path, err))
+		}
+		return nil
+	}
+
+	// others are read generically then decoded as json
+	var generic interface{}
+	if ConfigYAML == format {
+		err = yaml.Unmarshal(data, &generic)
+	} else {
+		var table map[string]interface{}
+		err = toml.Unmarshal(data, &table)
+		generic = table
+	}
+	if nil != err {
+		return l.Fail(fmt.Errorf("%s: %w", path, err))
+	}
+	data, err = json.Marshal(jsonGeneric(generic))
+	if nil != err {
+		return l.Fail(fmt.Errorf("%s: %w", path, err))
+	}
+	err = DecodeJSON(data, object, options)
+	var jsonErr *JSONError
+	if errors.As(err, &jsonErr) {
+		err = &ConfigError{Path: jsonErr.Path, Err: jsonErr.Err}
+	}
+	if nil != err {
+		return l.Fail(fmt.Errorf("%s: %w", path, err))
+	}
+
+	// done
+	return nil
+}
+
+// decodeJSONC decodes json5 text into object giving errors at the lines and
+// columns of the text; Infinity and NaN decode as zero and are set after
+func decodeJSONC(data []byte, object interface{}, options *JSONOptions) error {
+
+	// make it json
+	c, err := convertJSONC(data)
+	if nil != err {
+		return err
+	}
+	for _, f := range c.floats {
+		copy(c.out[f.offset:], "0"+strings.Repeat(" ", len(f.text)+1))
+	}
+
+	// decode it and set the numbers json can't hold
+	err = DecodeJSON(c.out, object, options)
+	for i := 0; nil == err && i < len(c.floats); i++ {
+		f := c.floats[i]
+		finder := newJSONWalker(c.out, &JSONOptions{}, int64(f.offset+1))
+		finder.walk(nil)
+		if !setJSONFloat(reflect.ValueOf(object), finder.stack, f.value) {
+			err = jsonErrorAt(c.out, int64(f.offset), finder.path(), fmt.Errorf("%s can only be read into a float", f.text))
+		}
+	}
+
+	// errors are found in the json so move them back to the text
+	var jsonErr *JSONError
+	if errors.As(err, &jsonErr) {
+		offset := len(c.out)
+		for i, line := 0, 1; i < len(c.out); i++ {
+			if line == jsonErr.Line {
+				offset = i + jsonErr.Column - 1
+				break
+			}
+			if '\n' == c.out[i] {
+				line++
+			}
+		}
+		return jsonErrorAt(data, int64(c.source(offset)), jsonErr.Path, jsonErr.Err)
+	}
+	return err
+}
+
+// setJSONFloat sets the float at path, as read by a jsonWalker, of v
+func setJSONFloat(v reflect.Value, path []string, f float64) bool {
+
+	// the end of the path
+	switch v.Kind() {
+	case reflect.Ptr:
+		return !v.IsNil() && setJSONFloat(v.Elem(), path, f)
+	case reflect.Float32, reflect.Float64:
+		if 0 != len(path) {
+			return false
+		}
+		v.SetFloat(f)
+		return true
+	case reflect.Interface:
+		if 0 == len(path) {
+			v.Set(reflect.ValueOf(f))
+			return true
+		}
+		if v.IsNil() {
+			return false
+		}
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		if !setJSONFloat(elem, path, f) {
+			return false
+		}
+		v.Set(elem)
+		return true
+	}
+	if 0 == len(path) {
+		return false
+	}
+
+	// or a step along it
+	key, index := path[0][1:], -1
+	if strings.HasPrefix(path[0], "[\"") {
+		key, _ = strconv.Unquote(path[0][1 : len(path[0])-1])
+	} else if strings.HasPrefix(path[0], "[") {
+		index = Atoi(path[0][1 : len(path[0])-1])
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		field := jsonField(v.Type(), key)
+		return -1 == index && nil != field && setJSONFloat(GetFieldValue(v, field.Index, true), path[1:], f)
+	case reflect.Map:
+		if -1 != index || reflect.String != v.Type().Key().Kind() {
+			return false
+		}
+		k := reflect.ValueOf(key).Convert(v.Type().Key())
+		elem := reflect.New(v.Type().Elem()).Elem()
+		elem.Set(v.MapIndex(k))
+		if !setJSONFloat(elem, path[1:], f) {
+			return false
+		}
+		v.SetMapIndex(k, elem)
+		return true
+	case reflect.Slice, reflect.Array:
+		return index >= 0 && index < v.Len() && setJSONFloat(v.Index(index), path[1:], f)
+	}
+	return false
+}
+
+// SaveConfigFile writes object to path in the format of its extension, with
+// fields in struct order for json and yaml; the file is written afresh so
+// comments in the one it replaces are lost, whatever its format, and jsonc
+// and json5 files are written as plain json
+func SaveConfigFile(path string, object interface{}) error {
+
+	// locals
+	format, err := ConfigFormat(path)
+	if l.Check(err) {
+		return err
+	}
+	if ConfigJSON == format {
+		return SaveJSONObject(path, object)
+	}
+	data, err := json.Marshal(object)
+	if nil != err {
+		return l.Fail(err)
+	}
+
+	// yaml keeps the order of the json
+	var buf bytes.Buffer
+	if ConfigYAML == format {
+		var node yaml.Node
+		err = yaml.Unmarshal(data, &node)
+		if nil != err {
+			return l.Fail(err)
+		}
+		blockStyle(&node)
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		err = encoder.Encode(&node)
+		if nil == err {
+			err = encoder.Close()
+		}
+		if nil != err {
+			return l.Fail(err)
+		}
+		return WriteFile(path, buf.Bytes())
+	}
+
+	// toml has no null
+	var generic interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&generic)
+	if nil != err {
+		return l.Fail(err)
+	}
+	err = toml.NewEncoder(&buf).Encode(dropNulls(tomlNumbers(generic)))
+	if nil != err {
+		return l.Fail(err)
+	}
+	return WriteFile(path, buf.Bytes())
+}
+
+// blockStyle clears the flow and quoting styles yaml read from json
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// jsonGeneric turns yaml maps with non string keys into maps json can write
+func jsonGeneric(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, value := range t {
+			t[k] = jsonGeneric(value)
+		}
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, value := range t {
+			m[fmt.Sprint(k)] = jsonGeneric(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range t {
+			t[i] = jsonGeneric(value)
+		}
+	}
+	return v
+}
+
+// tomlNumbers turns json numbers into ints or floats
+func tomlNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); nil == err {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]interface{}:
+		for k, value := range t {
+			t[k] = tomlNumbers(value)
+		}
+	case []interface{}:
+		for i, value := range t {
+			t[i] = tomlNumbers(value)
+		}
+	}
+	return v
+}
+
+// dropNulls removes null values from maps
+func dropNulls(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, value := range t {
+			if nil == value {
+				delete(t, k)
+			} else {
+				t[k] = dropNulls(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range t {
+			t[i] = dropNulls(value)
+		}
+	}
+	return v
+}
+
+// JSONCToJSON turns json with comments and json5 into json: comments and
+// trailing commas go, unquoted keys and single quoted strings are quoted,
+// json5 numbers are written as json numbers and multi-line strings are
+// joined; Infinity and NaN, which json has no numbers for, become strings.
+// Keys and numbers change length so offsets in the json differ from the
+// text; LoadConfigFile gives errors at the lines and columns of the file
+func JSONCToJSON(data []byte) ([]byte, error) {
+	c, err := convertJSONC(data)
+	if nil != err {
+		return nil, err
+	}
+	return c.out, nil
+}
+
+// jsoncText is json made from json5 and where each of its bytes came from
+type jsoncText struct {
+	out    []byte
+	from   []int
+	size   int
+	floats []jsoncFloat
+}
+
+// jsoncFloat is Infinity or NaN written as a string at offset of the json
+type jsoncFloat struct {
+	offset int
+	text   string
+	value  float64
+}
+
+// convertJSONC turns json5 into json; errors are *JSONError in data
+func convertJSONC(data []byte) (*jsoncText, error) {
+
+	// locals
+	c := &jsoncText{out: make([]byte, 0, len(data)), from: make([]int, 0, len(data)), size: len(data)}
+
+	// copy a byte at a time
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		switch {
+		case '"' == b || '\'' == b:
+			end, err := c.string(data, i)
+			if nil != err {
+				return nil, jsonErrorAt(data, int64(i), "", err)
+			}
+			i = end
+		case '/' == b && i+1 < len(data) && '/' == data[i+1]:
+			for ; i < len(data) && '\n' != data[i]; i++ {
+				c.write(i, ' ')
+			}
+			i--
+		case '/' == b && i+1 < len(data) && '*' == data[i+1]:
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, jsonErrorAt(data, int64(i), "", fmt.Errorf("comment is not closed"))
+			}
+			for j := i; j < i+2+end+2; j++ {
+				if '\n' == data[j] {
+					c.write(j, '\n')
+				} else {
+					c.write(j, ' ')
+				}
+			}
+			i += 2 + end + 1
+		case ',' == b && jsoncClosesNext(data, i+1):
+			c.write(i, ' ')
+		case isJSONCIdentStart(b) && jsoncIsKey(data, c.out, i):
+			end := i
+			for end < len(data) && isJSONCIdent(data[end]) {
+				end++
+			}
+			c.writeText(i, `"`+string(data[i:end])+`"`)
+			i = end - 1
+		case strings.IndexByte("+-.0123456789", b) >= 0 || jsoncIsWord(data, i, "Infinity") || jsoncIsWord(data, i, "NaN"):
+			end, err := c.number(data, i)
+			if nil != err {
+				return nil, jsonErrorAt(data, int64(i), "", err)
+			}
+			i = end - 1
+		default:
+			c.write(i, b)
+		}
+	}
+
+	// done
+	return c, nil
+}
+
+// write adds bytes that came from offset at of the json5
+func (c *jsoncText) write(at int, b ...byte) {
+	c.out = append(c.out, b...)
+	for range b {
+		c.from = append(c.from, at)
+	}
+}
+
+// writeText adds text that came from offset at of the json5
+func (c *jsoncText) writeText(at int, text string) {
+	c.write(at, []byte(text)...)
+}
+
+// source returns the offset in the json5 of offset in the json
+func (c *jsoncText) source(offset int) int {
+	if offset >= 0 && offset < len(c.from) {
+		return c.from[offset]
+	}
+	return c.size
+}
+
+// string writes the string starting at i as json and returns where it ends;
+// json5 escapes become json ones and an escaped line break is dropped
+func (c *jsoncText) string(data []byte, i int) (int, error) {
+	quote := data[i]
+	c.write(i, '"')
+	for j := i + 1; j < len(data); j++ {
+		b := data[j]
+		switch {
+		case '\\' == b && j+1 < len(data):
+			j++
+			switch e := data[j]; {
+			case '\n' == e:
+			case '\r' == e:
+				if j+1 < len(data) && '\n' == data[j+1] {
+					j++
+				}
+			case strings.IndexByte(`"\/bfnrtu`, e) >= 0:
+				c.write(j-1, '\\', e)
+			case 'v' == e:
+				c.writeText(j-1, `\u000b`)
+			case '0' == e:
+				c.writeText(j-1, `\u0000`)
+			case 'x' == e && j+2 < len(data):
+				c.writeText(j-1, `\u00`+string(data[j+1:j+3]))
+				j += 2
+			default:
+				c.write(j-1, e)
+			}
+		case quote == b:
+			c.write(j, '"')
+			return j, nil
+		case '"' == b:
+			c.write(j, '\\', '"')
+		case '\n' == b:
+			return 0, fmt.Errorf("string is not closed")
+		default:
+			c.write(j, b)
+		}
+	}
+	return 0, fmt.Errorf("string is not closed")
+}
+
+// number writes the json5 number at i as json and returns where it ends; hex
+// is written in decimal, a leading decimal point gets a zero and a trailing
+// one is dropped
+func (c *jsoncText) number(data []byte, i int) (int, error) {
+
+	// sign
+	j := i
+	negative := false
+	if '+' == data[j] || '-' == data[j] {
+		negative = '-' == data[j]
+		j++
+	}
+
+	// json has no Infinity or NaN so they are written as strings
+	if jsoncIsWord(data, j, "Infinity") || jsoncIsWord(data, j, "NaN") {
+		text, value := "NaN", math.NaN()
+		if 'I' == data[j] {
+			text, value = "Infinity", math.Inf(1)
+			if negative {
+				text, value = "-Infinity", math.Inf(-1)
+			}
+		}
+		c.floats = append(c.floats, jsoncFloat{offset: len(c.out), text: text, value: value})
+		c.writeText(i, strconv.Quote(text))
+		return j + len(strings.TrimPrefix(text, "-")), nil
+	}
+
+	// hex or decimal
+	var text string
+	end := j
+	if j+1 < len(data) && '0' == data[j] && ('x' == data[j+1] || 'X' == data[j+1]) {
+		for end = j + 2; end < len(data) && strings.IndexByte("0123456789abcdefABCDEF", data[end]) >= 0; end++ {
+		}
+		n, err := strconv.ParseUint(string(data[j+2:end]), 16, 64)
+		if nil != err {
+			return 0, fmt.Errorf("'%s' is not a number", data[i:end])
+		}
+		text = strconv.FormatUint(n, 10)
+	} else {
+		for end < len(data) && (strings.IndexByte("0123456789.eE", data[end]) >= 0 ||
+			strings.IndexByte("+-", data[end]) >= 0 && ('e' == data[end-1] || 'E' == data[end-1])) {
+			end++
+		}
+		text = string(data[j:end])
+		if strings.HasPrefix(text, ".") {
+			text = "0" + text
+		}
+		if dot := strings.IndexByte(text, '.'); dot >= 0 && (dot+1 == len(text) || text[dot+1] < '0' || text[dot+1] > '9') {
+			text = text[:dot] + text[dot+1:]
+		}
+	}
+	if negative {
+		text = "-" + text
+	}
+	c.writeText(i, text)
+	return end, nil
+}
+
+// jsoncIsWord returns true if word is at i and not part of a longer identifier
+func jsoncIsWord(data []byte, i int, word string) bool {
+	end := i + len(word)
+	return bytes.HasPrefix(data[i:], []byte(word)) && (end == len(data) || !isJSONCIdent(data[end]))
+}
+
+// jsoncClosesNext returns true if the next thing after i, skipping space and
+// comments, closes an object or array
+func jsoncClosesNext(data []byte, i int) bool {
+	for i < len(data) {
+		switch {
+		case ' ' == data[i] || '\t' == data[i] || '\r' == data[i] || '\n' == data[i]:
+			i++
+		case bytes.HasPrefix(data[i:], []byte("//")):
+			for i < len(data) && '\n' != data[i] {
+				i++
+			}
+		case bytes.HasPrefix(data[i:], []byte("/*")):
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return false
+			}
+			i += 2 + end + 2
+		default:
+			return '}' == data[i] || ']' == data[i]
+		}
+	}
+	return false
+}
+
+// jsoncIsKey returns true if the identifier at i is an unquoted key, one
+// that follows { or , in what has been written so far and comes before :
+func jsoncIsKey(data []byte, out []byte, i int) bool {
+	end := i
+	for end < len(data) && isJSONCIdent(data[end]) {
+		end++
+	}
+	for end < len(data) && strings.IndexByte(" \t\r\n", data[end]) >= 0 {
+		end++
+	}
+	if end >= len(data) || ':' != data[end] {
+		return false
+	}
+	before := bytes.TrimRight(out, " \t\r\n")
+	return 0 == len(before) || '{' == before[len(before)-1] || ',' == before[len(before)-1]
+}
+
+// isJSONCIdentStart returns true for bytes that start an unquoted key
+func isJSONCIdentStart(c byte) bool {
+	return '_' == c || '$' == c || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// isJSONCIdent returns true for bytes in an unquoted key
+func isJSONCIdent(c byte) bool {
+	return isJSONCIdentStart(c) || c >= '0' && c <= '9'
+}
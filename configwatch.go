@@ -0,0 +1,263 @@
+package utl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	l "github.com/stevenb256/log"
+)
+
+// ConfigWatchOptions controls how WatchConfig notices changes
+type ConfigWatchOptions struct {
+	ConfigOptions
+	Poll     bool          // poll files for changes instead of using file system events
+	Interval time.Duration // how often to poll, and the fallback when events aren't available; default 2s
+	Settle   time.Duration // wait after a change for more before reloading; default 100ms
+}
+
+// ConfigWatcher keeps a config loaded by LoadConfig up to date as its files change
+type ConfigWatcher struct {
+	t           reflect.Type
+	options     ConfigWatchOptions
+	current     atomic.Value
+	lock        sync.Mutex
+	reloading   sync.Mutex
+	subscribers []func(old interface{}, new interface{})
+	err         error
+	done        chan struct{}
+	closed      sync.Once
+	wait        sync.WaitGroup
+}
+
+// configState is what the watcher publishes
+type configState struct {
+	object interface{}
+}
+
+// WatchConfig loads a config like LoadConfig into a new value of the type
+// object points to and reloads it when its files change; the first load must
+// work, after that a bad file keeps the last good config
+func WatchConfig(object interface{}, options *ConfigWatchOptions) (*ConfigWatcher, error) {
+
+	// locals
+	if !isStructPtr(object) {
+		return nil, l.Fail(l.ErrInvalidArg, "WatchConfig needs a pointer to a struct")
+	}
+	w := &ConfigWatcher{t: reflect.TypeOf(object).Elem(), done: make(chan struct{})}
+	if nil != options {
+		w.options = *options
+	}
+	if 0 == w.options.Interval {
+		w.options.Interval = 2 * time.Second
+	}
+	if 0 == w.options.Settle {
+		w.options.Settle = 100 * time.Millisecond
+	}
+
+	// first load
+	err := w.Reload()
+	if l.Check(err) {
+		return nil, err
+	}
+
+	// watch with events or polling
+	var events *fsnotify.Watcher
+	if !w.options.Poll {
+		events, err = w.newEventWatcher()
+		if nil != err {
+			l.Debug("config watcher polling", err.Error())
+		}
+	}
+	w.wait.Add(1)
+	if nil != events {
+		go w.watchEvents(events)
+	} else {
+		go w.poll()
+	}
+
+	// done
+	return w, nil
+}
+
+// Get returns the current config, a pointer that must not be changed
+func (w *ConfigWatcher) Get() interface{} {
+	return w.current.Load().(*configState).object
+}
+
+// Err returns the error of the last reload or nil if it worked
+func (w *ConfigWatcher) Err() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.err
+}
+
+// Subscribe calls fn with the old and new config after every reload that works
+func (w *ConfigWatcher) Subscribe(fn func(old interface{}, new interface{})) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.subscribers = append(w.subscribers, fn)
+}
+
+// Reload loads the config now; on error the last good config is kept
+func (w *ConfigWatcher) Reload() error {
+
+	// one reload at a time so subscribers see changes in order
+	w.reloading.Lock()
+	defer w.reloading.Unlock()
+
+	// load a new value without holding up Err and Subscribe
+	object := reflect.New(w.t).Interface()
+	err := LoadConfig(object, &w.options.ConfigOptions)
+
+	// publish it
+	w.lock.Lock()
+	w.err = err
+	if nil != err {
+		w.lock.Unlock()
+		return err
+	}
+	var old interface{}
+	if state, ok := w.current.Load().(*configState); ok {
+		old = state.object
+	}
+	w.current.Store(&configState{object: object})
+	subscribers := append([]func(interface{}, interface{}){}, w.subscribers...)
+	w.lock.Unlock()
+
+	// tell subscribers
+	if nil != old {
+		for _, fn := range subscribers {
+			fn(old, object)
+		}
+	}
+
+	// done
+	return nil
+}
+
+// Close stops watching
+func (w *ConfigWatcher) Close() error {
+	w.closed.Do(func() {
+		close(w.done)
+	})
+	w.wait.Wait()
+	return nil
+}
+
+// newEventWatcher watches the directories of the files; editors and
+// WriteFile replace files rather than write to them, and kubernetes swaps a
+// "..data" link to a new directory that the files link through
+func (w *ConfigWatcher) newEventWatcher() (*fsnotify.Watcher, error) {
+	events, err := fsnotify.NewWatcher()
+	if nil != err {
+		return nil, err
+	}
+	dirs := make(map[string]bool)
+	for _, path := range w.options.Files {
+		dirs[filepath.Dir(path)] = true
+		if target, err := filepath.EvalSymlinks(path); nil == err {
+			dirs[filepath.Dir(target)] = true
+		}
+	}
+	for dir := range dirs {
+		err = events.Add(dir)
+		if nil != err {
+			events.Close()
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// watchEvents reloads when a file event touches one of the files
+func (w *ConfigWatcher) watchEvents(events *fsnotify.Watcher) {
+
+	// locals
+	defer w.wait.Done()
+	defer events.Close()
+	files := make(map[string]bool)
+	for _, path := range w.options.Files {
+		files[filepath.Clean(path)] = true
+		if target, err := filepath.EvalSymlinks(path); nil == err {
+			files[target] = true
+		}
+	}
+	targets := w.targets()
+	settle := time.NewTimer(time.Hour)
+	settle.Stop()
+
+	// wait for changes to settle then reload
+	for {
+		select {
+		case <-w.done:
+			settle.Stop()
+			return
+		case event, ok := <-events.Events:
+			if !ok {
+				return
+			}
+			if files[filepath.Clean(event.Name)] {
+				settle.Reset(w.options.Settle)
+			} else if now := w.targets(); now != targets {
+				targets = now
+				settle.Reset(w.options.Settle)
+			}
+		case err, ok := <-events.Errors:
+			if !ok {
+				return
+			}
+			l.Check(err)
+		case <-settle.C:
+			l.Check(w.Reload())
+		}
+	}
+}
+
+// poll reloads when the size or time of a file changes
+func (w *ConfigWatcher) poll() {
+	defer w.wait.Done()
+	ticker := time.NewTicker(w.options.Interval)
+	defer ticker.Stop()
+	last := w.stamp()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			if stamp := w.stamp(); stamp != last {
+				last = stamp
+				l.Check(w.Reload())
+			}
+		}
+	}
+}
+
+// stamp sums up where every file links to and its size and time
+func (w *ConfigWatcher) stamp() string {
+	stamp := w.targets()
+	for _, path := range w.options.Files {
+		if info, err := os.Stat(path); nil == err {
+			stamp += fmt.Sprintf("%d/%d", info.ModTime().UnixNano(), info.Size())
+		}
+		stamp += ";"
+	}
+	return stamp
+}
+
+// targets lists the files that the links of the files lead to
+func (w *ConfigWatcher) targets() string {
+	targets := ""
+	for _, path := range w.options.Files {
+		if target, err := filepath.EvalSymlinks(path); nil == err {
+			targets += target
+		}
+		targets += ";"
+	}
+	return targets
+}
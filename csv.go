@@ -0,0 +1,470 @@
+package utl
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	l "github.com/stevenb256/log"
+)
+
+// CSVOptions controls how csv is read and written
+type CSVOptions struct {
+	Delimiter   rune   // field delimiter; ',' when 0
+	Comment     rune   // lines starting with it are skipped when reading
+	AlwaysQuote bool   // quote every field when writing, not only those that need it
+	CRLF        bool   // end lines with \r\n when writing
+	TimeLayout  string // layout of time fields; RFC3339 when writing and TimeLayouts when reading if empty
+	NoHeader    bool   // rows have no header; fields are the columns in order
+}
+
+// CSVFieldError reports a value that could not be converted into a struct field
+type CSVFieldError struct {
+	Line   int // 1 based line number of the row
+	Column string
+	Value  string
+	Err    error
+}
+
+// Error returns the error text
+func (e *CSVFieldError) Error() string {
+	return fmt.Sprintf("line %d column '%s' value '%s': %s", e.Line, e.Column, e.Value, e.Err.Error())
+}
+
+// Unwrap returns the conversion error
+func (e *CSVFieldError) Unwrap() error {
+	return e.Err
+}
+
+// CSVErrors is a list of field errors found while decoding rows
+type CSVErrors []*CSVFieldError
+
+// Error returns the error text
+func (e CSVErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// CSVDecoder reads csv rows into structs with fields named by "csv" tags;
+// tag options "required" and "alias=a|b" work as they do for excel
+type CSVDecoder struct {
+	reader  *csv.Reader
+	options CSVOptions
+	header  []string
+	read    bool
+	t       reflect.Type
+	fields  []*TaggedField
+	columns []int
+}
+
+// CSVEncoder writes structs as csv rows with a header from their "csv" tags
+type CSVEncoder struct {
+	writer  *bufio.Writer
+	options CSVOptions
+	t       reflect.Type
+	fields  []*TaggedField
+}
+
+// csvFields returns the csv tagged fields of a struct type
+func csvFields(t reflect.Type) []*TaggedField {
+	return GetTaggedFields(t, "csv")
+}
+
+// NewCSVDecoder makes a decoder reading from reader
+func NewCSVDecoder(reader io.Reader, options *CSVOptions) *CSVDecoder {
+	d := &CSVDecoder{}
+	if nil != options {
+		d.options = *options
+	}
+	d.reader = csv.NewReader(reader)
+	if 0 != d.options.Delimiter {
+		d.reader.Comma = d.options.Delimiter
+	}
+	d.reader.Comment = d.options.Comment
+	d.reader.FieldsPerRecord = -1
+	d.reader.LazyQuotes = true
+	return d
+}
+
+// Header returns the header row; read with the first row
+func (d *CSVDecoder) Header() []string {
+	return d.header
+}
+
+// Decode reads the next row into object, a pointer to a struct; returns io.EOF
+// after the last row and CSVErrors if some fields could not be converted
+func (d *CSVDecoder) Decode(object interface{}) error {
+
+	// must be a pointer to a struct
+	v := reflect.ValueOf(object)
+	if reflect.Ptr != v.Kind() || v.IsNil() || reflect.Struct != v.Elem().Kind() {
+		return l.Fail(l.ErrInvalidArg, "Decode needs a pointer to a struct")
+	}
+	v = v.Elem()
+
+	// map columns to fields of this type
+	if v.Type() != d.t {
+		err := d.bind(v.Type())
+		if nil != err {
+			return err
+		}
+	}
+
+	// read the row
+	record, err := d.reader.Read()
+	if io.EOF == err {
+		return err
+	}
+	if l.Check(err) {
+		return l.Fail(fmt.Errorf("can't read csv: %s", err.Error()))
+	}
+	line, _ := d.reader.FieldPos(0)
+
+	// set each field
+	var errs CSVErrors
+	for i, f := range d.fields {
+		value := ""
+		if c := d.columns[i]; c >= 0 && c < len(record) {
+			value = record[c]
+		}
+		if "" == strings.TrimSpace(value) && f.HasOption("required") {
+			err = ErrRequiredValue
+		} else {
+			err = d.setValue(GetFieldValue(v, f.Index, true), value)
+		}
+		if nil != err {
+			errs = append(errs, &CSVFieldError{Line: line, Column: f.Name, Value: value, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	// done
+	return nil
+}
+
+// bind reads the header if not read yet and maps it to the fields of t
+func (d *CSVDecoder) bind(t reflect.Type) error {
+
+	// fields
+	fields := csvFields(t)
+	if 0 == len(fields) {
+		return l.Fail(l.ErrInvalidArg, "struct has no fields to decode")
+	}
+
+	// header
+	if !d.read && !d.options.NoHeader {
+		header, err := d.reader.Read()
+		if io.EOF == err {
+			return l.Fail(fmt.Errorf("csv has no header row"))
+		}
+		if l.Check(err) {
+			return l.Fail(fmt.Errorf("can't read csv header: %s", err.Error()))
+		}
+		if len(header) > 0 {
+			header[0] = strings.TrimPrefix(header[0], "\uFEFF")
+		}
+		d.header = header
+	}
+	d.read = true
+
+	// columns by position when there is no header
+	d.columns = make([]int, len(fields))
+	if d.options.NoHeader {
+		for i := range d.columns {
+			d.columns[i] = i
+		}
+		d.t, d.fields = t, fields
+		return nil
+	}
+
+	// otherwise by name
+	matcher := newHeaderMatcher(nil, &ExcelOptions{Columns: excelColumns(fields)})
+	found, missing := matcher.match(d.header)
+	if len(missing) > 0 {
+		return l.Fail(fmt.Errorf("csv must have column headers: %s", strings.Join(missing, ", ")))
+	}
+	for i, f := range fields {
+		c, ok := columnIndex(found, f.Name)
+		if !ok {
+			c = -1
+		}
+		d.columns[i] = c
+	}
+	d.t, d.fields = t, fields
+	return nil
+}
+
+// setValue converts text into a field using the time layout if there is one
+func (d *CSVDecoder) setValue(v reflect.Value, s string) error {
+	if "" != d.options.TimeLayout && "" != strings.TrimSpace(s) {
+		target := v
+		if reflect.Ptr == target.Kind() && reflect.TypeOf(time.Time{}) == target.Type().Elem() {
+			if target.IsNil() {
+				target.Set(reflect.New(target.Type().Elem()))
+			}
+			target = target.Elem()
+		}
+		if reflect.TypeOf(time.Time{}) == target.Type() {
+			t, err := time.Parse(d.options.TimeLayout, strings.TrimSpace(s))
+			if nil != err {
+				return err
+			}
+			target.Set(reflect.ValueOf(t))
+			return nil
+		}
+	}
+	return SetValueFromString(v, s)
+}
+
+// UnmarshalCSV reads every row of csv into slice, a pointer to a slice of
+// structs or struct pointers; rows that fail are kept and all errors returned as CSVErrors
+func UnmarshalCSV(reader io.Reader, slice interface{}, options *CSVOptions) error {
+
+	// must be pointer to slice
+	v := reflect.ValueOf(slice)
+	if reflect.Ptr != v.Kind() || !IsSlice(slice) {
+		return l.Fail(l.ErrInvalidArg, "UnmarshalCSV needs a pointer to a slice")
+	}
+	v = v.Elem()
+
+	// read rows
+	var errs CSVErrors
+	d := NewCSVDecoder(reader, options)
+	for {
+		n := v.Len()
+		v.Set(reflect.Append(v, reflect.Zero(GetSliceElementType(v))))
+		element := AllocateSliceElement(v, n)
+		if reflect.Ptr != element.Kind() {
+			element = element.Addr()
+		}
+		err := d.Decode(element.Interface())
+		if io.EOF == err {
+			v.Set(v.Slice(0, n))
+			break
+		}
+		if rowErrs, ok := err.(CSVErrors); ok {
+			errs = append(errs, rowErrs...)
+		} else if l.Check(err) {
+			v.Set(v.Slice(0, n))
+			return err
+		}
+	}
+
+	// any conversion errors
+	if len(errs) > 0 {
+		return errs
+	}
+
+	// done
+	return nil
+}
+
+// ScanCSV reads csv a row at a time into object, a pointer to a struct, and
+// calls fn after each row; stops at the first error from a row or from fn
+func ScanCSV(reader io.Reader, object interface{}, options *CSVOptions, fn func() error) error {
+	d := NewCSVDecoder(reader, options)
+	for {
+		err := d.Decode(object)
+		if io.EOF == err {
+			return nil
+		}
+		if nil != err {
+			return err
+		}
+		err = fn()
+		if nil != err {
+			return err
+		}
+	}
+}
+
+// StreamCSV sends a new element for each row of csv on channel, a chan of
+// structs or struct pointers, and closes it at the end; run it in a goroutine.
+// Stops at the first row that fails
+func StreamCSV(reader io.Reader, channel interface{}, options *CSVOptions) error {
+
+	// must be a channel we can send on
+	ch := reflect.ValueOf(channel)
+	if reflect.Chan != ch.Kind() || 0 == ch.Type().ChanDir()&reflect.SendDir {
+		return l.Fail(l.ErrInvalidArg, "StreamCSV needs a channel")
+	}
+	defer ch.Close()
+	t := ch.Type().Elem()
+	pointer := reflect.Ptr == t.Kind()
+	if pointer {
+		t = t.Elem()
+	}
+
+	// a new element per row
+	d := NewCSVDecoder(reader, options)
+	for {
+		element := reflect.New(t)
+		err := d.Decode(element.Interface())
+		if io.EOF == err {
+			return nil
+		}
+		if nil != err {
+			return err
+		}
+		if pointer {
+			ch.Send(element)
+		} else {
+			ch.Send(element.Elem())
+		}
+	}
+}
+
+// NewCSVEncoder makes an encoder writing to writer; call Flush when done
+func NewCSVEncoder(writer io.Writer, options *CSVOptions) *CSVEncoder {
+	e := &CSVEncoder{writer: bufio.NewWriter(writer)}
+	if nil != options {
+		e.options = *options
+	}
+	if 0 == e.options.Delimiter {
+		e.options.Delimiter = ','
+	}
+	return e
+}
+
+// Encode writes object, a struct or pointer to one, as a row; the header is
+// written before the first row
+func (e *CSVEncoder) Encode(object interface{}) error {
+	v := reflect.ValueOf(object)
+	for reflect.Ptr == v.Kind() && !v.IsNil() {
+		v = v.Elem()
+	}
+	if reflect.Struct != v.Kind() {
+		return l.Fail(l.ErrInvalidArg, "Encode needs a struct")
+	}
+	if nil == e.fields {
+		err := e.writeHeader(v.Type())
+		if nil != err {
+			return err
+		}
+	}
+	if v.Type() != e.t {
+		return l.Fail(l.ErrInvalidArg, "Encode needs structs of one type")
+	}
+	record := make([]string, len(e.fields))
+	for i, f := range e.fields {
+		record[i] = e.format(GetFieldValue(v, f.Index, false))
+	}
+	return e.writeRecord(record)
+}
+
+// writeHeader writes the names of the fields of t unless options say no header
+func (e *CSVEncoder) writeHeader(t reflect.Type) error {
+	for reflect.Ptr == t.Kind() {
+		t = t.Elem()
+	}
+	e.t, e.fields = t, csvFields(t)
+	if 0 == len(e.fields) {
+		return l.Fail(l.ErrInvalidArg, "struct has no fields to encode")
+	}
+	if e.options.NoHeader {
+		return nil
+	}
+	header := make([]string, len(e.fields))
+	for i, f := range e.fields {
+		header[i] = f.Name
+	}
+	return e.writeRecord(header)
+}
+
+// format converts a field to text using the time layout if there is one
+func (e *CSVEncoder) format(v reflect.Value) string {
+	if "" != e.options.TimeLayout {
+		for reflect.Ptr == v.Kind() && !v.IsNil() {
+			v = v.Elem()
+		}
+		if t, ok := v.Interface().(time.Time); ok {
+			if t.IsZero() {
+				return ""
+			}
+			return t.Format(e.options.TimeLayout)
+		}
+	}
+	return GetStringFromValue(v)
+}
+
+// writeRecord writes fields quoting those that need it
+func (e *CSVEncoder) writeRecord(record []string) error {
+	var b strings.Builder
+	for i, field := range record {
+		if i > 0 {
+			b.WriteRune(e.options.Delimiter)
+		}
+		if e.options.AlwaysQuote || e.needsQuote(field) {
+			b.WriteByte('"')
+			b.WriteString(strings.Replace(field, `"`, `""`, -1))
+			b.WriteByte('"')
+		} else {
+			b.WriteString(field)
+		}
+	}
+	if e.options.CRLF {
+		b.WriteString("\r\n")
+	} else {
+		b.WriteByte('\n')
+	}
+	_, err := e.writer.WriteString(b.String())
+	return err
+}
+
+// needsQuote returns true if field has a delimiter, quote, line break or leading space
+func (e *CSVEncoder) needsQuote(field string) bool {
+	if "" == field {
+		return false
+	}
+	return strings.ContainsRune(field, e.options.Delimiter) || strings.ContainsAny(field, "\"\r\n") ||
+		' ' == field[0] || '\t' == field[0]
+}
+
+// Flush writes any buffered rows
+func (e *CSVEncoder) Flush() error {
+	return e.writer.Flush()
+}
+
+// MarshalCSV writes slice, a slice of structs or struct pointers, as csv with a header row
+func MarshalCSV(writer io.Writer, slice interface{}, options *CSVOptions) error {
+
+	// must be a slice
+	if !IsSlice(slice) {
+		return l.Fail(l.ErrInvalidArg, "MarshalCSV needs a slice")
+	}
+	v := reflect.ValueOf(slice)
+	for reflect.Ptr == v.Kind() {
+		v = v.Elem()
+	}
+
+	// header even when there are no rows
+	e := NewCSVEncoder(writer, options)
+	err := e.writeHeader(GetSliceElementType(v))
+	if l.Check(err) {
+		return err
+	}
+
+	// a row per element
+	for i := 0; i < v.Len(); i++ {
+		element := v.Index(i)
+		if reflect.Ptr == element.Kind() && element.IsNil() {
+			continue
+		}
+		err = e.Encode(element.Interface())
+		if l.Check(err) {
+			return err
+		}
+	}
+
+	// done
+	return e.Flush()
+}
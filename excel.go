@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,86 +15,300 @@ import (
 
 // Excel used to hold an excel file and columns
 type Excel struct {
-	i       int
-	row     *xlsx.Row
-	sheet   *xlsx.Sheet
-	columns map[string]int
+	i        int
+	row      *xlsx.Row
+	sheet    *xlsx.Sheet
+	columns  map[string]int
+	header   []string
+	matcher  *headerMatcher
+	search   int
+	file     *xlsx.File
+	data     []byte
+	formulas *formulaEvaluator
+	dates    ExcelDates
+	sheets   []*xlsx.Sheet
+	s        int
+	err      error
+}
+
+// ExcelOptions used to choose which sheets OpenExcelWithOptions reads
+type ExcelOptions struct {
+	Sheet        string        // name of sheet to read; when empty SheetIndex is used
+	SheetIndex   int           // index of sheet to read
+	AllSheets    bool          // read every sheet in sequence with the same columns
+	Columns      []ExcelColumn // aliases and optional columns on top of the column names
+	HeaderRows   int           // look for the header in this many rows; 0 means first row only
+	FuzzyHeaders bool          // match abbreviated or misspelled header text
+	Evaluate     bool          // compute formula cells saved without a cached value
+	Dates        ExcelDates    // how text dates are parsed and the zone dates are given
 }
 
 // OpenExcel opens an excel file
 func OpenExcel(reader io.Reader, columns []string) (*Excel, error) {
+	return OpenExcelWithOptions(reader, columns, nil)
+}
+
+// OpenExcelSheet opens a sheet of an excel file by name
+func OpenExcelSheet(reader io.Reader, sheet string, columns []string) (*Excel, error) {
+	return OpenExcelWithOptions(reader, columns, &ExcelOptions{Sheet: sheet})
+}
+
+// OpenExcelAllSheets opens an excel file and reads every sheet in sequence
+func OpenExcelAllSheets(reader io.Reader, columns []string) (*Excel, error) {
+	return OpenExcelWithOptions(reader, columns, &ExcelOptions{AllSheets: true})
+}
+
+// OpenExcelWithOptions opens an excel file and reads the sheets picked by options
+func OpenExcelWithOptions(reader io.Reader, columns []string, options *ExcelOptions) (*Excel, error) {
 
 	// locals
 	var excel Excel
 
+	// default options
+	if nil == options {
+		options = &ExcelOptions{}
+	}
+
+	// open the file
+	file, data, err := openExcelFile(reader)
+	if l.Check(err) {
+		return nil, err
+	}
+
+	// pick the sheets
+	excel.file = file
+	excel.data = data
+	excel.dates = options.Dates
+	excel.matcher = newHeaderMatcher(columns, options)
+	excel.search = options.HeaderRows
+	excel.sheets, err = selectSheets(file, options)
+	if l.Check(err) {
+		return nil, err
+	}
+
+	// fill in formulas with no cached value
+	if options.Evaluate {
+		excel.evaluateFormulas()
+	}
+
+	// skip leading empty sheets when reading them all
+	first := 0
+	for options.AllSheets && first < len(excel.sheets)-1 && len(excel.sheets[first].Rows) == 0 {
+		first++
+	}
+
+	// load header of first sheet
+	err = excel.loadSheet(first)
+	if l.Check(err) {
+		return nil, err
+	}
+
+	// done
+	return &excel, nil
+}
+
+// ExcelSheetNames returns the names of all sheets in an excel file
+func ExcelSheetNames(reader io.Reader) ([]string, error) {
+	file, _, err := openExcelFile(reader)
+	if l.Check(err) {
+		return nil, err
+	}
+	return sheetNames(file), nil
+}
+
+// openExcelFile reads the whole body and opens it as an excel file; the raw
+// bytes are returned for parts the xlsx package doesn't read
+func openExcelFile(reader io.Reader) (*xlsx.File, []byte, error) {
+
 	// read the whole body
 	data, err := ioutil.ReadAll(reader)
 	if l.Check(err) {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// open the binary
 	file, err := xlsx.OpenBinary(data)
 	if l.Check(err) {
-		return nil, l.Fail(fmt.Errorf("can't OpenBinary on excel file: %s", err.Error()))
+		return nil, nil, l.Fail(fmt.Errorf("can't OpenBinary on excel file: %s", err.Error()))
 	}
 
 	// if no sheets
 	if len(file.Sheets) == 0 {
-		return nil, l.Fail(errors.New("no sheets in excel file"))
+		return nil, nil, l.Fail(errors.New("no sheets in excel file"))
 	}
 
-	// get first sheet
-	excel.sheet = file.Sheets[0]
+	// done
+	return file, data, nil
+}
 
-	// if no rows
-	if len(excel.sheet.Rows) == 0 {
-		return nil, l.Fail(errors.New("no rows in sheet"))
+// sheetNames returns names of sheets in a file in workbook order
+func sheetNames(file *xlsx.File) []string {
+	names := make([]string, len(file.Sheets))
+	for i, sheet := range file.Sheets {
+		names[i] = sheet.Name
+	}
+	return names
+}
+
+// selectSheets returns the sheets of file picked by options
+func selectSheets(file *xlsx.File, options *ExcelOptions) ([]*xlsx.Sheet, error) {
+	if options.AllSheets {
+		return file.Sheets, nil
 	}
+	if "" != options.Sheet {
+		for _, sheet := range file.Sheets {
+			if strings.EqualFold(options.Sheet, sheet.Name) {
+				return []*xlsx.Sheet{sheet}, nil
+			}
+		}
+		return nil, l.Fail(fmt.Errorf("no sheet named '%s' in excel file", options.Sheet))
+	}
+	if options.SheetIndex < 0 || options.SheetIndex >= len(file.Sheets) {
+		return nil, l.Fail(fmt.Errorf("sheet index %d out of range; excel file has %d sheets", options.SheetIndex, len(file.Sheets)))
+	}
+	return []*xlsx.Sheet{file.Sheets[options.SheetIndex]}, nil
+}
+
+// loadSheet makes sheet s current and maps columns from its header row
+func (e *Excel) loadSheet(s int) error {
 
-	// get row
-	excel.row = excel.sheet.Rows[0]
+	// set sheet
+	e.s = s
+	e.sheet = e.sheets[s]
 
 	// if no rows
-	if nil == excel.row {
-		return nil, l.Fail(errors.New("sheet has no rows or no header row"))
+	if len(e.sheet.Rows) == 0 {
+		return l.Fail(fmt.Errorf("no rows in sheet '%s'", e.sheet.Name))
 	}
 
+	// titles of the rows that might be the header
+	var rows [][]string
+	for i := 0; i < len(e.sheet.Rows) && (0 == i || i < e.search); i++ {
+		var titles []string
+		if row := e.sheet.Rows[i]; nil != row {
+			for j := 0; j < row.Sheet.MaxCol && j < len(row.Cells); j++ {
+				titles = append(titles, row.Cells[j].Value)
+			}
+		}
+		rows = append(rows, titles)
+	}
+	fillMergedTitles(e.sheet, rows)
+
 	// make column map
-	excel.columns = make(map[string]int)
-
-	// find columns
-	for _, name := range columns {
-		for i := 0; i < excel.row.Sheet.MaxCol; i++ {
-			if i < len(excel.row.Cells) {
-				if name == strings.ToLower(excel.row.Cells[i].Value) {
-					excel.columns[name] = i
-					break
+	var missing []string
+	e.i, e.columns, missing = e.matcher.findHeader(rows)
+	e.header = rows[e.i]
+	e.row = e.sheet.Rows[e.i]
+
+	// if no header row
+	if nil == e.row {
+		return l.Fail(fmt.Errorf("sheet '%s' has no rows or no header row", e.sheet.Name))
+	}
+
+	// missing a required column?
+	if len(missing) > 0 {
+		return l.Fail(fmt.Errorf("spreadsheet '%s' must have column headers: %s", e.sheet.Name, strings.Join(missing, ", ")))
+	}
+
+	// done
+	return nil
+}
+
+// fillMergedTitles copies the text of merged cells across the cells they cover
+// so a header title spanning columns or rows names each of them
+func fillMergedTitles(sheet *xlsx.Sheet, rows [][]string) {
+	for i := range rows {
+		row := sheet.Rows[i]
+		if nil == row {
+			continue
+		}
+		for j, cell := range row.Cells {
+			if j >= len(rows[i]) || "" == rows[i][j] || (0 == cell.HMerge && 0 == cell.VMerge) {
+				continue
+			}
+			for y := i; y <= i+cell.VMerge && y < len(rows); y++ {
+				for x := j; x <= j+cell.HMerge; x++ {
+					for len(rows[y]) <= x {
+						rows[y] = append(rows[y], "")
+					}
+					if "" == rows[y][x] {
+						rows[y][x] = rows[i][j]
+					}
 				}
 			}
 		}
 	}
+}
+
+// SheetNames returns names of all sheets in the workbook
+func (e *Excel) SheetNames() []string {
+	return sheetNames(e.file)
+}
+
+// SheetName returns name of the sheet currently being read
+func (e *Excel) SheetName() string {
+	return e.sheet.Name
+}
+
+// Row returns the 1 based row number of the current row
+func (e *Excel) Row() int {
+	return e.i + 1
+}
 
-	// not the same length?
-	if len(excel.columns) != len(columns) {
-		return nil, l.Fail(fmt.Errorf("spreadsheet must have column headers: %s", strings.Join(columns, ", ")))
+// Header returns the titles of the header row of the current sheet
+func (e *Excel) Header() []string {
+	return e.header
+}
+
+// Values returns the values of the current row under the header
+func (e *Excel) Values() []string {
+	values := make([]string, len(e.header))
+	for i := range values {
+		if nil != e.row && i < len(e.row.Cells) {
+			values[i] = e.row.Cells[i].Value
+		}
 	}
+	return values
+}
 
-	// done
-	return &excel, nil
+// Err returns error that stopped reading a later sheet, if any
+func (e *Excel) Err() error {
+	return e.err
 }
 
 // IsDone returns true if no more rows
 func (e *Excel) IsDone() bool {
-	if nil != e.row {
-		e.i++
-		if e.i >= len(e.sheet.Rows) {
-			e.row = nil
-		} else {
-			e.row = e.sheet.Rows[e.i]
+	for {
+		if nil != e.row {
+			e.i++
+			if e.i >= len(e.sheet.Rows) {
+				e.row = nil
+			} else {
+				e.row = e.sheet.Rows[e.i]
+			}
+		}
+		if nil != e.row && e.row.Sheet.MaxCol != 0 {
+			return false
+		}
+
+		// move on to next sheet with rows
+		if !e.nextSheet() {
+			return true
 		}
 	}
-	if nil == e.row || e.row.Sheet.MaxCol == 0 {
+}
+
+// nextSheet loads the next sheet that has rows; false if none left
+func (e *Excel) nextSheet() bool {
+	for s := e.s + 1; s < len(e.sheets); s++ {
+		if len(e.sheets[s].Rows) == 0 {
+			continue
+		}
+		e.err = e.loadSheet(s)
+		if l.Check(e.err) {
+			e.row = nil
+			return false
+		}
 		return true
 	}
 	return false
@@ -101,7 +316,7 @@ func (e *Excel) IsDone() bool {
 
 // getIndex returns index of column name or -1 if index is not valid
 func (e *Excel) getIndex(name string) int {
-	i, found := e.columns[name]
+	i, found := columnIndex(e.columns, name)
 	if !found {
 		return -1
 	}
@@ -111,6 +326,15 @@ func (e *Excel) getIndex(name string) int {
 	return i
 }
 
+// cell returns cell of current row/column or nil if not present
+func (e *Excel) cell(name string) *xlsx.Cell {
+	i := e.getIndex(name)
+	if -1 == i || i >= len(e.row.Cells) {
+		return nil
+	}
+	return e.row.Cells[i]
+}
+
 // String gets current row/column as string
 func (e *Excel) String(name string) string {
 	i := e.getIndex(name)
@@ -129,12 +353,25 @@ func (e *Excel) Int(name string) int {
 	return Atoi(e.row.Cells[i].Value)
 }
 
-// Date gets current row column as date
+// Date gets current row column as date; numeric cells are serial dates in the
+// date system of the workbook and text is parsed as set by ExcelOptions.Dates
 func (e *Excel) Date(name string) (time.Time, error) {
-	i := e.getIndex(name)
-	if -1 == i || i >= len(e.row.Cells) {
+	cell := e.cell(name)
+	if nil == cell || "" == strings.TrimSpace(cell.Value) {
 		var t time.Time
 		return t, nil
 	}
-	return e.row.Cells[i].GetTime(false)
+	return e.cellTime(cell)
+}
+
+// cellTime reads a cell holding a serial date or a date as text
+func (e *Excel) cellTime(cell *xlsx.Cell) (time.Time, error) {
+	if xlsx.CellTypeNumeric == cell.Type() || xlsx.CellTypeDate == cell.Type() {
+		f, err := strconv.ParseFloat(cell.Value, 64)
+		if nil != err {
+			return time.Time{}, fmt.Errorf("'%s' is not a date", cell.Value)
+		}
+		return e.dates.fromSerial(f, e.file.Date1904), nil
+	}
+	return e.dates.parse(cell.Value)
 }
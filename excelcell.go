@@ -0,0 +1,266 @@
+package utl
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	l "github.com/stevenb256/log"
+	"github.com/tealeg/xlsx"
+)
+
+// ErrMissingColumn column is not in the header of the sheet
+var ErrMissingColumn = l.NewError(201, "excel", "column is not in the sheet")
+
+// ErrEmptyCell cell has no value
+var ErrEmptyCell = l.NewError(202, "excel", "cell is empty")
+
+// ErrNotWholeNumber number has a fraction where an integer was expected
+var ErrNotWholeNumber = l.NewError(203, "excel", "not a whole number")
+
+// Has returns true if column name was found in the header
+func (e *Excel) Has(name string) bool {
+	_, found := columnIndex(e.columns, name)
+	return found
+}
+
+// IsEmpty returns true if the current row has no value for column name
+func (e *Excel) IsEmpty(name string) bool {
+	cell := e.cell(name)
+	return nil == cell || "" == strings.TrimSpace(cell.Value)
+}
+
+// Text gets current row/column as string or ErrMissingColumn/ErrEmptyCell
+func (e *Excel) Text(name string) (string, error) {
+	cell, err := e.typedCell(name)
+	if nil != err {
+		return "", err
+	}
+	return cell.Value, nil
+}
+
+// Formatted gets current row/column as text formatted by the cell's number format
+func (e *Excel) Formatted(name string) (string, error) {
+	cell, err := e.typedCell(name)
+	if nil != err {
+		return "", err
+	}
+	text, err := cell.FormattedValue()
+	if nil != err {
+		return cell.Value, e.cellError(name, cell, err)
+	}
+	return text, nil
+}
+
+// Int64 gets current row/column as int64; numbers with a fraction are an error
+func (e *Excel) Int64(name string) (int64, error) {
+	cell, err := e.typedCell(name)
+	if nil != err {
+		return 0, err
+	}
+	f, err := parseNumber(cell.Value)
+	if nil != err {
+		return 0, e.cellError(name, cell, err)
+	}
+	if f != math.Trunc(f) || f >= 1<<63 || f < -1<<63 {
+		return 0, e.cellError(name, cell, ErrNotWholeNumber)
+	}
+	return int64(f), nil
+}
+
+// Float64 gets current row/column as float64
+func (e *Excel) Float64(name string) (float64, error) {
+	cell, err := e.typedCell(name)
+	if nil != err {
+		return 0, err
+	}
+	f, err := parseNumber(cell.Value)
+	if nil != err {
+		return 0, e.cellError(name, cell, err)
+	}
+	return f, nil
+}
+
+// Bool gets current row/column as bool; takes TRUE/FALSE cells, 1/0, yes/no
+func (e *Excel) Bool(name string) (bool, error) {
+	cell, err := e.typedCell(name)
+	if nil != err {
+		return false, err
+	}
+	b, err := ParseBool(cell.Value)
+	if nil != err {
+		return false, e.cellError(name, cell, err)
+	}
+	return b, nil
+}
+
+// Percent gets current row/column as a fraction, so 15% is 0.15; numeric
+// cells must have a percent number format and text must end in %; use
+// Float64 for plain numbers
+func (e *Excel) Percent(name string) (float64, error) {
+	cell, err := e.typedCell(name)
+	if nil != err {
+		return 0, err
+	}
+	if xlsx.CellTypeNumeric == cell.Type() {
+		if !isPercentFormat(cell.NumFmt) {
+			return 0, e.cellError(name, cell, fmt.Errorf("'%s' is not formatted as a percent", cell.Value))
+		}
+		f, err := strconv.ParseFloat(cell.Value, 64)
+		if nil != err {
+			return 0, e.cellError(name, cell, err)
+		}
+		return f, nil
+	}
+	value := strings.TrimSpace(cell.Value)
+	if !strings.HasSuffix(value, "%") {
+		return 0, e.cellError(name, cell, fmt.Errorf("'%s' is not a percent", value))
+	}
+	f, err := parseNumber(strings.TrimSuffix(value, "%"))
+	if nil != err {
+		return 0, e.cellError(name, cell, err)
+	}
+	return f / 100, nil
+}
+
+// Duration gets current row/column as a duration; numeric cells must have a
+// time number format, as excel stores times as fractions of a day, and text
+// may be "1h30m" or "1:30:00"
+func (e *Excel) Duration(name string) (time.Duration, error) {
+	cell, err := e.typedCell(name)
+	if nil != err {
+		return 0, err
+	}
+	if xlsx.CellTypeNumeric == cell.Type() {
+		if !isTimeFormat(cell.NumFmt) {
+			return 0, e.cellError(name, cell, fmt.Errorf("'%s' is not formatted as a time", cell.Value))
+		}
+		f, err := strconv.ParseFloat(cell.Value, 64)
+		if nil != err {
+			return 0, e.cellError(name, cell, err)
+		}
+		return time.Duration(math.Round(f * float64(24*time.Hour))), nil
+	}
+	d, err := parseDuration(cell.Value)
+	if nil != err {
+		return 0, e.cellError(name, cell, err)
+	}
+	return d, nil
+}
+
+// Time gets current row/column as a time; numeric cells are excel serial
+// dates and text is parsed as set by ExcelOptions.Dates
+func (e *Excel) Time(name string) (time.Time, error) {
+	cell, err := e.typedCell(name)
+	if nil != err {
+		return time.Time{}, err
+	}
+	t, err := e.cellTime(cell)
+	if nil != err {
+		return time.Time{}, e.cellError(name, cell, err)
+	}
+	return t, nil
+}
+
+// typedCell returns cell for column name or ErrMissingColumn/ErrEmptyCell
+func (e *Excel) typedCell(name string) (*xlsx.Cell, error) {
+	if !e.Has(name) {
+		return nil, e.cellError(name, nil, ErrMissingColumn)
+	}
+	cell := e.cell(name)
+	if nil == cell || "" == strings.TrimSpace(cell.Value) {
+		return nil, e.cellError(name, cell, ErrEmptyCell)
+	}
+	return cell, nil
+}
+
+// cellError makes an error with the coordinates of a cell of the current row
+func (e *Excel) cellError(name string, cell *xlsx.Cell, err error) *ExcelCellError {
+	value := ""
+	if nil != cell {
+		value = cell.Value
+	}
+	index, found := columnIndex(e.columns, name)
+	if !found {
+		index = -1
+	}
+	return &ExcelCellError{Sheet: e.sheet.Name, Row: e.Row(), Column: name, Index: index, Value: value, Err: err}
+}
+
+// formatCodes returns the lower cased codes of a number format without its
+// quoted text, escaped characters and colors
+func formatCodes(format string) string {
+	var b strings.Builder
+	quoted, bracket := false, false
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		switch {
+		case '"' == c:
+			quoted = !quoted
+		case quoted:
+		case '\\' == c:
+			i++
+		case '[' == c:
+			bracket = true
+			b.WriteByte(c)
+		case ']' == c:
+			bracket = false
+			b.WriteByte(c)
+		case bracket && !strings.ContainsRune("hmsHMS", rune(c)):
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return strings.ToLower(strings.Replace(b.String(), "[]", "", -1))
+}
+
+// isPercentFormat returns true for number formats that show a percent
+func isPercentFormat(format string) bool {
+	return strings.Contains(formatCodes(format), "%")
+}
+
+// isTimeFormat returns true for number formats that show hours or seconds
+func isTimeFormat(format string) bool {
+	return strings.ContainsAny(formatCodes(format), "hs")
+}
+
+// parseNumber parses a number allowing thousands separators and currency signs
+func parseNumber(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+	s = strings.Trim(s, "()")
+	s = strings.TrimLeft(s, "$€£¥")
+	s = strings.Replace(s, ",", "", -1)
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if nil != err {
+		return 0, fmt.Errorf("'%s' is not a number", s)
+	}
+	if negative {
+		f = -f
+	}
+	return f, nil
+}
+
+// parseDuration parses go durations like "1h30m" or clock durations like "1:30" and "1:30:15"
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); nil == err {
+		return d, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("'%s' is not a duration", s)
+	}
+	var d time.Duration
+	units := []time.Duration{time.Hour, time.Minute, time.Second}
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(part, 64)
+		if nil != err || f < 0 {
+			return 0, fmt.Errorf("'%s' is not a duration", s)
+		}
+		d += time.Duration(f * float64(units[i]))
+	}
+	return d, nil
+}
@@ -0,0 +1,143 @@
+package utl
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// ExcelDates controls how dates are read from sheets
+type ExcelDates struct {
+	Layouts  []string       // layouts tried first for dates stored as text
+	DayFirst bool           // read "03/04/2021" as 3 April rather than March 4
+	Location *time.Location // zone given to dates; excel dates have none so default is UTC
+}
+
+// month first layouts for text dates, tried after TimeLayouts
+var monthFirstLayouts = []string{
+	"1/2/2006 15:04:05",
+	"1/2/2006 15:04",
+	"1/2/2006 3:04 PM",
+	"1/2/2006",
+	"1/2/06",
+	"1-2-2006",
+	"1.2.2006",
+}
+
+// day first layouts for text dates, tried after TimeLayouts
+var dayFirstLayouts = []string{
+	"2/1/2006 15:04:05",
+	"2/1/2006 15:04",
+	"2/1/2006 3:04 PM",
+	"2/1/2006",
+	"2/1/06",
+	"2-1-2006",
+	"2.1.2006",
+}
+
+// layouts with month names, tried last
+var namedMonthLayouts = []string{
+	"2006/1/2",
+	"2-Jan-2006",
+	"2-Jan-06",
+	"2 Jan 2006",
+	"2 January 2006",
+	"Jan 2, 2006",
+	"January 2, 2006",
+	"Mon, 2 Jan 2006",
+	"Monday, January 2, 2006",
+}
+
+// location returns the zone dates are given
+func (d *ExcelDates) location() *time.Location {
+	if nil == d.Location {
+		return time.UTC
+	}
+	return d.Location
+}
+
+// fromSerial turns an excel serial date into a time in the zone of d
+func (d *ExcelDates) fromSerial(f float64, date1904 bool) time.Time {
+	t := excelSerialTime(f, date1904)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), d.location())
+}
+
+// excelSerialTime turns a serial date into a utc time; in the 1900 system
+// day 1 is 1 Jan 1900 and day 60 is the 29 Feb 1900 excel thinks existed,
+// read as 1 Mar; in the 1904 system day 0 is 1 Jan 1904
+func excelSerialTime(f float64, date1904 bool) time.Time {
+	days := math.Floor(f)
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	} else if days < 61 {
+		epoch = epoch.AddDate(0, 0, 1)
+	}
+	ms := math.Round((f - days) * 24 * 60 * 60 * 1000)
+	return epoch.AddDate(0, 0, int(days)).Add(time.Duration(ms) * time.Millisecond)
+}
+
+// excelTimeSerial turns the wall clock of a time into a serial date
+func excelTimeSerial(t time.Time, date1904 bool) float64 {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	days := math.Round(day.Sub(epoch).Hours() / 24)
+	if !date1904 && days < 61 {
+		days--
+	}
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	return days + clock.Hours()/24
+}
+
+// parse parses a date stored as text; text with its own offset keeps it
+func (d *ExcelDates) parse(s string) (time.Time, error) {
+
+	// locals
+	s = strings.TrimSpace(s)
+	numeric := monthFirstLayouts
+	if d.DayFirst {
+		numeric = dayFirstLayouts
+	}
+
+	// try each layout
+	for _, layouts := range [][]string{d.Layouts, TimeLayouts, numeric, namedMonthLayouts} {
+		for _, layout := range layouts {
+			t, err := time.ParseInLocation(layout, s, d.location())
+			if nil == err {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("can't parse '%s' as a date", s)
+}
+
+// dateOnly drops the time of day
+func dateOnly(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// DateOnly gets current row/column as a date at midnight, dropping any time of day
+func (e *Excel) DateOnly(name string) (time.Time, error) {
+	t, err := e.Date(name)
+	return dateOnly(t), err
+}
+
+// DateOnly gets current row/column as a date at midnight, dropping any time of day
+func (e *ExcelStream) DateOnly(name string) (time.Time, error) {
+	t, err := e.Date(name)
+	return dateOnly(t), err
+}
+
+// DateOnly gets current row/column as a date at midnight, dropping any time of day
+func (t *Table) DateOnly(name string) (time.Time, error) {
+	d, err := t.Date(name)
+	return dateOnly(d), err
+}
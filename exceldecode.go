@@ -0,0 +1,205 @@
+package utl
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	l "github.com/stevenb256/log"
+	"github.com/tealeg/xlsx"
+)
+
+// ErrRequiredValue a required column had an empty cell
+var ErrRequiredValue = l.NewError(200, "excel", "required value is empty")
+
+// ExcelCellError reports a cell that could not be converted into a struct field
+type ExcelCellError struct {
+	Sheet  string
+	Row    int // 1 based row number as shown in excel
+	Column string
+	Index  int // 0 based column index in the sheet or -1 if column is missing
+	Value  string
+	Err    error
+}
+
+// Error returns the error text
+func (e *ExcelCellError) Error() string {
+	return fmt.Sprintf("sheet '%s' row %d column '%s' value '%s': %s", e.Sheet, e.Row, e.Column, e.Value, e.Err.Error())
+}
+
+// Unwrap returns the conversion error
+func (e *ExcelCellError) Unwrap() error {
+	return e.Err
+}
+
+// ExcelErrors is a list of cell errors found while decoding rows
+type ExcelErrors []*ExcelCellError
+
+// Error returns the error text
+func (e ExcelErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// excelFields returns the xlsx tagged fields of a struct type
+func excelFields(t reflect.Type) []*TaggedField {
+	return GetTaggedFields(t, "xlsx")
+}
+
+// excelColumns returns a column for each field; fields tagged "required" must be
+// in the header and "alias=Cust Name|Customer" gives other header texts
+func excelColumns(fields []*TaggedField) []ExcelColumn {
+	columns := make([]ExcelColumn, len(fields))
+	for i, f := range fields {
+		columns[i] = ExcelColumn{Name: f.Name, Optional: !f.HasOption("required")}
+		for _, option := range f.Options {
+			if strings.HasPrefix(option, "alias=") {
+				columns[i].Aliases = append(columns[i].Aliases, strings.Split(strings.TrimPrefix(option, "alias="), "|")...)
+			}
+		}
+	}
+	return columns
+}
+
+// UnmarshalExcel reads every row of an excel file into slice, which must be a
+// pointer to a slice of structs or struct pointers with `xlsx:"column name,required"`
+// tags; returns ExcelErrors listing every cell that could not be converted
+func UnmarshalExcel(reader io.Reader, slice interface{}, options *ExcelOptions) error {
+
+	// must be pointer to slice
+	v := reflect.ValueOf(slice)
+	if reflect.Ptr != v.Kind() || !IsSlice(slice) {
+		return l.Fail(l.ErrInvalidArg, "UnmarshalExcel needs a pointer to a slice")
+	}
+	v = v.Elem()
+
+	// find the columns from the element type
+	fields := excelFields(GetSliceElementType(v))
+	if 0 == len(fields) {
+		return l.Fail(l.ErrInvalidArg, "slice element has no fields to unmarshal")
+	}
+
+	// open it
+	excel, err := OpenExcelWithOptions(reader, nil, withColumns(options, excelColumns(fields)))
+	if l.Check(err) {
+		return err
+	}
+
+	// read rows
+	var errs ExcelErrors
+	for !excel.IsDone() {
+		n := v.Len()
+		v.Set(reflect.Append(v, reflect.Zero(GetSliceElementType(v))))
+		element := AllocateSliceElement(v, n)
+		errs = append(errs, excel.decodeFields(element, fields)...)
+	}
+
+	// a later sheet failed?
+	if l.Check(excel.Err()) {
+		return excel.Err()
+	}
+
+	// any conversion errors
+	if len(errs) > 0 {
+		return errs
+	}
+
+	// done
+	return nil
+}
+
+// Decode converts the current row into object, a pointer to a struct with xlsx tags;
+// columns not opened with the excel file are left alone
+func (e *Excel) Decode(object interface{}) error {
+	v := reflect.ValueOf(object)
+	if reflect.Ptr != v.Kind() || reflect.Struct != v.Elem().Kind() {
+		return l.Fail(l.ErrInvalidArg, "Decode needs a pointer to a struct")
+	}
+	errs := e.decodeFields(v, excelFields(v.Type()))
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// decodeFields sets fields of struct v from the current row and returns cell errors
+func (e *Excel) decodeFields(v reflect.Value, fields []*TaggedField) ExcelErrors {
+
+	// locals
+	var errs ExcelErrors
+
+	// follow pointer
+	for reflect.Ptr == v.Kind() {
+		v = v.Elem()
+	}
+
+	// set each field
+	for _, f := range fields {
+		cell := e.cell(f.Name)
+		var err error
+		if e.IsEmpty(f.Name) && f.HasOption("required") {
+			err = ErrRequiredValue
+		} else if nil != cell {
+			err = e.setCellValue(GetFieldValue(v, f.Index, true), cell)
+		}
+		if nil != err {
+			errs = append(errs, e.cellError(f.Name, cell, err))
+		}
+	}
+
+	// done
+	return errs
+}
+
+// setCellValue converts cell into v using numeric cell values where that matters
+func (e *Excel) setCellValue(v reflect.Value, cell *xlsx.Cell) error {
+
+	// allocate pointers for non empty cells
+	if reflect.Ptr == v.Kind() && "" != strings.TrimSpace(cell.Value) {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return e.setCellValue(v.Elem(), cell)
+	}
+
+	// dates may be serial numbers or text
+	if reflect.TypeOf(time.Time{}) == v.Type() {
+		if "" == strings.TrimSpace(cell.Value) {
+			return nil
+		}
+		t, err := e.cellTime(cell)
+		if nil != err {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	// numeric cells hold ints as floats
+	if xlsx.CellTypeNumeric == cell.Type() && reflect.Int <= v.Kind() && v.Kind() <= reflect.Int64 && reflect.TypeOf(time.Duration(0)) != v.Type() {
+		f, err := strconv.ParseFloat(cell.Value, 64)
+		if nil == err && f == math.Trunc(f) {
+			return SetValueFromString(v, strconv.FormatInt(int64(f), 10))
+		}
+	}
+
+	// everything else goes through the string conversion
+	return SetValueFromString(v, cell.Value)
+}
+
+// withColumns returns a copy of options that also maps columns
+func withColumns(options *ExcelOptions, columns []ExcelColumn) *ExcelOptions {
+	o := ExcelOptions{}
+	if nil != options {
+		o = *options
+	}
+	o.Columns = append(append([]ExcelColumn{}, o.Columns...), columns...)
+	return &o
+}
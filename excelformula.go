@@ -0,0 +1,1768 @@
+package utl
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	l "github.com/stevenb256/log"
+	"github.com/tealeg/xlsx"
+)
+
+// formulaError is an excel error value like #DIV/0!
+type formulaError string
+
+// excel error values
+const (
+	formulaDiv0  formulaError = "#DIV/0!"
+	formulaNA    formulaError = "#N/A"
+	formulaName  formulaError = "#NAME?"
+	formulaNum   formulaError = "#NUM!"
+	formulaRef   formulaError = "#REF!"
+	formulaValue formulaError = "#VALUE!"
+)
+
+// formulaRange is a block of cells a reference points at
+type formulaRange struct {
+	sheet  *xlsx.Sheet
+	r1, c1 int
+	r2, c2 int
+}
+
+// formulaNode kinds
+const (
+	nodeLiteral = iota
+	nodeRef
+	nodeName
+	nodeUnary
+	nodeBinary
+	nodePercent
+	nodeCall
+)
+
+// formulaNode is a node of a parsed formula
+type formulaNode struct {
+	kind  int
+	op    string
+	value interface{}
+	sheet string
+	ref   string
+	args  []*formulaNode
+}
+
+// formulaFunc evaluates a function call; args are not evaluated so
+// functions like IF only evaluate the branch they take
+type formulaFunc func(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{}
+
+// formulaEvaluator computes formulas over the cells of a workbook
+type formulaEvaluator struct {
+	file   *xlsx.File
+	values map[string]interface{}
+	active map[string]bool
+	err    error
+}
+
+// Formula gets the formula of current row/column without the leading '=', or "" if none
+func (e *Excel) Formula(name string) string {
+	cell := e.cell(name)
+	if nil == cell {
+		return ""
+	}
+	return cell.Formula()
+}
+
+// Evaluate computes the formula of current row/column; cells the formula
+// refers to use their cached value when they have one. Cells without a
+// formula give their value. Excel errors like #DIV/0! come back as the value
+func (e *Excel) Evaluate(name string) (string, error) {
+	cell := e.cell(name)
+	if nil == cell || "" == cell.Formula() {
+		return e.String(name), nil
+	}
+	return e.evaluator().evaluate(e.sheet, cell.Formula())
+}
+
+// EvaluateCell computes the value of a cell given by reference like "B7" on
+// the current sheet or "Totals!B7"; formula cells are always recomputed
+func (e *Excel) EvaluateCell(ref string) (string, error) {
+	ev := e.evaluator()
+	sheet, r, c, err := ev.cellRef(e.sheet, ref)
+	if l.Check(err) {
+		return "", err
+	}
+	cell := sheetCell(sheet, r, c)
+	if nil == cell {
+		return "", nil
+	}
+	if "" == cell.Formula() {
+		return formulaText(ev.cellValue(sheet, r, c)), nil
+	}
+	return ev.evaluate(sheet, cell.Formula())
+}
+
+// evaluator returns the formula evaluator of the workbook
+func (e *Excel) evaluator() *formulaEvaluator {
+	if nil == e.formulas {
+		e.formulas = newFormulaEvaluator(e.file)
+	}
+	return e.formulas
+}
+
+// evaluateFormulas fills in the value of formula cells of the read sheets
+// that were saved without a cached result; cells that fail are left empty
+func (e *Excel) evaluateFormulas() {
+	ev := e.evaluator()
+	for _, sheet := range e.sheets {
+		for _, row := range sheet.Rows {
+			if nil == row {
+				continue
+			}
+			for _, cell := range row.Cells {
+				if nil == cell || "" == cell.Formula() || "" != cell.Value {
+					continue
+				}
+				value, err := ev.compute(sheet, cell.Formula())
+				if nil != err {
+					continue
+				}
+
+				// type the cell as a cached result would be
+				switch v := value.(type) {
+				case float64:
+					cell.SetFormula(cell.Formula())
+					cell.Value = formulaText(v)
+				case bool:
+					cell.SetBool(v)
+				default:
+					cell.SetStringFormula(cell.Formula())
+					cell.Value = formulaText(v)
+				}
+			}
+		}
+	}
+}
+
+// newFormulaEvaluator makes an evaluator over file
+func newFormulaEvaluator(file *xlsx.File) *formulaEvaluator {
+	return &formulaEvaluator{file: file, values: make(map[string]interface{}), active: make(map[string]bool)}
+}
+
+// evaluate parses and computes formula as seen from sheet
+func (ev *formulaEvaluator) evaluate(sheet *xlsx.Sheet, formula string) (string, error) {
+	value, err := ev.compute(sheet, formula)
+	if l.Check(err) {
+		return "", err
+	}
+	return formulaText(value), nil
+}
+
+// compute parses and computes formula as seen from sheet to a number, text,
+// bool or excel error
+func (ev *formulaEvaluator) compute(sheet *xlsx.Sheet, formula string) (interface{}, error) {
+	node, err := parseFormula(formula)
+	if l.Check(err) {
+		return nil, err
+	}
+	ev.err = nil
+	value := ev.scalar(sheet, ev.eval(sheet, node))
+	if l.Check(ev.err) {
+		return nil, ev.err
+	}
+	return value, nil
+}
+
+// fail remembers the first problem that stops a formula being computed
+func (ev *formulaEvaluator) fail(err error) interface{} {
+	if nil == ev.err {
+		ev.err = l.Fail(err)
+	}
+	return formulaName
+}
+
+// eval computes a node; references give a formulaRange
+func (ev *formulaEvaluator) eval(sheet *xlsx.Sheet, node *formulaNode) interface{} {
+	switch node.kind {
+	case nodeLiteral:
+		return node.value
+	case nodeRef:
+		target := sheet
+		if "" != node.sheet {
+			target = ev.sheet(node.sheet)
+			if nil == target {
+				return formulaRef
+			}
+		}
+		r, ok := parseRange(target, node.ref)
+		if !ok {
+			return formulaRef
+		}
+		return r
+	case nodeName:
+		for _, name := range ev.file.DefinedNames {
+			if strings.EqualFold(name.Name, node.ref) {
+				key := "name!" + strings.ToLower(name.Name)
+				if ev.active[key] {
+					return formulaRef
+				}
+				defined, err := parseFormula(name.Data)
+				if nil != err {
+					return formulaName
+				}
+				ev.active[key] = true
+				value := ev.eval(sheet, defined)
+				delete(ev.active, key)
+				return value
+			}
+		}
+		return formulaName
+	case nodeUnary:
+		f, err := ev.number(sheet, ev.eval(sheet, node.args[0]))
+		if nil != err {
+			return err
+		}
+		if "-" == node.op {
+			return -f
+		}
+		return f
+	case nodePercent:
+		f, err := ev.number(sheet, ev.eval(sheet, node.args[0]))
+		if nil != err {
+			return err
+		}
+		return f / 100
+	case nodeBinary:
+		return ev.binary(sheet, node.op, ev.scalar(sheet, ev.eval(sheet, node.args[0])), ev.scalar(sheet, ev.eval(sheet, node.args[1])))
+	case nodeCall:
+		fn, found := formulaFuncs[node.op]
+		if !found {
+			return ev.fail(fmt.Errorf("formula function %s is not supported", node.op))
+		}
+		return fn(ev, sheet, node.args)
+	}
+	return formulaValue
+}
+
+// binary applies an operator to two values
+func (ev *formulaEvaluator) binary(sheet *xlsx.Sheet, op string, x, y interface{}) interface{} {
+
+	// errors win
+	if err, ok := x.(formulaError); ok {
+		return err
+	}
+	if err, ok := y.(formulaError); ok {
+		return err
+	}
+
+	// text and comparison
+	switch op {
+	case "&":
+		return formulaText(x) + formulaText(y)
+	case "=":
+		return 0 == compareValues(x, y)
+	case "<>":
+		return 0 != compareValues(x, y)
+	case "<":
+		return compareValues(x, y) < 0
+	case "<=":
+		return compareValues(x, y) <= 0
+	case ">":
+		return compareValues(x, y) > 0
+	case ">=":
+		return compareValues(x, y) >= 0
+	}
+
+	// arithmetic
+	a, err := ev.number(sheet, x)
+	if nil != err {
+		return err
+	}
+	b, err := ev.number(sheet, y)
+	if nil != err {
+		return err
+	}
+	switch op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		if 0 == b {
+			return formulaDiv0
+		}
+		return a / b
+	case "^":
+		f := math.Pow(a, b)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return formulaNum
+		}
+		return f
+	}
+	return formulaValue
+}
+
+// sheet finds a sheet by name
+func (ev *formulaEvaluator) sheet(name string) *xlsx.Sheet {
+	for _, sheet := range ev.file.Sheets {
+		if strings.EqualFold(sheet.Name, name) {
+			return sheet
+		}
+	}
+	return nil
+}
+
+// rangeRef resolves a reference like "B7", "A1:F20", "Totals!$B$7" or a defined name
+func (ev *formulaEvaluator) rangeRef(sheet *xlsx.Sheet, ref string) (*formulaRange, error) {
+	node, err := parseFormula(ref)
+	if nil != err || (nodeRef != node.kind && nodeName != node.kind) {
+		return nil, l.Fail(fmt.Errorf("'%s' is not a cell reference", ref))
+	}
+	r, ok := ev.eval(sheet, node).(*formulaRange)
+	if !ok {
+		return nil, l.Fail(fmt.Errorf("'%s' does not refer to cells of the workbook", ref))
+	}
+	return r, nil
+}
+
+// cellRef resolves a single cell reference like "B7" or "Totals!$B$7"
+func (ev *formulaEvaluator) cellRef(sheet *xlsx.Sheet, ref string) (*xlsx.Sheet, int, int, error) {
+	r, err := ev.rangeRef(sheet, ref)
+	if l.Check(err) {
+		return nil, 0, 0, err
+	}
+	if r.r1 != r.r2 || r.c1 != r.c2 {
+		return nil, 0, 0, l.Fail(fmt.Errorf("'%s' is not a single cell", ref))
+	}
+	return r.sheet, r.r1, r.c1, nil
+}
+
+// cellValue gives the value of a cell: float64, string, bool, formulaError or
+// nil when blank; formula cells without a cached value are computed
+func (ev *formulaEvaluator) cellValue(sheet *xlsx.Sheet, r, c int) interface{} {
+
+	// locals
+	cell := sheetCell(sheet, r, c)
+	if nil == cell {
+		return nil
+	}
+
+	// compute formulas with no cached value once
+	if "" != cell.Formula() && "" == cell.Value {
+		key := fmt.Sprintf("%s!%d!%d", sheet.Name, r, c)
+		if value, found := ev.values[key]; found {
+			return value
+		}
+		if ev.active[key] {
+			return ev.fail(fmt.Errorf("circular reference at %s!%s", sheet.Name, xlsx.GetCellIDStringFromCoords(c, r)))
+		}
+		ev.active[key] = true
+		node, err := parseFormula(cell.Formula())
+		var value interface{} = formulaName
+		if nil == err {
+			value = ev.scalar(sheet, ev.eval(sheet, node))
+		}
+		delete(ev.active, key)
+		if nil == ev.err {
+			ev.values[key] = value
+		}
+		return value
+	}
+
+	// typed values
+	if "" == cell.Value {
+		return nil
+	}
+	switch cell.Type() {
+	case xlsx.CellTypeNumeric, xlsx.CellTypeDate:
+		if f, err := strconv.ParseFloat(cell.Value, 64); nil == err {
+			return f
+		}
+	case xlsx.CellTypeBool:
+		return "1" == cell.Value || strings.EqualFold("TRUE", cell.Value)
+	case xlsx.CellTypeError:
+		return formulaError(cell.Value)
+	}
+	return cell.Value
+}
+
+// scalar turns a range into the value of its top left cell
+func (ev *formulaEvaluator) scalar(sheet *xlsx.Sheet, value interface{}) interface{} {
+	if r, ok := value.(*formulaRange); ok {
+		return ev.cellValue(r.sheet, r.r1, r.c1)
+	}
+	return value
+}
+
+// number turns a value into a number or an excel error
+func (ev *formulaEvaluator) number(sheet *xlsx.Sheet, value interface{}) (float64, interface{}) {
+	switch v := ev.scalar(sheet, value).(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if nil != err {
+			return 0, formulaValue
+		}
+		return f, nil
+	case formulaError:
+		return 0, v
+	}
+	return 0, formulaValue
+}
+
+// each calls fn with every value of a range or the value of a scalar; fn
+// gets true for values that came from cells
+func (ev *formulaEvaluator) each(value interface{}, fn func(value interface{}, fromCell bool)) {
+	r, ok := value.(*formulaRange)
+	if !ok {
+		fn(value, false)
+		return
+	}
+	for row := r.r1; row <= r.r2; row++ {
+		for col := r.c1; col <= r.c2; col++ {
+			fn(ev.cellValue(r.sheet, row, col), true)
+		}
+	}
+}
+
+// numbers gathers the numbers of args the way SUM does: text and
+// booleans in cells are skipped and typed in arguments are converted
+func (ev *formulaEvaluator) numbers(sheet *xlsx.Sheet, args []*formulaNode) ([]float64, interface{}) {
+	var list []float64
+	var failed interface{}
+	for _, arg := range args {
+		ev.each(ev.eval(sheet, arg), func(value interface{}, fromCell bool) {
+			if nil != failed {
+				return
+			}
+			switch v := value.(type) {
+			case formulaError:
+				failed = v
+			case float64:
+				list = append(list, v)
+			case nil:
+			default:
+				if !fromCell {
+					f, err := ev.number(sheet, v)
+					if nil != err {
+						failed = err
+						return
+					}
+					list = append(list, f)
+				}
+			}
+		})
+	}
+	return list, failed
+}
+
+// sheetCell returns the cell at row r and column c or nil; unlike
+// Sheet.Cell it does not add rows or cells
+func sheetCell(sheet *xlsx.Sheet, r, c int) *xlsx.Cell {
+	if r < 0 || r >= len(sheet.Rows) || nil == sheet.Rows[r] || c < 0 || c >= len(sheet.Rows[r].Cells) {
+		return nil
+	}
+	return sheet.Rows[r].Cells[c]
+}
+
+// formulaText formats a value the way excel caches it
+func formulaText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(v, 'g', 15, 64), 64)
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case string:
+		return v
+	case formulaError:
+		return string(v)
+	}
+	return string(formulaValue)
+}
+
+// compareValues orders two values like excel: numbers before text before
+// booleans, text without case; blank is 0, "" or FALSE next to the other value
+func compareValues(x, y interface{}) int {
+
+	// blanks take the type of the other side
+	if nil == x {
+		x = blankLike(y)
+	}
+	if nil == y {
+		y = blankLike(x)
+	}
+
+	// rank of types
+	if compareRank(x) != compareRank(y) {
+		return compareRank(x) - compareRank(y)
+	}
+
+	// same types
+	switch a := x.(type) {
+	case float64:
+		b := y.(float64)
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(strings.ToLower(a), strings.ToLower(y.(string)))
+	case bool:
+		b := y.(bool)
+		if a == b {
+			return 0
+		} else if b {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// blankLike returns the blank value of the type of v
+func blankLike(v interface{}) interface{} {
+	switch v.(type) {
+	case string:
+		return ""
+	case bool:
+		return false
+	}
+	return 0.0
+}
+
+// parseRange resolves a reference like "A1", "$A$1:B7" or "A:C" on sheet
+func parseRange(sheet *xlsx.Sheet, ref string) (*formulaRange, bool) {
+	parts := strings.Split(strings.Replace(strings.ToUpper(ref), "$", "", -1), ":")
+	if len(parts) > 2 {
+		return nil, false
+	}
+	r := &formulaRange{sheet: sheet}
+	var ok bool
+	r.c1, r.r1, ok = parseCellID(parts[0])
+	if !ok {
+		return nil, false
+	}
+	r.r2, r.c2 = r.r1, r.c1
+	if 2 == len(parts) {
+		r.c2, r.r2, ok = parseCellID(parts[1])
+		if !ok || (r.r1 < 0) != (r.r2 < 0) {
+			return nil, false
+		}
+	}
+
+	// whole columns stop at the last row of the sheet
+	if r.r1 > r.r2 {
+		r.r1, r.r2 = r.r2, r.r1
+	}
+	if r.r1 < 0 {
+		r.r1, r.r2 = 0, len(sheet.Rows)-1
+	}
+	if r.c1 > r.c2 {
+		r.c1, r.c2 = r.c2, r.c1
+	}
+	return r, true
+}
+
+// parseCellID parses "B7" to column and row; a column on its own like "B" gives row -1
+func parseCellID(id string) (int, int, bool) {
+	i := 0
+	for i < len(id) && id[i] >= 'A' && id[i] <= 'Z' {
+		i++
+	}
+	if 0 == i || i > 3 {
+		return 0, 0, false
+	}
+	col := xlsx.ColLettersToIndex(id[:i])
+	if i == len(id) {
+		return col, -1, true
+	}
+	row, err := strconv.Atoi(id[i:])
+	if nil != err || row < 1 {
+		return 0, 0, false
+	}
+	return col, row - 1, true
+}
+
+// formulaParser is a recursive descent parser for excel formulas
+type formulaParser struct {
+	text string
+	pos  int
+}
+
+// cellIDPattern matches a cell or column reference with optional $ signs
+var cellIDPattern = regexp.MustCompile(`^\$?[A-Za-z]{1,3}(\$?[0-9]+)?$`)
+
+// parseFormula parses a formula with or without the leading '='
+func parseFormula(formula string) (*formulaNode, error) {
+	p := &formulaParser{text: strings.TrimPrefix(strings.TrimSpace(formula), "=")}
+	node, err := p.comparison()
+	if nil == err && p.skip() < len(p.text) {
+		err = fmt.Errorf("unexpected '%s'", p.text[p.pos:])
+	}
+	if nil != err {
+		return nil, fmt.Errorf("can't parse formula '%s': %s", formula, err.Error())
+	}
+	return node, nil
+}
+
+// skip moves past white space and returns the position
+func (p *formulaParser) skip() int {
+	for p.pos < len(p.text) && (' ' == p.text[p.pos] || '\n' == p.text[p.pos] || '\r' == p.text[p.pos] || '\t' == p.text[p.pos]) {
+		p.pos++
+	}
+	return p.pos
+}
+
+// accept moves past one of ops if it is next and returns it
+func (p *formulaParser) accept(ops ...string) string {
+	p.skip()
+	for _, op := range ops {
+		if strings.HasPrefix(p.text[p.pos:], op) {
+			p.pos += len(op)
+			return op
+		}
+	}
+	return ""
+}
+
+// binary parses a left associative level of operators
+func (p *formulaParser) binary(next func() (*formulaNode, error), ops ...string) (*formulaNode, error) {
+	node, err := next()
+	if nil != err {
+		return nil, err
+	}
+	for op := p.accept(ops...); "" != op; op = p.accept(ops...) {
+		right, err := next()
+		if nil != err {
+			return nil, err
+		}
+		node = &formulaNode{kind: nodeBinary, op: op, args: []*formulaNode{node, right}}
+	}
+	return node, nil
+}
+
+// comparison parses = <> < <= > >=
+func (p *formulaParser) comparison() (*formulaNode, error) {
+	return p.binary(p.concat, "<>", "<=", ">=", "=", "<", ">")
+}
+
+// concat parses &
+func (p *formulaParser) concat() (*formulaNode, error) {
+	return p.binary(p.additive, "&")
+}
+
+// additive parses + -
+func (p *formulaParser) additive() (*formulaNode, error) {
+	return p.binary(p.multiplicative, "+", "-")
+}
+
+// multiplicative parses * /
+func (p *formulaParser) multiplicative() (*formulaNode, error) {
+	return p.binary(p.power, "*", "/")
+}
+
+// power parses ^
+func (p *formulaParser) power() (*formulaNode, error) {
+	return p.binary(p.unary, "^")
+}
+
+// unary parses leading + - and trailing %; in excel -2^2 is 4
+func (p *formulaParser) unary() (*formulaNode, error) {
+	if op := p.accept("-", "+"); "" != op {
+		node, err := p.unary()
+		if nil != err {
+			return nil, err
+		}
+		return &formulaNode{kind: nodeUnary, op: op, args: []*formulaNode{node}}, nil
+	}
+	node, err := p.primary()
+	if nil != err {
+		return nil, err
+	}
+	for "" != p.accept("%") {
+		node = &formulaNode{kind: nodePercent, args: []*formulaNode{node}}
+	}
+	return node, nil
+}
+
+// primary parses literals, references, names, calls and parentheses
+func (p *formulaParser) primary() (*formulaNode, error) {
+
+	// end
+	if p.skip() >= len(p.text) {
+		return nil, fmt.Errorf("unexpected end")
+	}
+	c := p.text[p.pos]
+
+	// parentheses
+	if '(' == c {
+		p.pos++
+		node, err := p.comparison()
+		if nil != err {
+			return nil, err
+		}
+		if "" == p.accept(")") {
+			return nil, fmt.Errorf("missing ')'")
+		}
+		return node, nil
+	}
+
+	// text
+	if '"' == c {
+		s, err := p.quoted('"')
+		if nil != err {
+			return nil, err
+		}
+		return &formulaNode{kind: nodeLiteral, value: s}, nil
+	}
+
+	// error values
+	if '#' == c {
+		for _, e := range []formulaError{formulaDiv0, formulaNA, formulaName, formulaNum, formulaRef, formulaValue, "#NULL!"} {
+			if strings.HasPrefix(strings.ToUpper(p.text[p.pos:]), string(e)) {
+				p.pos += len(e)
+				return &formulaNode{kind: nodeLiteral, value: e}, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown error value")
+	}
+
+	// numbers
+	if (c >= '0' && c <= '9') || '.' == c {
+		start := p.pos
+		for p.pos < len(p.text) && (isDigit(p.text[p.pos]) || '.' == p.text[p.pos]) {
+			p.pos++
+		}
+		if p.pos < len(p.text) && ('e' == p.text[p.pos] || 'E' == p.text[p.pos]) {
+			p.pos++
+			if p.pos < len(p.text) && ('+' == p.text[p.pos] || '-' == p.text[p.pos]) {
+				p.pos++
+			}
+			for p.pos < len(p.text) && isDigit(p.text[p.pos]) {
+				p.pos++
+			}
+		}
+		f, err := strconv.ParseFloat(p.text[start:p.pos], 64)
+		if nil != err {
+			return nil, fmt.Errorf("bad number '%s'", p.text[start:p.pos])
+		}
+		return &formulaNode{kind: nodeLiteral, value: f}, nil
+	}
+
+	// sheet qualified references
+	sheet := ""
+	if '\'' == c {
+		s, err := p.quoted('\'')
+		if nil != err {
+			return nil, err
+		}
+		if "" == p.accept("!") {
+			return nil, fmt.Errorf("missing '!' after sheet name")
+		}
+		sheet = s
+	}
+	word := p.word()
+	if "" == sheet && p.pos < len(p.text) && '!' == p.text[p.pos] {
+		p.pos++
+		sheet, word = word, p.word()
+	}
+	if "" == word {
+		return nil, fmt.Errorf("unexpected '%s'", p.text[p.pos:])
+	}
+
+	// function calls
+	if "" == sheet && p.pos < len(p.text) && '(' == p.text[p.pos] {
+		p.pos++
+		node := &formulaNode{kind: nodeCall, op: strings.TrimPrefix(strings.ToUpper(word), "_XLFN.")}
+		if "" != p.accept(")") {
+			return node, nil
+		}
+		for {
+			var arg *formulaNode
+			if p.skip() < len(p.text) && (',' == p.text[p.pos] || ')' == p.text[p.pos]) {
+				arg = &formulaNode{kind: nodeLiteral}
+			} else {
+				var err error
+				arg, err = p.comparison()
+				if nil != err {
+					return nil, err
+				}
+			}
+			node.args = append(node.args, arg)
+			if "" != p.accept(")") {
+				return node, nil
+			}
+			if "" == p.accept(",", ";") {
+				return nil, fmt.Errorf("missing ')' after arguments of %s", node.op)
+			}
+		}
+	}
+
+	// booleans
+	if "" == sheet && (strings.EqualFold("TRUE", word) || strings.EqualFold("FALSE", word)) {
+		return &formulaNode{kind: nodeLiteral, value: strings.EqualFold("TRUE", word)}, nil
+	}
+
+	// cell and range references
+	if cellIDPattern.MatchString(word) {
+		ref := word
+		if p.pos < len(p.text) && ':' == p.text[p.pos] {
+			save := p.pos
+			p.pos++
+			if end := p.word(); cellIDPattern.MatchString(end) {
+				ref += ":" + end
+			} else {
+				p.pos = save
+			}
+		}
+		if strings.Contains(ref, ":") || strings.IndexAny(ref, "0123456789") > 0 {
+			return &formulaNode{kind: nodeRef, sheet: sheet, ref: ref}, nil
+		}
+	}
+
+	// defined names
+	if "" != sheet {
+		return nil, fmt.Errorf("bad reference '%s!%s'", sheet, word)
+	}
+	return &formulaNode{kind: nodeName, ref: word}, nil
+}
+
+// quoted reads text between quote characters where a doubled quote is a quote
+func (p *formulaParser) quoted(quote byte) (string, error) {
+	var b strings.Builder
+	for p.pos++; p.pos < len(p.text); p.pos++ {
+		if quote == p.text[p.pos] {
+			if p.pos+1 < len(p.text) && quote == p.text[p.pos+1] {
+				p.pos++
+			} else {
+				p.pos++
+				return b.String(), nil
+			}
+		}
+		b.WriteByte(p.text[p.pos])
+	}
+	return "", fmt.Errorf("missing closing %c", quote)
+}
+
+// word reads a name, function name, sheet name or cell id
+func (p *formulaParser) word() string {
+	start := p.pos
+	for p.pos < len(p.text) {
+		c := p.text[p.pos]
+		if !(isDigit(c) || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || '_' == c || '.' == c || '$' == c || c >= 0x80) {
+			break
+		}
+		p.pos++
+	}
+	return p.text[start:p.pos]
+}
+
+// isDigit returns true for ascii digits
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// formulaFuncs are the supported functions by upper case name
+var formulaFuncs map[string]formulaFunc
+
+// init fills formulaFuncs; done here as functions refer back to the evaluator
+func init() {
+	formulaFuncs = map[string]formulaFunc{
+		"SUM":         fnSum,
+		"AVERAGE":     fnAverage,
+		"MIN":         fnMin,
+		"MAX":         fnMax,
+		"COUNT":       fnCount,
+		"COUNTA":      fnCountA,
+		"SUMIF":       fnSumIf,
+		"COUNTIF":     fnCountIf,
+		"ROUND":       fnRound(math.Round),
+		"ROUNDUP":     fnRound(func(f float64) float64 { return math.Copysign(math.Ceil(math.Abs(f)), f) }),
+		"ROUNDDOWN":   fnRound(math.Trunc),
+		"ABS":         fnMath(math.Abs),
+		"INT":         fnMath(math.Floor),
+		"MOD":         fnMod,
+		"IF":          fnIf,
+		"IFERROR":     fnIfError,
+		"AND":         fnAnd,
+		"OR":          fnOr,
+		"NOT":         fnNot,
+		"ISBLANK":     fnIsBlank,
+		"ISNUMBER":    fnIsNumber,
+		"ISTEXT":      fnIsText,
+		"ISERROR":     fnIsError,
+		"VLOOKUP":     fnLookup(true),
+		"HLOOKUP":     fnLookup(false),
+		"MATCH":       fnMatch,
+		"INDEX":       fnIndex,
+		"CONCATENATE": fnConcat,
+		"CONCAT":      fnConcat,
+		"LEFT":        fnLeft,
+		"RIGHT":       fnRight,
+		"MID":         fnMid,
+		"LEN":         fnLen,
+		"UPPER":       fnText(strings.ToUpper),
+		"LOWER":       fnText(strings.ToLower),
+		"TRIM":        fnText(func(s string) string { return strings.Join(strings.Fields(s), " ") }),
+		"VALUE":       fnValue,
+		"DATE":        fnDate,
+		"YEAR":        fnDatePart(func(t time.Time) int { return t.Year() }),
+		"MONTH":       fnDatePart(func(t time.Time) int { return int(t.Month()) }),
+		"DAY":         fnDatePart(func(t time.Time) int { return t.Day() }),
+		"WEEKDAY":     fnDatePart(func(t time.Time) int { return int(t.Weekday()) + 1 }),
+		"TODAY":       fnToday,
+		"NOW":         fnNow,
+		"EDATE":       fnEDate(false),
+		"EOMONTH":     fnEDate(true),
+		"DAYS":        fnDays,
+	}
+}
+
+// args evaluates every argument to a scalar
+func (ev *formulaEvaluator) args(sheet *xlsx.Sheet, nodes []*formulaNode) []interface{} {
+	values := make([]interface{}, len(nodes))
+	for i, node := range nodes {
+		values[i] = ev.scalar(sheet, ev.eval(sheet, node))
+	}
+	return values
+}
+
+// argNumbers evaluates every argument to a number
+func (ev *formulaEvaluator) argNumbers(sheet *xlsx.Sheet, nodes []*formulaNode) ([]float64, interface{}) {
+	list := make([]float64, len(nodes))
+	for i, node := range nodes {
+		f, err := ev.number(sheet, ev.eval(sheet, node))
+		if nil != err {
+			return nil, err
+		}
+		list[i] = f
+	}
+	return list, nil
+}
+
+// truth turns a value into a boolean or an excel error
+func truth(value interface{}) (bool, interface{}) {
+	switch v := value.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	case float64:
+		return 0 != v, nil
+	case string:
+		if strings.EqualFold("TRUE", v) {
+			return true, nil
+		}
+		if strings.EqualFold("FALSE", v) {
+			return false, nil
+		}
+		return false, formulaValue
+	case formulaError:
+		return false, v
+	}
+	return false, formulaValue
+}
+
+// fnSum is SUM
+func fnSum(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	list, err := ev.numbers(sheet, args)
+	if nil != err {
+		return err
+	}
+	sum := 0.0
+	for _, f := range list {
+		sum += f
+	}
+	return sum
+}
+
+// fnAverage is AVERAGE
+func fnAverage(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	list, err := ev.numbers(sheet, args)
+	if nil != err {
+		return err
+	}
+	if 0 == len(list) {
+		return formulaDiv0
+	}
+	sum := 0.0
+	for _, f := range list {
+		sum += f
+	}
+	return sum / float64(len(list))
+}
+
+// fnMin is MIN
+func fnMin(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	list, err := ev.numbers(sheet, args)
+	if nil != err {
+		return err
+	}
+	if 0 == len(list) {
+		return 0.0
+	}
+	min := list[0]
+	for _, f := range list {
+		min = math.Min(min, f)
+	}
+	return min
+}
+
+// fnMax is MAX
+func fnMax(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	list, err := ev.numbers(sheet, args)
+	if nil != err {
+		return err
+	}
+	if 0 == len(list) {
+		return 0.0
+	}
+	max := list[0]
+	for _, f := range list {
+		max = math.Max(max, f)
+	}
+	return max
+}
+
+// fnCount is COUNT
+func fnCount(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	n := 0
+	for _, arg := range args {
+		ev.each(ev.eval(sheet, arg), func(value interface{}, fromCell bool) {
+			if _, ok := value.(float64); ok {
+				n++
+			} else if s, ok := value.(string); ok && !fromCell {
+				if _, err := strconv.ParseFloat(s, 64); nil == err {
+					n++
+				}
+			}
+		})
+	}
+	return float64(n)
+}
+
+// fnCountA is COUNTA
+func fnCountA(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	n := 0
+	for _, arg := range args {
+		ev.each(ev.eval(sheet, arg), func(value interface{}, fromCell bool) {
+			if nil != value {
+				n++
+			}
+		})
+	}
+	return float64(n)
+}
+
+// criteria builds a test for SUMIF and COUNTIF from criteria like ">5", "<>x" or "ab*"
+func criteria(value interface{}) func(interface{}) bool {
+
+	// non text matches equal values
+	s, ok := value.(string)
+	if !ok {
+		return func(v interface{}) bool { return nil != v && 0 == compareValues(v, value) }
+	}
+
+	// split operator
+	op := ""
+	for _, prefix := range []string{"<>", "<=", ">=", "=", "<", ">"} {
+		if strings.HasPrefix(s, prefix) {
+			op, s = prefix, s[len(prefix):]
+			break
+		}
+	}
+
+	// numbers compare as numbers
+	var target interface{} = s
+	if f, err := strconv.ParseFloat(s, 64); nil == err {
+		target = f
+	}
+
+	// text equality allows wildcards
+	var pattern *regexp.Regexp
+	if _, isText := target.(string); isText && ("" == op || "=" == op || "<>" == op) {
+		expr := regexp.QuoteMeta(strings.ToLower(s))
+		expr = strings.Replace(strings.Replace(expr, `\*`, ".*", -1), `\?`, ".", -1)
+		pattern = regexp.MustCompile("^" + expr + "$")
+	}
+
+	return func(v interface{}) bool {
+		if nil != pattern {
+			text, isText := v.(string)
+			match := isText && pattern.MatchString(strings.ToLower(text))
+			if "" == s && nil == v {
+				match = true
+			}
+			return match == ("<>" != op)
+		}
+		if nil == v {
+			return false
+		}
+		if _, same := v.(float64); !same {
+			if _, number := target.(float64); number {
+				return "<>" == op
+			}
+		}
+		n := compareValues(v, target)
+		switch op {
+		case "<":
+			return n < 0
+		case "<=":
+			return n <= 0
+		case ">":
+			return n > 0
+		case ">=":
+			return n >= 0
+		case "<>":
+			return 0 != n
+		}
+		return 0 == n
+	}
+}
+
+// fnSumIf is SUMIF
+func fnSumIf(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if len(args) < 2 || len(args) > 3 {
+		return formulaValue
+	}
+	test, ok := ev.eval(sheet, args[0]).(*formulaRange)
+	if !ok {
+		return formulaValue
+	}
+	sum := test
+	if 3 == len(args) {
+		if sum, ok = ev.eval(sheet, args[2]).(*formulaRange); !ok {
+			return formulaValue
+		}
+	}
+	match := criteria(ev.scalar(sheet, ev.eval(sheet, args[1])))
+	total := 0.0
+	for r := 0; r <= test.r2-test.r1; r++ {
+		for c := 0; c <= test.c2-test.c1; c++ {
+			if match(ev.cellValue(test.sheet, test.r1+r, test.c1+c)) {
+				if f, ok := ev.cellValue(sum.sheet, sum.r1+r, sum.c1+c).(float64); ok {
+					total += f
+				}
+			}
+		}
+	}
+	return total
+}
+
+// fnCountIf is COUNTIF
+func fnCountIf(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if 2 != len(args) {
+		return formulaValue
+	}
+	match := criteria(ev.scalar(sheet, ev.eval(sheet, args[1])))
+	n := 0
+	ev.each(ev.eval(sheet, args[0]), func(value interface{}, fromCell bool) {
+		if match(value) {
+			n++
+		}
+	})
+	return float64(n)
+}
+
+// fnRound makes ROUND, ROUNDUP and ROUNDDOWN from a rounding of whole numbers
+func fnRound(round func(float64) float64) formulaFunc {
+	return func(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+		if 2 != len(args) {
+			return formulaValue
+		}
+		list, err := ev.argNumbers(sheet, args)
+		if nil != err {
+			return err
+		}
+		scale := math.Pow(10, math.Trunc(list[1]))
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(list[0]*scale, 'g', 15, 64), 64)
+		return round(f) / scale
+	}
+}
+
+// fnMath makes a function of one number
+func fnMath(fn func(float64) float64) formulaFunc {
+	return func(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+		if 1 != len(args) {
+			return formulaValue
+		}
+		list, err := ev.argNumbers(sheet, args)
+		if nil != err {
+			return err
+		}
+		return fn(list[0])
+	}
+}
+
+// fnMod is MOD; the result has the sign of the divisor
+func fnMod(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if 2 != len(args) {
+		return formulaValue
+	}
+	list, err := ev.argNumbers(sheet, args)
+	if nil != err {
+		return err
+	}
+	if 0 == list[1] {
+		return formulaDiv0
+	}
+	return list[0] - list[1]*math.Floor(list[0]/list[1])
+}
+
+// fnIf is IF
+func fnIf(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if len(args) < 2 || len(args) > 3 {
+		return formulaValue
+	}
+	b, err := truth(ev.scalar(sheet, ev.eval(sheet, args[0])))
+	if nil != err {
+		return err
+	}
+	if b {
+		return ev.eval(sheet, args[1])
+	}
+	if 3 == len(args) {
+		return ev.eval(sheet, args[2])
+	}
+	return false
+}
+
+// fnIfError is IFERROR
+func fnIfError(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if 2 != len(args) {
+		return formulaValue
+	}
+	value := ev.scalar(sheet, ev.eval(sheet, args[0]))
+	if _, failed := value.(formulaError); failed {
+		return ev.eval(sheet, args[1])
+	}
+	return value
+}
+
+// logical evaluates every argument as a boolean for AND and OR
+func (ev *formulaEvaluator) logical(sheet *xlsx.Sheet, args []*formulaNode) ([]bool, interface{}) {
+	var list []bool
+	var failed interface{}
+	for _, arg := range args {
+		ev.each(ev.eval(sheet, arg), func(value interface{}, fromCell bool) {
+			if nil != failed || (fromCell && nil == value) {
+				return
+			}
+			if _, text := value.(string); text && fromCell {
+				return
+			}
+			b, err := truth(value)
+			if nil != err {
+				failed = err
+				return
+			}
+			list = append(list, b)
+		})
+	}
+	if nil == failed && 0 == len(list) {
+		failed = formulaValue
+	}
+	return list, failed
+}
+
+// fnAnd is AND
+func fnAnd(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	list, err := ev.logical(sheet, args)
+	if nil != err {
+		return err
+	}
+	for _, b := range list {
+		if !b {
+			return false
+		}
+	}
+	return true
+}
+
+// fnOr is OR
+func fnOr(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	list, err := ev.logical(sheet, args)
+	if nil != err {
+		return err
+	}
+	for _, b := range list {
+		if b {
+			return true
+		}
+	}
+	return false
+}
+
+// fnNot is NOT
+func fnNot(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if 1 != len(args) {
+		return formulaValue
+	}
+	b, err := truth(ev.scalar(sheet, ev.eval(sheet, args[0])))
+	if nil != err {
+		return err
+	}
+	return !b
+}
+
+// fnIsBlank is ISBLANK
+func fnIsBlank(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if 1 != len(args) {
+		return formulaValue
+	}
+	return nil == ev.scalar(sheet, ev.eval(sheet, args[0]))
+}
+
+// fnIsNumber is ISNUMBER
+func fnIsNumber(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if 1 != len(args) {
+		return formulaValue
+	}
+	_, ok := ev.scalar(sheet, ev.eval(sheet, args[0])).(float64)
+	return ok
+}
+
+// fnIsText is ISTEXT
+func fnIsText(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if 1 != len(args) {
+		return formulaValue
+	}
+	_, ok := ev.scalar(sheet, ev.eval(sheet, args[0])).(string)
+	return ok
+}
+
+// fnIsError is ISERROR
+func fnIsError(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if 1 != len(args) {
+		return formulaValue
+	}
+	_, ok := ev.scalar(sheet, ev.eval(sheet, args[0])).(formulaError)
+	return ok
+}
+
+// lookupMatch finds the position of value in the cells of a row or column:
+// exact gives the first equal value, otherwise the last value not greater
+// in a list sorted ascending; -1 if none
+func (ev *formulaEvaluator) lookupMatch(value interface{}, n int, at func(i int) interface{}, exact bool) int {
+	found := -1
+	match := criteria(value)
+	for i := 0; i < n; i++ {
+		v := at(i)
+		if exact {
+			if _, text := value.(string); text {
+				if match(v) {
+					return i
+				}
+			} else if nil != v && 0 == compareValues(v, value) {
+				return i
+			}
+			continue
+		}
+		if nil == v || compareRank(v) != compareRank(value) {
+			continue
+		}
+		if compareValues(v, value) > 0 {
+			break
+		}
+		found = i
+	}
+	return found
+}
+
+// compareRank returns the type rank compareValues orders by
+func compareRank(v interface{}) int {
+	switch v.(type) {
+	case float64:
+		return 0
+	case string:
+		return 1
+	case bool:
+		return 2
+	}
+	return 3
+}
+
+// fnLookup makes VLOOKUP when vertical or HLOOKUP
+func fnLookup(vertical bool) formulaFunc {
+	return func(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+
+		// arguments
+		if len(args) < 3 || len(args) > 4 {
+			return formulaValue
+		}
+		value := ev.scalar(sheet, ev.eval(sheet, args[0]))
+		if err, failed := value.(formulaError); failed {
+			return err
+		}
+		table, ok := ev.eval(sheet, args[1]).(*formulaRange)
+		if !ok {
+			return formulaValue
+		}
+		index, err := ev.number(sheet, ev.eval(sheet, args[2]))
+		if nil != err {
+			return err
+		}
+		approximate := true
+		if 4 == len(args) {
+			if approximate, err = truth(ev.scalar(sheet, ev.eval(sheet, args[3]))); nil != err {
+				return err
+			}
+		}
+
+		// search first column or row
+		offset := int(index) - 1
+		if offset < 0 {
+			return formulaValue
+		}
+		if vertical {
+			if offset > table.c2-table.c1 {
+				return formulaRef
+			}
+			i := ev.lookupMatch(value, table.r2-table.r1+1, func(i int) interface{} {
+				return ev.cellValue(table.sheet, table.r1+i, table.c1)
+			}, !approximate)
+			if i < 0 {
+				return formulaNA
+			}
+			return ev.cellValue(table.sheet, table.r1+i, table.c1+offset)
+		}
+		if offset > table.r2-table.r1 {
+			return formulaRef
+		}
+		i := ev.lookupMatch(value, table.c2-table.c1+1, func(i int) interface{} {
+			return ev.cellValue(table.sheet, table.r1, table.c1+i)
+		}, !approximate)
+		if i < 0 {
+			return formulaNA
+		}
+		return ev.cellValue(table.sheet, table.r1+offset, table.c1+i)
+	}
+}
+
+// fnMatch is MATCH with match types 0 and 1
+func fnMatch(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if len(args) < 2 || len(args) > 3 {
+		return formulaValue
+	}
+	value := ev.scalar(sheet, ev.eval(sheet, args[0]))
+	list, ok := ev.eval(sheet, args[1]).(*formulaRange)
+	if !ok || (list.r1 != list.r2 && list.c1 != list.c2) {
+		return formulaNA
+	}
+	kind := 1.0
+	if 3 == len(args) {
+		var err interface{}
+		if kind, err = ev.number(sheet, ev.eval(sheet, args[2])); nil != err {
+			return err
+		}
+	}
+	if kind < 0 {
+		return ev.fail(fmt.Errorf("MATCH with match type -1 is not supported"))
+	}
+	n := list.r2 - list.r1 + 1
+	at := func(i int) interface{} { return ev.cellValue(list.sheet, list.r1+i, list.c1) }
+	if list.r1 == list.r2 {
+		n = list.c2 - list.c1 + 1
+		at = func(i int) interface{} { return ev.cellValue(list.sheet, list.r1, list.c1+i) }
+	}
+	i := ev.lookupMatch(value, n, at, 0 == kind)
+	if i < 0 {
+		return formulaNA
+	}
+	return float64(i + 1)
+}
+
+// fnIndex is INDEX over a range
+func fnIndex(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if len(args) < 2 || len(args) > 3 {
+		return formulaValue
+	}
+	table, ok := ev.eval(sheet, args[0]).(*formulaRange)
+	if !ok {
+		return formulaValue
+	}
+	list, err := ev.argNumbers(sheet, args[1:])
+	if nil != err {
+		return err
+	}
+	r, c := int(list[0]), 1
+	if 3 == len(args) {
+		c = int(list[1])
+	} else if table.r1 == table.r2 {
+		r, c = 1, r
+	}
+	if r < 1 || c < 1 || r > table.r2-table.r1+1 || c > table.c2-table.c1+1 {
+		return formulaRef
+	}
+	return ev.cellValue(table.sheet, table.r1+r-1, table.c1+c-1)
+}
+
+// fnConcat is CONCATENATE and CONCAT
+func fnConcat(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	var b strings.Builder
+	var failed interface{}
+	for _, arg := range args {
+		ev.each(ev.eval(sheet, arg), func(value interface{}, fromCell bool) {
+			if err, ok := value.(formulaError); ok && nil == failed {
+				failed = err
+			}
+			b.WriteString(formulaText(value))
+		})
+	}
+	if nil != failed {
+		return failed
+	}
+	return b.String()
+}
+
+// textArgs evaluates a text argument followed by numbers
+func (ev *formulaEvaluator) textArgs(sheet *xlsx.Sheet, args []*formulaNode) ([]rune, []float64, interface{}) {
+	value := ev.scalar(sheet, ev.eval(sheet, args[0]))
+	if err, failed := value.(formulaError); failed {
+		return nil, nil, err
+	}
+	list, err := ev.argNumbers(sheet, args[1:])
+	if nil != err {
+		return nil, nil, err
+	}
+	return []rune(formulaText(value)), list, nil
+}
+
+// fnLeft is LEFT
+func fnLeft(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if len(args) < 1 || len(args) > 2 {
+		return formulaValue
+	}
+	text, list, err := ev.textArgs(sheet, args)
+	if nil != err {
+		return err
+	}
+	n := 1
+	if len(list) > 0 {
+		n = int(list[0])
+	}
+	if n < 0 {
+		return formulaValue
+	}
+	return string(text[:minInt(n, len(text))])
+}
+
+// fnRight is RIGHT
+func fnRight(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if len(args) < 1 || len(args) > 2 {
+		return formulaValue
+	}
+	text, list, err := ev.textArgs(sheet, args)
+	if nil != err {
+		return err
+	}
+	n := 1
+	if len(list) > 0 {
+		n = int(list[0])
+	}
+	if n < 0 {
+		return formulaValue
+	}
+	return string(text[len(text)-minInt(n, len(text)):])
+}
+
+// fnMid is MID
+func fnMid(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if 3 != len(args) {
+		return formulaValue
+	}
+	text, list, err := ev.textArgs(sheet, args)
+	if nil != err {
+		return err
+	}
+	start, n := int(list[0]), int(list[1])
+	if start < 1 || n < 0 {
+		return formulaValue
+	}
+	if start > len(text) {
+		return ""
+	}
+	return string(text[start-1 : minInt(start-1+n, len(text))])
+}
+
+// fnLen is LEN
+func fnLen(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if 1 != len(args) {
+		return formulaValue
+	}
+	text, _, err := ev.textArgs(sheet, args)
+	if nil != err {
+		return err
+	}
+	return float64(len(text))
+}
+
+// fnText makes a function of one text
+func fnText(fn func(string) string) formulaFunc {
+	return func(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+		if 1 != len(args) {
+			return formulaValue
+		}
+		text, _, err := ev.textArgs(sheet, args)
+		if nil != err {
+			return err
+		}
+		return fn(string(text))
+	}
+}
+
+// fnValue is VALUE
+func fnValue(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if 1 != len(args) {
+		return formulaValue
+	}
+	value := ev.scalar(sheet, ev.eval(sheet, args[0]))
+	if s, ok := value.(string); ok {
+		f, err := parseNumber(s)
+		if nil != err {
+			return formulaValue
+		}
+		return f
+	}
+	f, err := ev.number(sheet, value)
+	if nil != err {
+		return err
+	}
+	return f
+}
+
+// serialTime turns an excel serial date of the workbook into a time
+func (ev *formulaEvaluator) serialTime(f float64) time.Time {
+	return excelSerialTime(f, ev.file.Date1904)
+}
+
+// timeSerial turns a time into an excel serial date of the workbook
+func (ev *formulaEvaluator) timeSerial(t time.Time) float64 {
+	return excelTimeSerial(t, ev.file.Date1904)
+}
+
+// fnDate is DATE; months and days out of range roll over like excel
+func fnDate(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if 3 != len(args) {
+		return formulaValue
+	}
+	list, err := ev.argNumbers(sheet, args)
+	if nil != err {
+		return err
+	}
+	year := int(list[0])
+	if year < 1900 {
+		year += 1900
+	}
+	serial := ev.timeSerial(time.Date(year, time.Month(int(list[1])), int(list[2]), 0, 0, 0, 0, time.UTC))
+	if serial < 0 {
+		return formulaNum
+	}
+	return serial
+}
+
+// fnDatePart makes YEAR, MONTH, DAY and WEEKDAY
+func fnDatePart(part func(time.Time) int) formulaFunc {
+	return func(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+		if len(args) < 1 {
+			return formulaValue
+		}
+		t, err := ev.dateArg(sheet, args[0])
+		if nil != err {
+			return err
+		}
+		return float64(part(t))
+	}
+}
+
+// dateArg evaluates an argument that is a serial date or date text
+func (ev *formulaEvaluator) dateArg(sheet *xlsx.Sheet, node *formulaNode) (time.Time, interface{}) {
+	value := ev.scalar(sheet, ev.eval(sheet, node))
+	if s, ok := value.(string); ok {
+		if _, err := strconv.ParseFloat(s, 64); nil != err {
+			t, err := ParseTime(s)
+			if nil != err {
+				return time.Time{}, formulaValue
+			}
+			return t, nil
+		}
+	}
+	f, err := ev.number(sheet, value)
+	if nil != err {
+		return time.Time{}, err
+	}
+	if f < 0 {
+		return time.Time{}, formulaNum
+	}
+	return ev.serialTime(f), nil
+}
+
+// fnToday is TODAY
+func fnToday(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	now := time.Now()
+	return ev.timeSerial(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC))
+}
+
+// fnNow is NOW
+func fnNow(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	return ev.timeSerial(time.Now())
+}
+
+// fnEDate makes EDATE, or EOMONTH when endOfMonth
+func fnEDate(endOfMonth bool) formulaFunc {
+	return func(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+		if 2 != len(args) {
+			return formulaValue
+		}
+		t, err := ev.dateArg(sheet, args[0])
+		if nil != err {
+			return err
+		}
+		months, err := ev.number(sheet, ev.eval(sheet, args[1]))
+		if nil != err {
+			return err
+		}
+		first := time.Date(t.Year(), t.Month()+time.Month(int(months)), 1, 0, 0, 0, 0, time.UTC)
+		last := first.AddDate(0, 1, -1).Day()
+		day := minInt(t.Day(), last)
+		if endOfMonth {
+			day = last
+		}
+		return ev.timeSerial(first.AddDate(0, 0, day-1))
+	}
+}
+
+// fnDays is DAYS
+func fnDays(ev *formulaEvaluator, sheet *xlsx.Sheet, args []*formulaNode) interface{} {
+	if 2 != len(args) {
+		return formulaValue
+	}
+	end, err := ev.dateArg(sheet, args[0])
+	if nil != err {
+		return err
+	}
+	start, err := ev.dateArg(sheet, args[1])
+	if nil != err {
+		return err
+	}
+	return math.Floor(ev.timeSerial(end)) - math.Floor(ev.timeSerial(start))
+}
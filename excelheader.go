@@ -0,0 +1,281 @@
+package utl
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ExcelColumn describes a logical column and the header texts that may name it
+type ExcelColumn struct {
+	Name     string   // name passed to String, Int, Date...
+	Aliases  []string // other header texts for the same column, e.g. "Cust. Name"
+	Optional bool     // column may be missing from the header
+}
+
+// headerMatcher finds logical columns in a header row
+type headerMatcher struct {
+	columns []ExcelColumn
+	fuzzy   bool
+}
+
+// newHeaderMatcher makes a matcher for required names plus columns from options
+func newHeaderMatcher(names []string, options *ExcelOptions) *headerMatcher {
+	m := &headerMatcher{fuzzy: options.FuzzyHeaders}
+	for _, name := range names {
+		m.add(ExcelColumn{Name: name})
+	}
+	for _, column := range options.Columns {
+		m.add(column)
+	}
+	return m
+}
+
+// add adds a column or merges it into one with the same name
+func (m *headerMatcher) add(column ExcelColumn) {
+	key := normalizeHeader(column.Name)
+	for i := range m.columns {
+		if normalizeHeader(m.columns[i].Name) == key {
+			m.columns[i].Aliases = append(m.columns[i].Aliases, column.Aliases...)
+			m.columns[i].Optional = column.Optional
+			return
+		}
+	}
+	m.columns = append(m.columns, column)
+}
+
+// required returns names of columns that must be in the header
+func (m *headerMatcher) required() []string {
+	var names []string
+	for _, column := range m.columns {
+		if !column.Optional {
+			names = append(names, column.Name)
+		}
+	}
+	return names
+}
+
+// match maps normalized column names to their index in header and returns
+// the names of required columns that could not be found; a header title goes
+// to one column only, so a fuzzy title that fits several columns is ambiguous
+// and reported with the missing ones
+func (m *headerMatcher) match(header []string) (map[string]int, []string) {
+
+	// locals
+	var missing []string
+	columns := make(map[string]int)
+	found := make([]int, len(m.columns))
+	ambiguous := make([][]int, len(m.columns))
+	claimed := make(map[int]bool)
+
+	// normalize the header once
+	titles := make([]string, len(header))
+	for i, title := range header {
+		titles[i] = normalizeHeader(title)
+	}
+
+	// exact matches are claimed first, then those ignoring spaces, then fuzzy ones
+	for c := range found {
+		found[c] = -1
+	}
+	for pass := 0; pass < 3; pass++ {
+
+		// titles each column could take
+		candidates := make([][]int, len(m.columns))
+		claims := make(map[int]int)
+		for c, column := range m.columns {
+			if -1 == found[c] {
+				candidates[c] = m.candidates(titles, column, pass, claimed)
+				for _, i := range candidates[c] {
+					claims[i]++
+				}
+			}
+		}
+
+		// take them; fuzzy ones only when neither side has a choice
+		for c, list := range candidates {
+			for _, i := range list {
+				if claimed[i] {
+					continue
+				}
+				if 2 == pass && (1 != len(list) || 1 != claims[i]) {
+					ambiguous[c] = list
+					break
+				}
+				found[c] = i
+				claimed[i] = true
+				break
+			}
+		}
+	}
+
+	// name the columns not found
+	for c, column := range m.columns {
+		if -1 != found[c] {
+			columns[normalizeHeader(column.Name)] = found[c]
+			continue
+		}
+		if column.Optional {
+			continue
+		}
+		if 0 == len(ambiguous[c]) {
+			missing = append(missing, column.Name)
+			continue
+		}
+		texts := make([]string, len(ambiguous[c]))
+		for k, i := range ambiguous[c] {
+			texts[k] = strings.TrimSpace(header[i])
+		}
+		missing = append(missing, fmt.Sprintf("%s (ambiguous: %s)", column.Name, strings.Join(texts, ", ")))
+	}
+
+	// done
+	return columns, missing
+}
+
+// candidates returns indexes of unclaimed header titles naming column in a
+// pass: 0 exact, 1 ignoring spaces or 2 fuzzy; titles matching the name come
+// before those matching aliases
+func (m *headerMatcher) candidates(titles []string, column ExcelColumn, pass int, claimed map[int]bool) []int {
+	var list []int
+	seen := make(map[int]bool)
+	for _, name := range append([]string{column.Name}, column.Aliases...) {
+		name = normalizeHeader(name)
+		for i, title := range titles {
+			if "" == title || claimed[i] || seen[i] {
+				continue
+			}
+			match := false
+			switch pass {
+			case 0:
+				match = title == name
+			case 1:
+				match = strings.Replace(title, " ", "", -1) == strings.Replace(name, " ", "", -1)
+			case 2:
+				match = m.fuzzy && isFuzzyMatch(title, name)
+			}
+			if match {
+				list = append(list, i)
+				seen[i] = true
+			}
+		}
+	}
+	return list
+}
+
+// findHeader picks the first of rows whose titles have every required column;
+// returns its index, the column map and the columns missing from the best row
+func (m *headerMatcher) findHeader(rows [][]string) (int, map[string]int, []string) {
+	best := -1
+	var bestColumns map[string]int
+	var bestMissing []string
+	for i, row := range rows {
+		columns, missing := m.match(row)
+		if 0 == len(missing) {
+			return i, columns, nil
+		}
+		if -1 == best || len(missing) < len(bestMissing) {
+			best, bestColumns, bestMissing = i, columns, missing
+		}
+	}
+	return best, bestColumns, bestMissing
+}
+
+// columnIndex looks name up in a column map made by match
+func columnIndex(columns map[string]int, name string) (int, bool) {
+	i, found := columns[name]
+	if !found {
+		i, found = columns[normalizeHeader(name)]
+	}
+	return i, found
+}
+
+// normalizeHeader lower cases s, turns punctuation into spaces and collapses
+// runs of spaces so "Customer_Name " and "customer name" are the same
+func normalizeHeader(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteRune(r)
+			space = false
+		} else {
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// isFuzzyMatch returns true if title abbreviates name word by word, like
+// "cust name" for "customer name" or "qty" for "quantity", or is a near miss
+// spelling of it
+func isFuzzyMatch(title, name string) bool {
+
+	// abbreviated words
+	tw := strings.Fields(title)
+	nw := strings.Fields(name)
+	if len(tw) == len(nw) {
+		abbreviated := true
+		for i := range tw {
+			if tw[i] != nw[i] && (len(tw[i]) < 2 || tw[i][0] != nw[i][0] || !isSubsequence(tw[i], nw[i])) {
+				abbreviated = false
+				break
+			}
+		}
+		if abbreviated {
+			return true
+		}
+	}
+
+	// typos; allow one edit for every 5 letters
+	t := []rune(strings.Replace(title, " ", "", -1))
+	n := []rune(strings.Replace(name, " ", "", -1))
+	if len(n) < 4 {
+		return false
+	}
+	return editDistance(t, n) <= len(n)/5
+}
+
+// isSubsequence returns true if the letters of a appear in order in b
+func isSubsequence(a, b string) bool {
+	letters := []rune(a)
+	i := 0
+	for _, r := range b {
+		if i < len(letters) && letters[i] == r {
+			i++
+		}
+	}
+	return i == len(letters)
+}
+
+// editDistance is the levenshtein distance between a and b
+func editDistance(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// minInt returns smaller of two ints
+func minInt(x, y int) int {
+	if x < y {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,178 @@
+package utl
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	l "github.com/stevenb256/log"
+)
+
+// ExcelTable is a table (list object) defined on a sheet of a workbook
+type ExcelTable struct {
+	Name    string     // display name used in formulas
+	Sheet   string     // sheet the table is on
+	Ref     string     // cells of the table including header and totals rows
+	Columns []string   // column names
+	Rows    [][]string // data rows without the header and totals rows
+}
+
+// xml layout of a table part
+type excelTablePart struct {
+	Name        string `xml:"name,attr"`
+	DisplayName string `xml:"displayName,attr"`
+	Ref         string `xml:"ref,attr"`
+	HeaderRows  *int   `xml:"headerRowCount,attr"`
+	TotalsRows  int    `xml:"totalsRowCount,attr"`
+	Columns     []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"tableColumns>tableColumn"`
+}
+
+// Cell gets the value of a cell by reference like "B7" on the current sheet or "Totals!B7"
+func (e *Excel) Cell(ref string) (string, error) {
+	sheet, r, c, err := e.evaluator().cellRef(e.sheet, ref)
+	if l.Check(err) {
+		return "", err
+	}
+	cell := sheetCell(sheet, r, c)
+	if nil == cell {
+		return "", nil
+	}
+	return cell.Value, nil
+}
+
+// Range gets the values of a block of cells like "A1:F20", "Totals!A:C" or a
+// defined name as rows of columns; cells past the end of the sheet are ""
+func (e *Excel) Range(ref string) ([][]string, error) {
+	r, err := e.evaluator().rangeRef(e.sheet, ref)
+	if l.Check(err) {
+		return nil, err
+	}
+	rows := make([][]string, 0, r.r2-r.r1+1)
+	for i := r.r1; i <= r.r2; i++ {
+		row := make([]string, r.c2-r.c1+1)
+		for j := range row {
+			if cell := sheetCell(r.sheet, i, r.c1+j); nil != cell {
+				row[j] = cell.Value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// NamedRange gets the values of a range defined by name in the workbook
+func (e *Excel) NamedRange(name string) ([][]string, error) {
+	for _, defined := range e.file.DefinedNames {
+		if strings.EqualFold(defined.Name, name) {
+			return e.Range(defined.Name)
+		}
+	}
+	return nil, l.Fail(fmt.Errorf("no range named '%s' in excel file", name))
+}
+
+// Names returns the names defined in the workbook
+func (e *Excel) Names() []string {
+	names := make([]string, len(e.file.DefinedNames))
+	for i, defined := range e.file.DefinedNames {
+		names[i] = defined.Name
+	}
+	return names
+}
+
+// Tables returns every table defined in the workbook with its rows
+func (e *Excel) Tables() ([]*ExcelTable, error) {
+
+	// locals
+	var tables []*ExcelTable
+
+	// open the zip; the xlsx package doesn't read tables
+	z, err := zip.NewReader(bytes.NewReader(e.data), int64(len(e.data)))
+	if l.Check(err) {
+		return nil, l.Fail(fmt.Errorf("can't open excel file: %s", err.Error()))
+	}
+	sheets, _, err := workbookSheets(z)
+	if l.Check(err) {
+		return nil, err
+	}
+
+	// tables hang off the relationships of each sheet
+	for _, sheet := range sheets {
+		var rels streamRelationships
+		dir, file := path.Split(sheet.path)
+		err = decodePart(z, path.Join(dir, "_rels", file+".rels"), &rels)
+		if os.ErrNotExist == err {
+			continue
+		}
+		if l.Check(err) {
+			return nil, l.Fail(fmt.Errorf("can't read relationships of sheet '%s': %s", sheet.name, err.Error()))
+		}
+		targets := rels.targets(dir)
+		for _, rel := range rels.Relationships {
+			if !strings.HasSuffix(rel.Type, "/table") {
+				continue
+			}
+			table, err := e.readTable(z, sheet.name, targets[rel.ID])
+			if l.Check(err) {
+				return nil, err
+			}
+			tables = append(tables, table)
+		}
+	}
+
+	// done
+	return tables, nil
+}
+
+// Table returns a table defined in the workbook by name
+func (e *Excel) Table(name string) (*ExcelTable, error) {
+	tables, err := e.Tables()
+	if l.Check(err) {
+		return nil, err
+	}
+	for _, table := range tables {
+		if strings.EqualFold(table.Name, name) {
+			return table, nil
+		}
+	}
+	return nil, l.Fail(fmt.Errorf("no table named '%s' in excel file", name))
+}
+
+// readTable reads a table part and the rows of the table from its sheet
+func (e *Excel) readTable(z *zip.Reader, sheet string, name string) (*ExcelTable, error) {
+
+	// read the part
+	var part excelTablePart
+	err := decodePart(z, name, &part)
+	if l.Check(err) {
+		return nil, l.Fail(fmt.Errorf("can't read table '%s': %s", name, err.Error()))
+	}
+	table := &ExcelTable{Name: part.DisplayName, Sheet: sheet, Ref: part.Ref}
+	if "" == table.Name {
+		table.Name = part.Name
+	}
+	for _, column := range part.Columns {
+		table.Columns = append(table.Columns, column.Name)
+	}
+
+	// read the cells less header and totals
+	rows, err := e.Range("'" + strings.Replace(sheet, "'", "''", -1) + "'!" + part.Ref)
+	if l.Check(err) {
+		return nil, err
+	}
+	header := 1
+	if nil != part.HeaderRows {
+		header = *part.HeaderRows
+	}
+	if header+part.TotalsRows > len(rows) {
+		return nil, l.Fail(fmt.Errorf("table '%s' has a bad range %s", table.Name, part.Ref))
+	}
+	table.Rows = rows[header : len(rows)-part.TotalsRows]
+
+	// done
+	return table, nil
+}
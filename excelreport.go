@@ -0,0 +1,175 @@
+package utl
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	l "github.com/stevenb256/log"
+	"github.com/tealeg/xlsx"
+)
+
+// ExcelErrorFill color used to highlight cells with errors in a report
+var ExcelErrorFill = "FFFFC7CE"
+
+// excelNote is a comment on a cell of a sheet
+type excelNote struct {
+	row  int
+	col  int
+	text string
+}
+
+// WriteReport writes a copy of the workbook with the cells of errs filled in
+// ExcelErrorFill and the error text attached to each cell as a comment; the
+// workbook being read is not changed
+func (e *Excel) WriteReport(writer io.Writer, errs ExcelErrors) error {
+
+	// locals
+	notes := make(map[string][]*excelNote)
+
+	// work on a copy
+	file, err := xlsx.OpenBinary(e.data)
+	if l.Check(err) {
+		return err
+	}
+
+	// gather messages per cell; errors for missing columns have no cell
+	cells := make(map[string]*excelNote)
+	for _, err := range errs {
+		if err.Index < 0 || err.Row < 1 {
+			continue
+		}
+		key := fmt.Sprintf("%s!%d!%d", err.Sheet, err.Row, err.Index)
+		note, found := cells[key]
+		if !found {
+			note = &excelNote{row: err.Row - 1, col: err.Index}
+			cells[key] = note
+			notes[err.Sheet] = append(notes[err.Sheet], note)
+		} else {
+			note.text += "\n"
+		}
+		note.text += err.Err.Error()
+	}
+
+	// highlight the cells
+	for name, list := range notes {
+		sheet, found := file.Sheet[name]
+		if !found {
+			continue
+		}
+		for _, note := range list {
+			fillCells([]*xlsx.Cell{sheet.Cell(note.row, note.col)}, ExcelErrorFill)
+		}
+	}
+
+	// get the parts of the workbook
+	parts, err := file.MarshallParts()
+	if l.Check(err) {
+		return err
+	}
+
+	// add comments to sheets; parts are named by sheet position
+	for i, sheet := range file.Sheets {
+		if list := notes[sheet.Name]; len(list) > 0 {
+			addComments(parts, i+1, list)
+		}
+	}
+
+	// write the zip
+	return writeParts(writer, parts)
+}
+
+// addComments adds a comments part and the vml drawing excel needs to show them to sheet n
+func addComments(parts map[string]string, n int, notes []*excelNote) {
+
+	// locals
+	var comments, shapes strings.Builder
+	sheetPath := fmt.Sprintf("xl/worksheets/sheet%d.xml", n)
+	relsPath := fmt.Sprintf("xl/worksheets/_rels/sheet%d.xml.rels", n)
+
+	// keep them in cell order
+	sort.Slice(notes, func(i, j int) bool {
+		if notes[i].row == notes[j].row {
+			return notes[i].col < notes[j].col
+		}
+		return notes[i].row < notes[j].row
+	})
+
+	// a comment and a shape per cell
+	for i, note := range notes {
+		fmt.Fprintf(&comments, `<comment ref="%s" authorId="0"><text><r><t xml:space="preserve">%s</t></r></text></comment>`,
+			xlsx.GetCellIDStringFromCoords(note.col, note.row), escapeXML(note.text))
+		fmt.Fprintf(&shapes, `<v:shape id="_x0000_s%d" type="#_x0000_t202" style="position:absolute;margin-left:60pt;margin-top:2pt;width:150pt;height:60pt;z-index:%d;visibility:hidden" fillcolor="#ffffe1" o:insetmode="auto">`+
+			`<v:fill color2="#ffffe1"/><v:shadow on="t" color="black" obscured="t"/><v:path o:connecttype="none"/>`+
+			`<v:textbox style="mso-direction-alt:auto"><div style="text-align:left"></div></v:textbox>`+
+			`<x:ClientData ObjectType="Note"><x:MoveWithCells/><x:SizeWithCells/><x:Anchor>%d, 15, %d, 2, %d, 15, %d, 16</x:Anchor>`+
+			`<x:AutoFill>False</x:AutoFill><x:Row>%d</x:Row><x:Column>%d</x:Column></x:ClientData></v:shape>`,
+			1024*n+i+1, i+1, note.col+1, note.row, note.col+3, note.row+4, note.row, note.col)
+	}
+
+	// comments part
+	parts[fmt.Sprintf("xl/comments%d.xml", n)] = xml.Header +
+		`<comments xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><authors><author>validation</author></authors><commentList>` +
+		comments.String() + `</commentList></comments>`
+
+	// vml drawing part
+	parts[fmt.Sprintf("xl/drawings/vmlDrawing%d.vml", n)] =
+		`<xml xmlns:v="urn:schemas-microsoft-com:vml" xmlns:o="urn:schemas-microsoft-com:office:office" xmlns:x="urn:schemas-microsoft-com:office:excel">` +
+			fmt.Sprintf(`<o:shapelayout v:ext="edit"><o:idmap v:ext="edit" data="%d"/></o:shapelayout>`, n) +
+			`<v:shapetype id="_x0000_t202" coordsize="21600,21600" o:spt="202" path="m,l,21600r21600,l21600,xe"><v:stroke joinstyle="miter"/><v:path gradientshapeok="t" o:connecttype="rect"/></v:shapetype>` +
+			shapes.String() + `</xml>`
+
+	// relate them to the sheet
+	relations := `<Relationship Id="rIdComments" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/comments" Target="../comments` + Itoa(n) + `.xml"/>` +
+		`<Relationship Id="rIdNotes" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/vmlDrawing" Target="../drawings/vmlDrawing` + Itoa(n) + `.vml"/>`
+	if rels, found := parts[relsPath]; found {
+		parts[relsPath] = strings.Replace(rels, "</Relationships>", relations+"</Relationships>", 1)
+	} else {
+		parts[relsPath] = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` + relations + `</Relationships>`
+	}
+	parts[sheetPath] = strings.Replace(parts[sheetPath], "</worksheet>",
+		`<legacyDrawing xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships" r:id="rIdNotes"/></worksheet>`, 1)
+
+	// content types
+	types := fmt.Sprintf(`<Override PartName="/xl/comments%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.comments+xml"/>`, n)
+	if !strings.Contains(parts["[Content_Types].xml"], `Extension="vml"`) {
+		types += `<Default Extension="vml" ContentType="application/vnd.openxmlformats-officedocument.vmlDrawing"/>`
+	}
+	parts["[Content_Types].xml"] = strings.Replace(parts["[Content_Types].xml"], "</Types>", types+"</Types>", 1)
+}
+
+// fillCells sets the background of cells to color
+func fillCells(cells []*xlsx.Cell, color string) {
+	for _, cell := range cells {
+		style := *cell.GetStyle()
+		style.Fill = *xlsx.NewFill(xlsx.Solid_Cell_Fill, color, color)
+		style.ApplyFill = true
+		cell.SetStyle(&style)
+	}
+}
+
+// writeParts zips workbook parts to writer
+func writeParts(writer io.Writer, parts map[string]string) error {
+	z := zip.NewWriter(writer)
+	for name, part := range parts {
+		w, err := z.Create(name)
+		if l.Check(err) {
+			return err
+		}
+		_, err = io.WriteString(w, part)
+		if l.Check(err) {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// escapeXML escapes text for use in xml content
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,607 @@
+package utl
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	l "github.com/stevenb256/log"
+	"github.com/tealeg/xlsx"
+)
+
+// ExcelStream reads an excel file a row at a time straight from the sheet xml
+// so memory stays bounded no matter how many rows there are; only the shared
+// string table is held in memory; merged header cells aren't filled across
+// since the merges are listed after the rows
+type ExcelStream struct {
+	zip      *zip.Reader
+	file     *os.File
+	date1904 bool
+	dates    ExcelDates
+	strings  []string
+	workbook []streamSheet
+	sheets   []streamSheet
+	s        int
+	matcher  *headerMatcher
+	search   int
+	columns  map[string]int
+	header   []string
+	reader   io.ReadCloser
+	decoder  *xml.Decoder
+	row      []streamCell
+	n        int
+	err      error
+}
+
+// streamSheet is a sheet name and the path of its xml in the zip
+type streamSheet struct {
+	name string
+	path string
+}
+
+// streamCell is a raw cell read from sheet xml
+type streamCell struct {
+	value string
+	kind  string
+}
+
+// xml layout of the workbook parts we need
+type streamWorkbook struct {
+	Properties struct {
+		Date1904 string `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+// xml layout of relationships
+type streamRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Type   string `xml:"Type,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// OpenExcelStreamFile opens an excel file on disk for streaming; call Close when done
+func OpenExcelStreamFile(path string, columns []string, options *ExcelOptions) (*ExcelStream, error) {
+
+	// open the file
+	file, err := os.Open(path)
+	if l.Check(err) {
+		return nil, err
+	}
+
+	// get size
+	info, err := file.Stat()
+	if l.Check(err) {
+		file.Close()
+		return nil, err
+	}
+
+	// open stream
+	stream, err := OpenExcelStream(file, info.Size(), columns, options)
+	if l.Check(err) {
+		file.Close()
+		return nil, err
+	}
+	stream.file = file
+
+	// done
+	return stream, nil
+}
+
+// OpenExcelStream opens an excel file for streaming; call Close when done
+func OpenExcelStream(reader io.ReaderAt, size int64, columns []string, options *ExcelOptions) (*ExcelStream, error) {
+
+	// locals
+	var stream ExcelStream
+
+	// default options
+	if nil == options {
+		options = &ExcelOptions{}
+	}
+
+	// open the zip
+	z, err := zip.NewReader(reader, size)
+	if l.Check(err) {
+		return nil, l.Fail(fmt.Errorf("can't open excel file: %s", err.Error()))
+	}
+	stream.zip = z
+	stream.matcher = newHeaderMatcher(columns, options)
+	stream.search = options.HeaderRows
+	stream.dates = options.Dates
+
+	// read workbook parts
+	err = stream.readWorkbook()
+	if l.Check(err) {
+		return nil, err
+	}
+	err = stream.readStrings()
+	if l.Check(err) {
+		return nil, err
+	}
+
+	// pick the sheets
+	stream.sheets, err = stream.selectSheets(options)
+	if l.Check(err) {
+		return nil, err
+	}
+
+	// open first sheet
+	stream.s = -1
+	if !stream.nextSheet() {
+		if nil == stream.err {
+			stream.err = l.Fail(errors.New("no rows in sheet"))
+		}
+		stream.Close()
+		return nil, stream.err
+	}
+
+	// done
+	return &stream, nil
+}
+
+// Close closes the sheet being read and the file if opened by path
+func (e *ExcelStream) Close() error {
+	e.closeSheet()
+	if nil != e.file {
+		err := e.file.Close()
+		e.file = nil
+		return err
+	}
+	return nil
+}
+
+// SheetName returns name of the sheet currently being read
+func (e *ExcelStream) SheetName() string {
+	if e.s < 0 || e.s >= len(e.sheets) {
+		return ""
+	}
+	return e.sheets[e.s].name
+}
+
+// SheetNames returns names of all sheets in the workbook
+func (e *ExcelStream) SheetNames() []string {
+	var names []string
+	for _, sheet := range e.workbook {
+		names = append(names, sheet.name)
+	}
+	return names
+}
+
+// Row returns the 1 based row number of the current row
+func (e *ExcelStream) Row() int {
+	return e.n
+}
+
+// Header returns the titles of the header row of the current sheet
+func (e *ExcelStream) Header() []string {
+	return e.header
+}
+
+// Values returns the values of the current row under the header
+func (e *ExcelStream) Values() []string {
+	values := make([]string, len(e.header))
+	for i := range values {
+		if i < len(e.row) {
+			values[i] = e.row[i].value
+		}
+	}
+	return values
+}
+
+// Err returns error that stopped reading, if any
+func (e *ExcelStream) Err() error {
+	return e.err
+}
+
+// IsDone returns true if no more rows
+func (e *ExcelStream) IsDone() bool {
+	for {
+		if nil != e.decoder && e.readRow() {
+			return false
+		}
+		if nil != e.err || !e.nextSheet() {
+			e.row = nil
+			return true
+		}
+	}
+}
+
+// String gets current row/column as string
+func (e *ExcelStream) String(name string) string {
+	cell := e.cell(name)
+	if nil == cell {
+		return ""
+	}
+	return cell.value
+}
+
+// Int gets current row/column as int
+func (e *ExcelStream) Int(name string) int {
+	cell := e.cell(name)
+	if nil == cell {
+		return 0
+	}
+	return Atoi(cell.value)
+}
+
+// Date gets current row column as date; numeric cells are serial dates and
+// text is parsed as set by ExcelOptions.Dates
+func (e *ExcelStream) Date(name string) (time.Time, error) {
+	cell := e.cell(name)
+	if nil == cell || "" == strings.TrimSpace(cell.value) {
+		return time.Time{}, nil
+	}
+	if "" == cell.kind || "n" == cell.kind {
+		f, err := strconv.ParseFloat(cell.value, 64)
+		if nil != err {
+			return time.Time{}, err
+		}
+		return e.dates.fromSerial(f, e.date1904), nil
+	}
+	return e.dates.parse(cell.value)
+}
+
+// cell returns cell of current row/column or nil if not present
+func (e *ExcelStream) cell(name string) *streamCell {
+	i, found := columnIndex(e.columns, name)
+	if !found || i >= len(e.row) {
+		return nil
+	}
+	return &e.row[i]
+}
+
+// open opens a file inside the zip
+func (e *ExcelStream) open(name string) (io.ReadCloser, error) {
+	return openPart(e.zip, name)
+}
+
+// readWorkbook reads sheet names, their paths and the date system
+func (e *ExcelStream) readWorkbook() error {
+	var err error
+	e.workbook, e.date1904, err = workbookSheets(e.zip)
+	return err
+}
+
+// openPart opens a file inside an excel zip
+func openPart(z *zip.Reader, name string) (io.ReadCloser, error) {
+	for _, f := range z.File {
+		if f.Name == name {
+			return f.Open()
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// decodePart unmarshals a whole xml part of an excel zip into v
+func decodePart(z *zip.Reader, name string, v interface{}) error {
+	r, err := openPart(z, name)
+	if nil != err {
+		return err
+	}
+	defer r.Close()
+	return xml.NewDecoder(r).Decode(v)
+}
+
+// workbookSheets reads sheet names, their paths and the date system of an excel zip
+func workbookSheets(z *zip.Reader) ([]streamSheet, bool, error) {
+
+	// locals
+	var workbook streamWorkbook
+	var rels streamRelationships
+	var sheets []streamSheet
+
+	// read workbook and its relationships
+	err := decodePart(z, "xl/workbook.xml", &workbook)
+	if l.Check(err) {
+		return nil, false, l.Fail(fmt.Errorf("can't read workbook from excel file: %s", err.Error()))
+	}
+	err = decodePart(z, "xl/_rels/workbook.xml.rels", &rels)
+	if l.Check(err) {
+		return nil, false, l.Fail(fmt.Errorf("can't read workbook relationships from excel file: %s", err.Error()))
+	}
+
+	// date system
+	date1904, _ := strconv.ParseBool(workbook.Properties.Date1904)
+
+	// resolve sheet paths
+	targets := rels.targets("xl")
+	for _, sheet := range workbook.Sheets {
+		sheets = append(sheets, streamSheet{name: sheet.Name, path: targets[sheet.ID]})
+	}
+
+	// if no sheets
+	if 0 == len(sheets) {
+		return nil, false, l.Fail(errors.New("no sheets in excel file"))
+	}
+
+	// done
+	return sheets, date1904, nil
+}
+
+// targets maps relationship ids to zip paths; dir is the folder of the part the relationships belong to
+func (r *streamRelationships) targets(dir string) map[string]string {
+	targets := make(map[string]string)
+	for _, rel := range r.Relationships {
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join(dir, rel.Target)
+		}
+	}
+	return targets
+}
+
+// readStrings reads the shared string table
+func (e *ExcelStream) readStrings() error {
+
+	// not all files have one
+	r, err := e.open("xl/sharedStrings.xml")
+	if os.ErrNotExist == err {
+		return nil
+	}
+	if l.Check(err) {
+		return err
+	}
+	defer r.Close()
+
+	// each si holds the text of all its runs
+	var text strings.Builder
+	depth := 0
+	inText := false
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if io.EOF == err {
+			return nil
+		}
+		if l.Check(err) {
+			return l.Fail(fmt.Errorf("can't read shared strings from excel file: %s", err.Error()))
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				text.Reset()
+			case "rPh":
+				depth++
+			case "t":
+				inText = 0 == depth
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				e.strings = append(e.strings, text.String())
+			case "rPh":
+				depth--
+			case "t":
+				inText = false
+			}
+		case xml.CharData:
+			if inText {
+				text.Write(t)
+			}
+		}
+	}
+}
+
+// selectSheets returns the sheets picked by options
+func (e *ExcelStream) selectSheets(options *ExcelOptions) ([]streamSheet, error) {
+	if options.AllSheets {
+		return e.workbook, nil
+	}
+	if "" != options.Sheet {
+		for _, sheet := range e.workbook {
+			if strings.EqualFold(options.Sheet, sheet.name) {
+				return []streamSheet{sheet}, nil
+			}
+		}
+		return nil, l.Fail(fmt.Errorf("no sheet named '%s' in excel file", options.Sheet))
+	}
+	if options.SheetIndex < 0 || options.SheetIndex >= len(e.workbook) {
+		return nil, l.Fail(fmt.Errorf("sheet index %d out of range; excel file has %d sheets", options.SheetIndex, len(e.workbook)))
+	}
+	return []streamSheet{e.workbook[options.SheetIndex]}, nil
+}
+
+// nextSheet opens the next sheet with a header row; false if none left
+func (e *ExcelStream) nextSheet() bool {
+	for e.s+1 < len(e.sheets) {
+
+		// close the last one
+		e.closeSheet()
+		e.columns = nil
+		e.n = 0
+
+		// open the sheet xml
+		e.s++
+		sheet := e.sheets[e.s]
+		e.reader, e.err = e.open(sheet.path)
+		if l.Check(e.err) {
+			e.err = l.Fail(fmt.Errorf("can't open sheet '%s': %s", sheet.name, e.err.Error()))
+			return false
+		}
+		e.decoder = xml.NewDecoder(e.reader)
+
+		// header is the first row with every required column, else the row
+		// missing the fewest is reported; skip sheets without rows
+		var missing []string
+		for i := 0; 0 == i || i < e.search; i++ {
+			if !e.readRow() {
+				break
+			}
+			header := make([]string, len(e.row))
+			for j, cell := range e.row {
+				header[j] = cell.value
+			}
+			columns, rowMissing := e.matcher.match(header)
+			if 0 == i || len(rowMissing) < len(missing) {
+				e.columns, e.header, missing = columns, header, rowMissing
+			}
+			if 0 == len(missing) {
+				break
+			}
+		}
+		if nil != e.err {
+			e.closeSheet()
+			return false
+		}
+		if nil == e.columns {
+			continue
+		}
+
+		// missing a required column?
+		if len(missing) > 0 {
+			e.err = l.Fail(fmt.Errorf("spreadsheet '%s' must have column headers: %s", sheet.name, strings.Join(missing, ", ")))
+			e.closeSheet()
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// closeSheet closes the xml of the sheet being read
+func (e *ExcelStream) closeSheet() {
+	if nil != e.reader {
+		e.reader.Close()
+		e.reader = nil
+	}
+	e.decoder = nil
+}
+
+// readRow reads the next row element of the sheet into e.row; false at end of sheet
+func (e *ExcelStream) readRow() bool {
+
+	// find the next row
+	for {
+		token, err := e.decoder.Token()
+		if io.EOF == err {
+			e.decoder = nil
+			return false
+		}
+		if l.Check(err) {
+			e.err = l.Fail(fmt.Errorf("can't read sheet '%s': %s", e.SheetName(), err.Error()))
+			e.decoder = nil
+			return false
+		}
+		if start, ok := token.(xml.StartElement); ok && "row" == start.Name.Local {
+			e.n++
+			if r, err := strconv.Atoi(attribute(start, "r")); nil == err {
+				e.n = r
+			}
+			break
+		}
+	}
+
+	// read its cells
+	e.row = e.row[:0]
+	for {
+		token, err := e.decoder.Token()
+		if l.Check(err) {
+			e.err = l.Fail(fmt.Errorf("can't read sheet '%s': %s", e.SheetName(), err.Error()))
+			e.decoder = nil
+			return false
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if "c" == t.Name.Local {
+				err = e.readCell(t)
+				if l.Check(err) {
+					e.err = err
+					e.decoder = nil
+					return false
+				}
+			}
+		case xml.EndElement:
+			if "row" == t.Name.Local {
+				return true
+			}
+		}
+	}
+}
+
+// readCell reads a c element into its column of e.row
+func (e *ExcelStream) readCell(start xml.StartElement) error {
+
+	// locals
+	var cell streamCell
+	var value strings.Builder
+	var inline strings.Builder
+	var element string
+
+	// where does it go
+	column := len(e.row)
+	if ref := attribute(start, "r"); "" != ref {
+		x, _, err := xlsx.GetCoordsFromCellIDString(ref)
+		if nil == err {
+			column = x
+		}
+	}
+	cell.kind = attribute(start, "t")
+
+	// read contents
+	for done := false; !done; {
+		token, err := e.decoder.Token()
+		if nil != err {
+			return l.Fail(fmt.Errorf("can't read sheet '%s': %s", e.SheetName(), err.Error()))
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			element = t.Name.Local
+		case xml.EndElement:
+			element = ""
+			done = "c" == t.Name.Local
+		case xml.CharData:
+			switch element {
+			case "v":
+				value.Write(t)
+			case "t":
+				inline.Write(t)
+			}
+		}
+	}
+
+	// resolve value by type
+	switch cell.kind {
+	case "s":
+		i, err := strconv.Atoi(value.String())
+		if nil == err && i >= 0 && i < len(e.strings) {
+			cell.value = e.strings[i]
+		}
+	case "inlineStr":
+		cell.value = inline.String()
+	default:
+		cell.value = value.String()
+	}
+
+	// put it in its column
+	for len(e.row) <= column {
+		e.row = append(e.row, streamCell{})
+	}
+	e.row[column] = cell
+
+	// done
+	return nil
+}
+
+// attribute returns value of an attribute of an element or ""
+func attribute(start xml.StartElement, name string) string {
+	for _, a := range start.Attr {
+		if name == a.Name.Local {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,243 @@
+package utl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	l "github.com/stevenb256/log"
+)
+
+// ExcelColumnRule declares the checks run on every value of a column
+type ExcelColumnRule struct {
+	Column    string
+	Required  bool      // column must be in the sheet and every cell must have a value
+	Pattern   string    // regular expression the value must match
+	Min       *float64  // smallest number allowed
+	Max       *float64  // largest number allowed
+	OneOf     []string  // allowed values, case insensitive
+	Unique    bool      // value may only appear once across all rows
+	NotBefore time.Time // earliest date allowed
+	NotAfter  time.Time // latest date allowed
+}
+
+// ExcelRowRule checks a row as a whole, e.g. one column against another;
+// Check returns an error describing the problem, reported against Column
+type ExcelRowRule struct {
+	Column string
+	Check  func(e *Excel) error
+}
+
+// ExcelRules used to validate every row of a sheet
+type ExcelRules struct {
+	Columns []ExcelColumnRule
+	Rows    []ExcelRowRule
+}
+
+// ExcelValidator checks rows against rules and remembers values for unique checks
+type ExcelValidator struct {
+	rules    *ExcelRules
+	patterns []*regexp.Regexp
+	seen     []map[string]excelCellRef
+}
+
+// excelCellRef is where a value was first seen
+type excelCellRef struct {
+	sheet string
+	row   int
+}
+
+// NewExcelValidator makes a validator; fails if a pattern doesn't compile
+func NewExcelValidator(rules *ExcelRules) (*ExcelValidator, error) {
+	v := &ExcelValidator{
+		rules:    rules,
+		patterns: make([]*regexp.Regexp, len(rules.Columns)),
+		seen:     make([]map[string]excelCellRef, len(rules.Columns)),
+	}
+	for i, rule := range rules.Columns {
+		if "" != rule.Pattern {
+			pattern, err := regexp.Compile(rule.Pattern)
+			if l.Check(err) {
+				return nil, l.Fail(fmt.Errorf("bad pattern for column '%s': %s", rule.Column, err.Error()))
+			}
+			v.patterns[i] = pattern
+		}
+		if rule.Unique {
+			v.seen[i] = make(map[string]excelCellRef)
+		}
+	}
+	return v, nil
+}
+
+// columns returns the required column names and optional columns the rules read
+func (r *ExcelRules) columns() ([]string, []ExcelColumn) {
+	var required []string
+	var optional []ExcelColumn
+	for _, rule := range r.Columns {
+		if rule.Required {
+			required = append(required, rule.Column)
+		} else {
+			optional = append(optional, ExcelColumn{Name: rule.Column, Optional: true})
+		}
+	}
+	for _, rule := range r.Rows {
+		if "" != rule.Column {
+			optional = append(optional, ExcelColumn{Name: rule.Column, Optional: true})
+		}
+	}
+	return required, optional
+}
+
+// ValidateExcel checks every row of an excel file against rules and returns all
+// the problems found; if report is not nil and there are problems a copy of
+// the workbook with the offending cells highlighted and commented is written to it
+func ValidateExcel(reader io.Reader, rules *ExcelRules, options *ExcelOptions, report io.Writer) (ExcelErrors, error) {
+
+	// locals
+	var errs ExcelErrors
+
+	// make validator
+	validator, err := NewExcelValidator(rules)
+	if l.Check(err) {
+		return nil, err
+	}
+
+	// read it all so the report can reuse it
+	data, err := ioutil.ReadAll(reader)
+	if l.Check(err) {
+		return nil, err
+	}
+
+	// open it
+	required, optional := rules.columns()
+	excel, err := OpenExcelWithOptions(bytes.NewReader(data), required, withColumns(options, optional))
+	if l.Check(err) {
+		return nil, err
+	}
+
+	// check rows
+	for !excel.IsDone() {
+		errs = append(errs, validator.Validate(excel)...)
+	}
+	if l.Check(excel.Err()) {
+		return nil, excel.Err()
+	}
+
+	// write report
+	if nil != report && len(errs) > 0 {
+		err = excel.WriteReport(report, errs)
+		if l.Check(err) {
+			return nil, err
+		}
+	}
+
+	// done
+	return errs, nil
+}
+
+// Validate checks the current row of e and returns every problem found
+func (v *ExcelValidator) Validate(e *Excel) ExcelErrors {
+
+	// locals
+	var errs ExcelErrors
+
+	// column rules
+	for i, rule := range v.rules.Columns {
+		err := v.checkColumn(e, i, rule)
+		if nil != err {
+			errs = append(errs, e.cellError(rule.Column, e.cell(rule.Column), err))
+		}
+	}
+
+	// row rules
+	for _, rule := range v.rules.Rows {
+		err := rule.Check(e)
+		if nil != err {
+			errs = append(errs, e.cellError(rule.Column, e.cell(rule.Column), err))
+		}
+	}
+
+	// done
+	return errs
+}
+
+// checkColumn runs the checks of one column rule on the current row
+func (v *ExcelValidator) checkColumn(e *Excel, i int, rule ExcelColumnRule) error {
+
+	// empty values only fail when required
+	value, err := e.Text(rule.Column)
+	if errors.Is(err, ErrEmptyCell) || errors.Is(err, ErrMissingColumn) {
+		if rule.Required {
+			return ErrRequiredValue
+		}
+		return nil
+	}
+	value = strings.TrimSpace(value)
+
+	// pattern
+	if nil != v.patterns[i] && !v.patterns[i].MatchString(value) {
+		return fmt.Errorf("must match %s", rule.Pattern)
+	}
+
+	// enum
+	if len(rule.OneOf) > 0 {
+		found := false
+		for _, allowed := range rule.OneOf {
+			if strings.EqualFold(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("must be one of: %s", strings.Join(rule.OneOf, ", "))
+		}
+	}
+
+	// range
+	if nil != rule.Min || nil != rule.Max {
+		f, err := e.Float64(rule.Column)
+		if nil != err {
+			return errors.New("must be a number")
+		}
+		if nil != rule.Min && f < *rule.Min {
+			return fmt.Errorf("must be at least %v", *rule.Min)
+		}
+		if nil != rule.Max && f > *rule.Max {
+			return fmt.Errorf("must be at most %v", *rule.Max)
+		}
+	}
+
+	// date bounds
+	if !rule.NotBefore.IsZero() || !rule.NotAfter.IsZero() {
+		t, err := e.Time(rule.Column)
+		if nil != err {
+			return errors.New("must be a date")
+		}
+		if !rule.NotBefore.IsZero() && t.Before(rule.NotBefore) {
+			return fmt.Errorf("must not be before %s", rule.NotBefore.Format("2006-01-02"))
+		}
+		if !rule.NotAfter.IsZero() && t.After(rule.NotAfter) {
+			return fmt.Errorf("must not be after %s", rule.NotAfter.Format("2006-01-02"))
+		}
+	}
+
+	// unique
+	if rule.Unique {
+		key := strings.ToLower(value)
+		if first, found := v.seen[i][key]; found {
+			if first.sheet != e.SheetName() {
+				return fmt.Errorf("duplicate of sheet '%s' row %d", first.sheet, first.row)
+			}
+			return fmt.Errorf("duplicate of row %d", first.row)
+		}
+		v.seen[i][key] = excelCellRef{sheet: e.SheetName(), row: e.Row()}
+	}
+
+	// done
+	return nil
+}
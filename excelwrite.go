@@ -0,0 +1,518 @@
+package utl
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	l "github.com/stevenb256/log"
+	"github.com/tealeg/xlsx"
+)
+
+// ExcelDateFormat default number format for time.Time cells
+var ExcelDateFormat = "yyyy-mm-dd"
+
+// ExcelSheetOptions used to control how a sheet is written
+type ExcelSheetOptions struct {
+	Widths       map[string]float64 // column widths by header name
+	Formats      map[string]string  // number or date formats by header name, e.g. "#,##0.00"
+	BoldHeader   bool               // header row in bold
+	FreezeHeader bool               // header row stays put when scrolling
+}
+
+// ExcelFill is a value written to a cell with a solid background color
+type ExcelFill struct {
+	Value interface{}
+	Color string // argb such as "FFFFC7CE"
+}
+
+// ExcelWriter streams an excel workbook to a writer a row at a time; rows go
+// out as they are added so only the styles and sheet names are kept in memory
+type ExcelWriter struct {
+	zip     *zip.Writer
+	sheet   *bufio.Writer
+	names   []string
+	formats []string
+	row     int
+	styles  excelStyles
+	err     error
+}
+
+// excelStyles collects the cell styles used while writing
+type excelStyles struct {
+	formats map[string]int // number format code to id
+	fills   []string       // fill colors after the two fills excel reserves
+	xfs     []excelXF
+	ids     map[excelXF]int
+}
+
+// excelXF is a cell style; ids are indexes into excelStyles.xfs
+type excelXF struct {
+	format int
+	bold   bool
+	fill   int
+}
+
+// NewExcelWriter starts a workbook written to writer; Close finishes it
+func NewExcelWriter(writer io.Writer) *ExcelWriter {
+	return &ExcelWriter{
+		zip: zip.NewWriter(writer),
+		styles: excelStyles{
+			formats: make(map[string]int),
+			xfs:     []excelXF{{}},
+			ids:     map[excelXF]int{{}: 0},
+		},
+	}
+}
+
+// MarshalExcel writes a slice of structs as a single sheet workbook
+func MarshalExcel(writer io.Writer, sheet string, slice interface{}, options *ExcelSheetOptions) error {
+	w := NewExcelWriter(writer)
+	err := w.AddSlice(sheet, slice, options)
+	if l.Check(err) {
+		return err
+	}
+	return w.Close()
+}
+
+// AddSlice adds a sheet with a header row from the xlsx tags or field names of
+// the slice element type and a row for each element
+func (w *ExcelWriter) AddSlice(name string, slice interface{}, options *ExcelSheetOptions) error {
+
+	// must be a slice
+	if !IsSlice(slice) {
+		return l.Fail(l.ErrInvalidArg, "AddSlice needs a slice")
+	}
+	v := reflect.ValueOf(slice)
+	for reflect.Ptr == v.Kind() {
+		v = v.Elem()
+	}
+
+	// header from fields
+	fields := GetTaggedFields(GetSliceElementType(v), "xlsx")
+	if 0 == len(fields) {
+		return l.Fail(l.ErrInvalidArg, "slice element has no fields to write")
+	}
+	header := make([]string, len(fields))
+	for i, f := range fields {
+		header[i] = f.Name
+	}
+	err := w.AddSheet(name, header, options)
+	if l.Check(err) {
+		return err
+	}
+
+	// a row per element
+	values := make([]interface{}, len(fields))
+	for i := 0; i < v.Len(); i++ {
+		element := v.Index(i)
+		for reflect.Ptr == element.Kind() && !element.IsNil() {
+			element = element.Elem()
+		}
+		for j, f := range fields {
+			values[j] = nil
+			if reflect.Struct == element.Kind() {
+				values[j] = GetFieldValue(element, f.Index, false).Interface()
+			}
+		}
+		err = w.AddRow(values...)
+		if l.Check(err) {
+			return err
+		}
+	}
+
+	// done
+	return nil
+}
+
+// AddRows adds a sheet with a header row and rows of values
+func (w *ExcelWriter) AddRows(name string, header []string, rows [][]interface{}, options *ExcelSheetOptions) error {
+	err := w.AddSheet(name, header, options)
+	if l.Check(err) {
+		return err
+	}
+	for _, values := range rows {
+		err = w.AddRow(values...)
+		if l.Check(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddSheet finishes the current sheet and starts another with a header row;
+// rows added after this go to the new sheet
+func (w *ExcelWriter) AddSheet(name string, header []string, options *ExcelSheetOptions) error {
+
+	// locals
+	if nil != w.err {
+		return w.err
+	}
+	o := ExcelSheetOptions{}
+	if nil != options {
+		o = *options
+	}
+	err := w.checkSheetName(name)
+	if l.Check(err) {
+		return err
+	}
+
+	// start the sheet
+	err = w.endSheet()
+	if l.Check(err) {
+		return w.fail(err)
+	}
+	entry, err := w.zip.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(w.names)+1))
+	if l.Check(err) {
+		return w.fail(err)
+	}
+	w.names = append(w.names, name)
+	w.sheet = bufio.NewWriter(entry)
+	w.row = 0
+	w.formats = make([]string, len(header))
+	for i, title := range header {
+		w.formats[i] = o.Formats[title]
+	}
+
+	// views and widths come before the rows
+	w.sheet.WriteString(xml.Header)
+	w.sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetViews><sheetView workbookViewId="0">`)
+	if o.FreezeHeader {
+		w.sheet.WriteString(`<pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/>`)
+	}
+	w.sheet.WriteString(`</sheetView></sheetViews>`)
+	widths := ""
+	for i, title := range header {
+		if width, found := o.Widths[title]; found {
+			widths += fmt.Sprintf(`<col min="%d" max="%d" width="%s" customWidth="1"/>`, i+1, i+1, strconv.FormatFloat(width, 'f', -1, 64))
+		}
+	}
+	if "" != widths {
+		w.sheet.WriteString("<cols>" + widths + "</cols>")
+	}
+	w.sheet.WriteString("<sheetData>")
+
+	// header row
+	w.row++
+	w.sheet.WriteString(fmt.Sprintf(`<row r="%d">`, w.row))
+	for i, title := range header {
+		w.writeCell(i, title, "", o.BoldHeader)
+	}
+	w.sheet.WriteString("</row>")
+
+	// done
+	return w.flush()
+}
+
+// AddRow writes a row of values to the current sheet; values line up with the
+// header and pick up its formats; ExcelFill values also color their cell
+func (w *ExcelWriter) AddRow(values ...interface{}) error {
+	if nil != w.err {
+		return w.err
+	}
+	if nil == w.sheet {
+		return l.Fail(l.ErrInvalidArg, "AddRow needs a sheet; call AddSheet first")
+	}
+	w.row++
+	w.sheet.WriteString(fmt.Sprintf(`<row r="%d">`, w.row))
+	for i, value := range values {
+		format := ""
+		if i < len(w.formats) {
+			format = w.formats[i]
+		}
+		w.writeCell(i, value, format, false)
+	}
+	w.sheet.WriteString("</row>")
+	return w.flush()
+}
+
+// Close finishes the last sheet and writes the parts that list the sheets and styles
+func (w *ExcelWriter) Close() error {
+
+	// locals
+	if nil != w.err {
+		return w.err
+	}
+	if 0 == len(w.names) {
+		return w.fail(l.Fail(l.ErrInvalidArg, "workbook has no sheets"))
+	}
+	err := w.endSheet()
+	if l.Check(err) {
+		return w.fail(err)
+	}
+
+	// workbook and how its parts fit together
+	var workbook, rels, types strings.Builder
+	types.WriteString(xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	workbook.WriteString(xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><bookViews><workbookView/></bookViews><sheets>`)
+	rels.WriteString(xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i, name := range w.names {
+		types.WriteString(fmt.Sprintf(`<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1))
+		workbook.WriteString(fmt.Sprintf(`<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escapeXML(name), i+1, i+1))
+		rels.WriteString(fmt.Sprintf(`<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1))
+	}
+	types.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets></workbook>`)
+	rels.WriteString(fmt.Sprintf(`<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`, len(w.names)+1))
+
+	// write them out
+	parts := []struct{ name, text string }{
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", rels.String()},
+		{"xl/styles.xml", w.styles.xml()},
+		{"[Content_Types].xml", types.String()},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	}
+	for _, part := range parts {
+		entry, err := w.zip.Create(part.name)
+		if nil == err {
+			_, err = io.WriteString(entry, part.text)
+		}
+		if nil != err {
+			return w.fail(l.Fail(err))
+		}
+	}
+
+	// done
+	err = w.zip.Close()
+	if nil != err {
+		return w.fail(l.Fail(err))
+	}
+	w.err = l.Fail(l.ErrInvalidArg, "excel writer is closed")
+	return nil
+}
+
+// fail keeps the first error; once a write fails the workbook is unusable
+func (w *ExcelWriter) fail(err error) error {
+	if nil == w.err {
+		w.err = err
+	}
+	return w.err
+}
+
+// flush sends what is buffered of the current sheet to the zip
+func (w *ExcelWriter) flush() error {
+	if w.sheet.Buffered() < 32*1024 {
+		return nil
+	}
+	err := w.sheet.Flush()
+	if nil != err {
+		return w.fail(l.Fail(err))
+	}
+	return nil
+}
+
+// endSheet closes the xml of the current sheet, if any
+func (w *ExcelWriter) endSheet() error {
+	if nil == w.sheet {
+		return nil
+	}
+	w.sheet.WriteString("</sheetData></worksheet>")
+	err := w.sheet.Flush()
+	w.sheet = nil
+	return err
+}
+
+// checkSheetName returns an error for names excel won't open
+func (w *ExcelWriter) checkSheetName(name string) error {
+	if "" == name || len([]rune(name)) > 31 || strings.ContainsAny(name, `:\/?*[]`) {
+		return l.Fail(l.ErrInvalidArg, fmt.Sprintf("can't add sheet '%s': names need 1 to 31 characters and none of :\\/?*[]", name))
+	}
+	for _, existing := range w.names {
+		if strings.EqualFold(existing, name) {
+			return l.Fail(l.ErrInvalidArg, fmt.Sprintf("can't add sheet '%s': there is already a sheet by that name", name))
+		}
+	}
+	return nil
+}
+
+// writeCell writes the cell in column i of the current row from a go value
+func (w *ExcelWriter) writeCell(i int, value interface{}, format string, bold bool) {
+
+	// locals
+	xf := excelXF{bold: bold}
+	if fill, ok := value.(ExcelFill); ok {
+		xf.fill = w.styles.fill(fill.Color)
+		value = fill.Value
+	}
+	kind, text := cellText(value)
+	if "d" == kind && "" == format {
+		format = ExcelDateFormat
+	}
+	if "" != format && "s" != kind {
+		xf.format = w.styles.format(format)
+	}
+	ref := xlsx.GetCellIDStringFromCoords(i, w.row-1)
+	style := ""
+	if id := w.styles.id(xf); 0 != id {
+		style = fmt.Sprintf(` s="%d"`, id)
+	}
+
+	// write by kind; empty cells are only written to keep their style
+	switch {
+	case "" == text:
+		if "" != style {
+			w.sheet.WriteString(fmt.Sprintf(`<c r="%s"%s/>`, ref, style))
+		}
+	case "s" == kind:
+		w.sheet.WriteString(fmt.Sprintf(`<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, escapeXML(text)))
+	case "b" == kind:
+		w.sheet.WriteString(fmt.Sprintf(`<c r="%s"%s t="b"><v>%s</v></c>`, ref, style, text))
+	default:
+		w.sheet.WriteString(fmt.Sprintf(`<c r="%s"%s><v>%s</v></c>`, ref, style, text))
+	}
+}
+
+// cellText returns how a go value is stored: "s" text, "n" a number, "d" a
+// serial date or "b" a bool, and the text of the value; nil is empty text
+func cellText(value interface{}) (string, string) {
+
+	// follow pointers
+	v := reflect.ValueOf(value)
+	for v.IsValid() && reflect.Ptr == v.Kind() {
+		if v.IsNil() {
+			return "s", ""
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return "s", ""
+	}
+	value = v.Interface()
+
+	// by type
+	switch t := value.(type) {
+	case time.Time:
+		if t.IsZero() {
+			return "s", ""
+		}
+		return "d", strconv.FormatFloat(excelTimeSerial(t, false), 'f', -1, 64)
+	case time.Duration:
+		return "s", t.String()
+	case encoding.TextMarshaler:
+		text, err := t.MarshalText()
+		if l.Check(err) {
+			return "s", ""
+		}
+		return "s", string(text)
+	case fmt.Stringer:
+		return "s", t.String()
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return "b", "1"
+		}
+		return "b", "0"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "n", strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "n", strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		if math.IsNaN(v.Float()) || math.IsInf(v.Float(), 0) {
+			return "n", ""
+		}
+		return "n", strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits())
+	case reflect.String:
+		return "s", v.String()
+	}
+	return "s", fmt.Sprint(value)
+}
+
+// format returns the id of a number format; custom ids start at 164
+func (s *excelStyles) format(code string) int {
+	id, found := s.formats[code]
+	if !found {
+		id = 164 + len(s.formats)
+		s.formats[code] = id
+	}
+	return id
+}
+
+// fill returns the id of a solid fill; excel reserves 0 and 1
+func (s *excelStyles) fill(color string) int {
+	for i, existing := range s.fills {
+		if existing == color {
+			return i + 2
+		}
+	}
+	s.fills = append(s.fills, color)
+	return len(s.fills) + 1
+}
+
+// id returns the id of a cell style
+func (s *excelStyles) id(xf excelXF) int {
+	id, found := s.ids[xf]
+	if !found {
+		id = len(s.xfs)
+		s.xfs = append(s.xfs, xf)
+		s.ids[xf] = id
+	}
+	return id
+}
+
+// xml returns the styles part
+func (s *excelStyles) xml() string {
+
+	// number formats in id order
+	var b strings.Builder
+	b.WriteString(xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	if 0 != len(s.formats) {
+		codes := make([]string, len(s.formats))
+		for code, id := range s.formats {
+			codes[id-164] = code
+		}
+		b.WriteString(fmt.Sprintf(`<numFmts count="%d">`, len(codes)))
+		for i, code := range codes {
+			b.WriteString(fmt.Sprintf(`<numFmt numFmtId="%d" formatCode="%s"/>`, 164+i, escapeXML(code)))
+		}
+		b.WriteString(`</numFmts>`)
+	}
+
+	// a plain and a bold font, the fills and one border
+	b.WriteString(`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>`)
+	b.WriteString(fmt.Sprintf(`<fills count="%d"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill>`, len(s.fills)+2))
+	for _, color := range s.fills {
+		b.WriteString(fmt.Sprintf(`<fill><patternFill patternType="solid"><fgColor rgb="%s"/><bgColor rgb="%s"/></patternFill></fill>`, escapeXML(color), escapeXML(color)))
+	}
+	b.WriteString(`</fills><borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>`)
+	b.WriteString(`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>`)
+
+	// cell styles
+	b.WriteString(fmt.Sprintf(`<cellXfs count="%d">`, len(s.xfs)))
+	for _, xf := range s.xfs {
+		font := 0
+		if xf.bold {
+			font = 1
+		}
+		b.WriteString(fmt.Sprintf(`<xf numFmtId="%d" fontId="%d" fillId="%d" borderId="0" xfId="0"`, xf.format, font, xf.fill))
+		if 0 != xf.format {
+			b.WriteString(` applyNumberFormat="1"`)
+		}
+		if xf.bold {
+			b.WriteString(` applyFont="1"`)
+		}
+		if 0 != xf.fill {
+			b.WriteString(` applyFill="1"`)
+		}
+		b.WriteString(`/>`)
+	}
+	b.WriteString(`</cellXfs><cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles></styleSheet>`)
+
+	// done
+	return b.String()
+}
@@ -0,0 +1,775 @@
+package utl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	l "github.com/stevenb256/log"
+)
+
+// WritableFS is an fs.FS that can also be changed; names are slash separated
+// and relative as fs.ValidPath wants
+type WritableFS interface {
+	fs.StatFS
+	fs.ReadFileFS
+	fs.ReadDirFS
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	MkdirAll(name string, perm fs.FileMode) error
+	Remove(name string) error
+	Rename(oldName, newName string) error
+}
+
+// errDirNotEmpty is returned when removing a directory with files in it
+var errDirNotEmpty = errors.New("directory not empty")
+
+// CopyFileFS copies a file within fsys keeping its permissions
+func CopyFileFS(fsys WritableFS, srcName, dstName string) error {
+	info, err := fsys.Stat(srcName)
+	if l.Check(err) {
+		return err
+	}
+	data, err := fsys.ReadFile(srcName)
+	if l.Check(err) {
+		return err
+	}
+	err = fsys.MkdirAll(path.Dir(dstName), os.ModePerm)
+	if l.Check(err) {
+		return err
+	}
+	err = fsys.WriteFile(dstName, data, info.Mode().Perm())
+	if nil != err {
+		return l.Fail(err)
+	}
+	return nil
+}
+
+// MoveFileFS copies a file within fsys and then deletes the source file
+func MoveFileFS(fsys WritableFS, srcName, dstName string) error {
+	err := CopyFileFS(fsys, srcName, dstName)
+	if l.Check(err) {
+		return err
+	}
+	err = fsys.Remove(srcName)
+	if nil != err {
+		return l.Fail(err)
+	}
+	return nil
+}
+
+// WriteFileFS writes buffer to name in fsys making its directory; an existing
+// file keeps its permissions
+func WriteFileFS(fsys WritableFS, name string, buffer []byte) error {
+	err := fsys.MkdirAll(path.Dir(name), os.ModePerm)
+	if l.Check(err) {
+		return err
+	}
+	perm := DefaultFilePerm
+	if info, err := fsys.Stat(name); nil == err {
+		perm = info.Mode().Perm()
+	}
+	err = fsys.WriteFile(name, buffer, perm)
+	if nil != err {
+		return l.Fail(err)
+	}
+	return nil
+}
+
+// DoesFileExistFS checks to see if name exists in fsys
+func DoesFileExistFS(fsys fs.FS, name string) bool {
+	_, err := fs.Stat(fsys, name)
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// IsDirectoryFS checks to see if name is a directory in fsys
+func IsDirectoryFS(fsys fs.FS, name string) bool {
+	info, err := fs.Stat(fsys, name)
+	return nil == err && info.IsDir()
+}
+
+// LoadJSONObjectFS reads a json object from a file in fsys
+func LoadJSONObjectFS(fsys fs.FS, name string, object interface{}) error {
+	data, err := fs.ReadFile(fsys, name)
+	if nil != err {
+		return l.Fail(err)
+	}
+	err = DecodeJSON(data, object, nil)
+	if nil != err {
+		return l.Fail(err)
+	}
+	return nil
+}
+
+// SaveJSONObjectFS writes a json object to a file in fsys
+func SaveJSONObjectFS(fsys WritableFS, name string, object interface{}) error {
+	var prettyJSON bytes.Buffer
+	data, err := json.Marshal(object)
+	if nil != err {
+		return l.Fail(err)
+	}
+	err = json.Indent(&prettyJSON, data, "", "\t")
+	if nil != err {
+		return l.Fail(err)
+	}
+	return WriteFileFS(fsys, name, prettyJSON.Bytes())
+}
+
+// OSFS is the disk below a directory; files are written atomically like WriteFile
+type OSFS struct {
+	root string
+}
+
+// NewOSFS returns the disk below root as a WritableFS
+func NewOSFS(root string) *OSFS {
+	return &OSFS{root: root}
+}
+
+// path returns the os path of name
+func (f *OSFS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(f.root, filepath.FromSlash(name)), nil
+}
+
+// Open opens a file for reading
+func (f *OSFS) Open(name string) (fs.File, error) {
+	p, err := f.path("open", name)
+	if nil != err {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+// Stat returns info about a file
+func (f *OSFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := f.path("stat", name)
+	if nil != err {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+// ReadFile returns the contents of a file
+func (f *OSFS) ReadFile(name string) ([]byte, error) {
+	p, err := f.path("read", name)
+	if nil != err {
+		return nil, err
+	}
+	return os.ReadFile(p)
+}
+
+// ReadDir returns the entries of a directory sorted by name
+func (f *OSFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := f.path("readdir", name)
+	if nil != err {
+		return nil, err
+	}
+	return os.ReadDir(p)
+}
+
+// WriteFile replaces a file atomically; its directory must exist
+func (f *OSFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	p, err := f.path("write", name)
+	if nil != err {
+		return err
+	}
+	if !IsDirectory(filepath.Dir(p)) {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrNotExist}
+	}
+	return WriteFileWithOptions(p, data, &WriteFileOptions{Perm: perm})
+}
+
+// MkdirAll makes a directory and any parents it needs
+func (f *OSFS) MkdirAll(name string, perm fs.FileMode) error {
+	p, err := f.path("mkdir", name)
+	if nil != err {
+		return err
+	}
+	return os.MkdirAll(p, perm)
+}
+
+// Remove deletes a file or empty directory
+func (f *OSFS) Remove(name string) error {
+	p, err := f.path("remove", name)
+	if nil != err {
+		return err
+	}
+	return os.Remove(p)
+}
+
+// Rename moves a file or directory
+func (f *OSFS) Rename(oldName, newName string) error {
+	from, err := f.path("rename", oldName)
+	if nil != err {
+		return err
+	}
+	to, err := f.path("rename", newName)
+	if nil != err {
+		return err
+	}
+	return os.Rename(from, to)
+}
+
+// MemoryFS keeps files in memory; safe for use by many go routines
+type MemoryFS struct {
+	sync.RWMutex
+	files map[string]*memoryFile
+}
+
+// memoryFile is a file or directory of a MemoryFS
+type memoryFile struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// NewMemoryFS returns an empty MemoryFS
+func NewMemoryFS() *MemoryFS {
+	return &MemoryFS{files: map[string]*memoryFile{
+		".": {mode: fs.ModeDir | os.ModePerm, modTime: time.Now()},
+	}}
+}
+
+// Open opens a file for reading; what is read doesn't change with later writes
+func (f *MemoryFS) Open(name string) (fs.File, error) {
+	f.RLock()
+	defer f.RUnlock()
+	file, err := f.lookup("open", name)
+	if nil != err {
+		return nil, err
+	}
+	open := &openFile{info: file.info(name)}
+	if file.mode.IsDir() {
+		open.entries = f.entries(name)
+	} else {
+		open.reader = bytes.NewReader(file.data)
+	}
+	return open, nil
+}
+
+// Stat returns info about a file
+func (f *MemoryFS) Stat(name string) (fs.FileInfo, error) {
+	f.RLock()
+	defer f.RUnlock()
+	file, err := f.lookup("stat", name)
+	if nil != err {
+		return nil, err
+	}
+	return file.info(name), nil
+}
+
+// ReadFile returns a copy of the contents of a file
+func (f *MemoryFS) ReadFile(name string) ([]byte, error) {
+	f.RLock()
+	defer f.RUnlock()
+	file, err := f.lookup("read", name)
+	if nil != err {
+		return nil, err
+	}
+	if file.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	return append([]byte{}, file.data...), nil
+}
+
+// ReadDir returns the entries of a directory sorted by name
+func (f *MemoryFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f.RLock()
+	defer f.RUnlock()
+	file, err := f.lookup("readdir", name)
+	if nil != err {
+		return nil, err
+	}
+	if !file.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return f.entries(name), nil
+}
+
+// WriteFile replaces a file with a copy of data; its directory must exist
+func (f *MemoryFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	f.Lock()
+	defer f.Unlock()
+	err := f.parent("write", name)
+	if nil != err {
+		return err
+	}
+	if file, ok := f.files[name]; ok && file.mode.IsDir() {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrExist}
+	}
+	f.files[name] = &memoryFile{data: append([]byte{}, data...), mode: perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+// MkdirAll makes a directory and any parents it needs
+func (f *MemoryFS) MkdirAll(name string, perm fs.FileMode) error {
+	f.Lock()
+	defer f.Unlock()
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	for dir := name; "." != dir; dir = path.Dir(dir) {
+		if file, ok := f.files[dir]; ok && !file.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrExist}
+		}
+	}
+	for dir := name; "." != dir; dir = path.Dir(dir) {
+		if _, ok := f.files[dir]; !ok {
+			f.files[dir] = &memoryFile{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+		}
+	}
+	return nil
+}
+
+// Remove deletes a file or empty directory
+func (f *MemoryFS) Remove(name string) error {
+	f.Lock()
+	defer f.Unlock()
+	file, err := f.lookup("remove", name)
+	if nil != err {
+		return err
+	}
+	if "." == name || file.mode.IsDir() && 0 != len(f.entries(name)) {
+		return &fs.PathError{Op: "remove", Path: name, Err: errDirNotEmpty}
+	}
+	delete(f.files, name)
+	return nil
+}
+
+// Rename moves a file or a directory with everything in it
+func (f *MemoryFS) Rename(oldName, newName string) error {
+
+	// locals
+	f.Lock()
+	defer f.Unlock()
+	file, err := f.lookup("rename", oldName)
+	if nil != err {
+		return err
+	}
+	err = f.parent("rename", newName)
+	if nil != err {
+		return err
+	}
+	if "." == oldName || strings.HasPrefix(newName+"/", oldName+"/") {
+		return &fs.PathError{Op: "rename", Path: newName, Err: fs.ErrInvalid}
+	}
+	if existing, ok := f.files[newName]; ok && existing.mode.IsDir() {
+		if !file.mode.IsDir() || 0 != len(f.entries(newName)) {
+			return &fs.PathError{Op: "rename", Path: newName, Err: fs.ErrExist}
+		}
+	}
+
+	// move it and anything below it
+	f.files[newName] = file
+	delete(f.files, oldName)
+	if file.mode.IsDir() {
+		for name, child := range f.files {
+			if strings.HasPrefix(name, oldName+"/") {
+				f.files[newName+strings.TrimPrefix(name, oldName)] = child
+				delete(f.files, name)
+			}
+		}
+	}
+
+	// done
+	return nil
+}
+
+// lookup returns the file called name
+func (f *MemoryFS) lookup(op, name string) (*memoryFile, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	file, ok := f.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return file, nil
+}
+
+// parent checks that the directory name goes in exists
+func (f *MemoryFS) parent(op, name string) error {
+	if !fs.ValidPath(name) || "." == name {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if dir, ok := f.files[path.Dir(name)]; !ok || !dir.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+// entries returns what is directly in directory name sorted by name
+func (f *MemoryFS) entries(name string) []fs.DirEntry {
+	entries := []fs.DirEntry{}
+	for child, file := range f.files {
+		if "." != child && name == path.Dir(child) {
+			entries = append(entries, fs.FileInfoToDirEntry(file.info(child)))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
+
+// info returns file info for the file called name
+func (file *memoryFile) info(name string) fs.FileInfo {
+	return &fileInfo{name: path.Base(name), size: int64(len(file.data)), mode: file.mode, modTime: file.modTime}
+}
+
+// fileInfo describes a file of a MemoryFS or OverlayFS
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+func (i *fileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *fileInfo) Sys() interface{}   { return nil }
+
+// openFile is an open file or directory of a MemoryFS or OverlayFS
+type openFile struct {
+	info    fs.FileInfo
+	reader  *bytes.Reader
+	entries []fs.DirEntry
+}
+
+// Stat returns info about the file
+func (o *openFile) Stat() (fs.FileInfo, error) {
+	return o.info, nil
+}
+
+// Read reads from a file
+func (o *openFile) Read(buffer []byte) (int, error) {
+	if nil == o.reader {
+		return 0, &fs.PathError{Op: "read", Path: o.info.Name(), Err: fs.ErrInvalid}
+	}
+	return o.reader.Read(buffer)
+}
+
+// Seek moves where the next read of a file starts
+func (o *openFile) Seek(offset int64, whence int) (int64, error) {
+	if nil == o.reader {
+		return 0, &fs.PathError{Op: "seek", Path: o.info.Name(), Err: fs.ErrInvalid}
+	}
+	return o.reader.Seek(offset, whence)
+}
+
+// ReadDir reads the next n entries of a directory, or all when n <= 0
+func (o *openFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if nil != o.reader {
+		return nil, &fs.PathError{Op: "readdir", Path: o.info.Name(), Err: fs.ErrInvalid}
+	}
+	if n <= 0 {
+		entries := o.entries
+		o.entries = nil
+		return entries, nil
+	}
+	if 0 == len(o.entries) {
+		return nil, io.EOF
+	}
+	if n > len(o.entries) {
+		n = len(o.entries)
+	}
+	entries := o.entries[:n]
+	o.entries = o.entries[n:]
+	return entries, nil
+}
+
+// Close closes the file
+func (o *openFile) Close() error {
+	return nil
+}
+
+// OverlayFS writes to an upper file system over a lower one that is only read;
+// files removed from the lower one are hidden rather than deleted
+type OverlayFS struct {
+	sync.RWMutex
+	lower   fs.FS
+	upper   WritableFS
+	removed map[string]bool
+}
+
+// NewOverlayFS returns lower with changes kept in upper, or in memory when upper is nil
+func NewOverlayFS(lower fs.FS, upper WritableFS) *OverlayFS {
+	if nil == upper {
+		upper = NewMemoryFS()
+	}
+	return &OverlayFS{lower: lower, upper: upper, removed: make(map[string]bool)}
+}
+
+// Open opens a file for reading; directories list both file systems
+func (o *OverlayFS) Open(name string) (fs.File, error) {
+	o.RLock()
+	defer o.RUnlock()
+	info, err := o.stat("open", name)
+	if nil != err {
+		return nil, err
+	}
+	if info.IsDir() {
+		entries, err := o.readDir("open", name)
+		if nil != err {
+			return nil, err
+		}
+		return &openFile{info: info, entries: entries}, nil
+	}
+	if _, err := o.upper.Stat(name); nil == err {
+		return o.upper.Open(name)
+	}
+	return o.lower.Open(name)
+}
+
+// Stat returns info about a file
+func (o *OverlayFS) Stat(name string) (fs.FileInfo, error) {
+	o.RLock()
+	defer o.RUnlock()
+	return o.stat("stat", name)
+}
+
+// ReadFile returns the contents of a file
+func (o *OverlayFS) ReadFile(name string) ([]byte, error) {
+	o.RLock()
+	defer o.RUnlock()
+	return o.readFile(name)
+}
+
+// ReadDir returns the entries of a directory from both file systems sorted by name
+func (o *OverlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	o.RLock()
+	defer o.RUnlock()
+	return o.readDir("readdir", name)
+}
+
+// WriteFile writes a file to the upper file system; its directory must exist
+func (o *OverlayFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	o.Lock()
+	defer o.Unlock()
+	if !fs.ValidPath(name) || "." == name {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	err := o.mkdirAll("write", path.Dir(name), os.ModePerm, false)
+	if nil != err {
+		return err
+	}
+	if info, err := o.stat("write", name); nil == err && info.IsDir() {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrExist}
+	}
+	err = o.upper.WriteFile(name, data, perm)
+	if nil != err {
+		return err
+	}
+	delete(o.removed, name)
+	return nil
+}
+
+// MkdirAll makes a directory and any parents it needs in the upper file system
+func (o *OverlayFS) MkdirAll(name string, perm fs.FileMode) error {
+	o.Lock()
+	defer o.Unlock()
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return o.mkdirAll("mkdir", name, perm, true)
+}
+
+// Remove deletes a file or empty directory, hiding it if it is in the lower file system
+func (o *OverlayFS) Remove(name string) error {
+	o.Lock()
+	defer o.Unlock()
+	return o.remove(name)
+}
+
+// Rename moves a file or a directory with everything in it
+func (o *OverlayFS) Rename(oldName, newName string) error {
+	o.Lock()
+	defer o.Unlock()
+	return o.rename(oldName, newName)
+}
+
+// rename moves a file or directory with the lock held
+func (o *OverlayFS) rename(oldName, newName string) error {
+
+	// locals
+	info, err := o.stat("rename", oldName)
+	if nil != err {
+		return err
+	}
+	if "." == oldName || strings.HasPrefix(newName+"/", oldName+"/") {
+		return &fs.PathError{Op: "rename", Path: newName, Err: fs.ErrInvalid}
+	}
+	err = o.mkdirAll("rename", path.Dir(newName), os.ModePerm, false)
+	if nil != err {
+		return err
+	}
+
+	// files only in the upper one can be moved there
+	if _, err := fs.Stat(o.lower, oldName); nil != err || o.removed[oldName] {
+		if _, err := o.stat("rename", newName); errors.Is(err, fs.ErrNotExist) {
+			return o.upper.Rename(oldName, newName)
+		}
+	}
+
+	// otherwise copy then remove
+	if !info.IsDir() {
+		data, err := o.readFile(oldName)
+		if nil == err {
+			err = o.upper.WriteFile(newName, data, info.Mode().Perm())
+		}
+		if nil != err {
+			return err
+		}
+		delete(o.removed, newName)
+		return o.remove(oldName)
+	}
+	err = o.mkdirAll("rename", newName, info.Mode().Perm(), true)
+	if nil != err {
+		return err
+	}
+	entries, err := o.readDir("rename", oldName)
+	if nil != err {
+		return err
+	}
+	for _, entry := range entries {
+		err = o.rename(path.Join(oldName, entry.Name()), path.Join(newName, entry.Name()))
+		if nil != err {
+			return err
+		}
+	}
+
+	// done
+	return o.remove(oldName)
+}
+
+// readFile returns the contents of a file from the upper or lower file system
+func (o *OverlayFS) readFile(name string) ([]byte, error) {
+	if _, err := o.upper.Stat(name); nil == err {
+		return o.upper.ReadFile(name)
+	}
+	if o.removed[name] {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	return fs.ReadFile(o.lower, name)
+}
+
+// stat returns info about a file from the upper or lower file system
+func (o *OverlayFS) stat(op, name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if info, err := o.upper.Stat(name); nil == err {
+		return info, nil
+	}
+	if o.removed[name] {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	info, err := fs.Stat(o.lower, name)
+	if nil != err {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return info, nil
+}
+
+// readDir merges a directory of both file systems
+func (o *OverlayFS) readDir(op, name string) ([]fs.DirEntry, error) {
+
+	// locals
+	info, err := o.stat(op, name)
+	if nil != err {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	merged := make(map[string]fs.DirEntry)
+
+	// lower ones that are still there, then upper ones over them
+	if lower, err := fs.ReadDir(o.lower, name); nil == err {
+		for _, entry := range lower {
+			if !o.removed[path.Join(name, entry.Name())] {
+				merged[entry.Name()] = entry
+			}
+		}
+	}
+	if upper, err := o.upper.ReadDir(name); nil == err {
+		for _, entry := range upper {
+			merged[entry.Name()] = entry
+		}
+	}
+
+	// done
+	entries := make([]fs.DirEntry, 0, len(merged))
+	for _, entry := range merged {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// mkdirAll makes directories in the upper file system for name and its
+// parents; when create is false they must already be there in one or other
+func (o *OverlayFS) mkdirAll(op, name string, perm fs.FileMode, create bool) error {
+	for dir := name; "." != dir; dir = path.Dir(dir) {
+		info, err := o.stat(op, dir)
+		if nil == err && !info.IsDir() {
+			return &fs.PathError{Op: op, Path: dir, Err: fs.ErrExist}
+		}
+		if nil != err && !create {
+			return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+	}
+	err := o.upper.MkdirAll(name, perm)
+	if nil != err {
+		return err
+	}
+	for dir := name; "." != dir; dir = path.Dir(dir) {
+		delete(o.removed, dir)
+	}
+	return nil
+}
+
+// remove deletes from the upper file system and hides what is in the lower one
+func (o *OverlayFS) remove(name string) error {
+	info, err := o.stat("remove", name)
+	if nil != err {
+		return err
+	}
+	if "." == name {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	if info.IsDir() {
+		entries, err := o.readDir("remove", name)
+		if nil != err {
+			return err
+		}
+		if 0 != len(entries) {
+			return &fs.PathError{Op: "remove", Path: name, Err: errDirNotEmpty}
+		}
+	}
+	if _, err := o.upper.Stat(name); nil == err {
+		err = o.upper.Remove(name)
+		if nil != err {
+			return err
+		}
+	}
+	if _, err := fs.Stat(o.lower, name); nil == err {
+		o.removed[name] = true
+	}
+	return nil
+}
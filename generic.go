@@ -8,12 +8,13 @@ import (
 	"fmt"
 	"hash/fnv"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
 	l "github.com/stevenb256/log"
 )
@@ -45,6 +46,38 @@ func Atoi(s string) int {
 	return i
 }
 
+// TimeLayouts are the layouts ParseTime tries in order
+var TimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseTime parses s with the first of TimeLayouts that fits
+func ParseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range TimeLayouts {
+		t, err := time.Parse(layout, s)
+		if nil == err {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("can't parse '%s' as a time", s)
+}
+
+// ParseBool parses true/false values including yes/no and y/n
+func ParseBool(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "yes", "y":
+		return true, nil
+	case "no", "n":
+		return false, nil
+	}
+	return strconv.ParseBool(strings.TrimSpace(s))
+}
+
 // Percent computes percent value
 func Percent(v1, v2 int) int {
 	if v2 == 0 {
@@ -199,36 +232,9 @@ func Clean(path string) string {
 	return filepath.Clean(path)
 }
 
-// WriteFile writes buffer into path
+// WriteFile replaces path atomically with buffer; see WriteFileWithOptions
 func WriteFile(path string, buffer []byte) error {
-
-	// make sure directory exists
-	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
-	if l.Check(err) {
-		return err
-	}
-
-	// create/lock the local file
-	file, err := os.Create(path)
-	if l.Check(err) {
-		return err
-	}
-	defer file.Close()
-
-	// write the contents
-	_, err = file.Write(buffer)
-	if l.Check(err) {
-		return err
-	}
-
-	// truncate the file
-	err = file.Truncate(int64(len(buffer)))
-	if l.Check(err) {
-		return err
-	}
-
-	// set the size
-	return nil
+	return WriteFileWithOptions(path, buffer, nil)
 }
 
 // join
@@ -245,19 +251,17 @@ func DoesFileExist(name string) bool {
 
 // used to read a json object form a file
 func LoadJSONObject(path string, object interface{}) error {
-	jsonText, err := ioutil.ReadFile(path)
-	if nil != err {
-		return l.Fail(err)
-	}
-	err = json.Unmarshal(jsonText, object)
-	if nil != err {
-		return l.Fail(err)
-	}
-	return nil
+	return LoadJSONObjectWithOptions(path, object, nil)
 }
 
 // used to write a json object form a file
 func SaveJSONObject(path string, object interface{}) error {
+	return SaveJSONObjectWithOptions(path, object, nil)
+}
+
+// SaveJSONObjectWithOptions writes a json object to a file atomically with
+// permissions and backups from options
+func SaveJSONObjectWithOptions(path string, object interface{}, options *WriteFileOptions) error {
 	var prettyJSON bytes.Buffer
 	data, err := json.Marshal(object)
 	if nil != err {
@@ -267,9 +271,5 @@ func SaveJSONObject(path string, object interface{}) error {
 	if nil != err {
 		return l.Fail(err)
 	}
-	err = ioutil.WriteFile(path, prettyJSON.Bytes(), os.ModePerm)
-	if nil != err {
-		return l.Fail(err)
-	}
-	return nil
+	return WriteFileWithOptions(path, prettyJSON.Bytes(), options)
 }
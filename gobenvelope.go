@@ -0,0 +1,276 @@
+package utl
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"sync"
+
+	l "github.com/stevenb256/log"
+)
+
+// ErrGobNotRegistered type was not registered with RegisterGobType
+var ErrGobNotRegistered = l.NewError(300, "gob", "type is not registered")
+
+// ErrGobNoMigration no migration registered from a schema version
+var ErrGobNoMigration = l.NewError(301, "gob", "no migration from schema version")
+
+// ErrGobWrongType envelope holds a different type than asked for
+var ErrGobWrongType = l.NewError(302, "gob", "envelope holds a different type")
+
+// gobEnvelopeMagic starts every envelope; a gob stream never starts with a zero
+// length message so bare gob bytes can't be mistaken for an envelope
+var gobEnvelopeMagic = []byte("\x00utlgob")
+
+// GobEnvelope is gob bytes of an object with the name and schema version of its type
+type GobEnvelope struct {
+	Type    string
+	Version int
+	Data    []byte
+}
+
+// GobMigration turns an object of one schema version into the next version
+type GobMigration func(old interface{}) (interface{}, error)
+
+// gobSchema is what is known about a registered type
+type gobSchema struct {
+	t          reflect.Type
+	version    int
+	old        map[int]reflect.Type
+	migrations map[int]GobMigration
+}
+
+// registry of types by name and the names given to types
+var gobSchemas = struct {
+	sync.RWMutex
+	types map[string]*gobSchema
+	names map[reflect.Type]string
+}{types: make(map[string]*gobSchema), names: make(map[reflect.Type]string)}
+
+// GobTypeName returns the name envelopes give the type of object: the name it
+// was registered with by RegisterGobTypeName, otherwise its package path and
+// name, so types of the same name in different packages differ. That name is
+// stored with the data, so moving or renaming the package of a type makes old
+// envelopes unreadable unless the type is registered by its old name
+func GobTypeName(object interface{}) string {
+	t := GetNonPtrType(object)
+	gobSchemas.RLock()
+	name, found := gobSchemas.names[t]
+	gobSchemas.RUnlock()
+	if found {
+		return name
+	}
+	if "" == t.PkgPath() {
+		return t.String()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
+
+// RegisterGobType registers the type of object, named by GobTypeName, at its
+// current schema version; bump the version whenever fields change meaning
+func RegisterGobType(object interface{}, version int) error {
+	return RegisterGobTypeName(object, GobTypeName(object), version)
+}
+
+// RegisterGobTypeName registers the type of object under a name of its own, one
+// that stays the same when the type moves, at its current schema version
+func RegisterGobTypeName(object interface{}, name string, version int) error {
+	t := GetNonPtrType(object)
+	gobSchemas.Lock()
+	defer gobSchemas.Unlock()
+	if schema, found := gobSchemas.types[name]; found && schema.t != t {
+		return l.Fail(fmt.Errorf("gob type name '%s' is already used by %s", name, schema.t.String()))
+	}
+	if registered, found := gobSchemas.names[t]; found && registered != name {
+		return l.Fail(fmt.Errorf("gob type %s is already registered as '%s'", t.String(), registered))
+	}
+	schema := gobSchemas.types[name]
+	if nil == schema {
+		schema = &gobSchema{old: make(map[int]reflect.Type), migrations: make(map[int]GobMigration)}
+		gobSchemas.types[name] = schema
+	}
+	schema.t, schema.version = t, version
+	gobSchemas.names[t] = name
+	return nil
+}
+
+// RegisterGobMigration registers how to upgrade the registered type of object
+// from schema version from to from+1; old is a value of the struct as it was at
+// version from and migrate gets a pointer to one and returns the next version
+func RegisterGobMigration(object interface{}, from int, old interface{}, migrate GobMigration) error {
+	name := GobTypeName(object)
+	gobSchemas.Lock()
+	defer gobSchemas.Unlock()
+	schema, found := gobSchemas.types[name]
+	if !found {
+		return l.Fail(ErrGobNotRegistered, name)
+	}
+	schema.old[from] = GetNonPtrType(old)
+	schema.migrations[from] = migrate
+	return nil
+}
+
+// gobSchemaOf returns a copy of the schema registered under name, so it can be
+// read while types and migrations are being registered
+func gobSchemaOf(name string) (*gobSchema, error) {
+	gobSchemas.RLock()
+	defer gobSchemas.RUnlock()
+	schema, found := gobSchemas.types[name]
+	if !found {
+		return nil, l.Fail(ErrGobNotRegistered, name)
+	}
+	copied := &gobSchema{t: schema.t, version: schema.version, old: make(map[int]reflect.Type), migrations: make(map[int]GobMigration)}
+	for version, t := range schema.old {
+		copied.old[version] = t
+	}
+	for version, migrate := range schema.migrations {
+		copied.migrations[version] = migrate
+	}
+	return copied, nil
+}
+
+// GobEncodeEnvelope encodes object in an envelope with its type name and schema version
+func GobEncodeEnvelope(object interface{}) ([]byte, error) {
+
+	// must be registered
+	name := GobTypeName(object)
+	schema, err := gobSchemaOf(name)
+	if l.Check(err) {
+		return nil, err
+	}
+
+	// encode object then envelope
+	data, err := GobEncode(object)
+	if l.Check(err) {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(append([]byte{}, gobEnvelopeMagic...))
+	err = gob.NewEncoder(buf).Encode(&GobEnvelope{Type: name, Version: schema.version, Data: data})
+	if l.Check(err) {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// OpenGobEnvelope reads the envelope of buf without decoding the object; bare
+// gob bytes give an envelope with no type and version 0
+func OpenGobEnvelope(buf []byte) (*GobEnvelope, error) {
+	if !bytes.HasPrefix(buf, gobEnvelopeMagic) {
+		return &GobEnvelope{Data: buf}, nil
+	}
+	var envelope GobEnvelope
+	err := GobDecode(buf[len(gobEnvelopeMagic):], &envelope)
+	if l.Check(err) {
+		return nil, err
+	}
+	return &envelope, nil
+}
+
+// GobDecodeEnvelope decodes an envelope into object, migrating it from older
+// schema versions; bare gob bytes from GobEncode are taken as version 0
+func GobDecodeEnvelope(buf []byte, object interface{}) error {
+
+	// must be pointer
+	if reflect.Ptr != reflect.ValueOf(object).Kind() {
+		return l.Fail(l.ErrInvalidArg, "GobDecodeEnvelope needs a pointer")
+	}
+
+	// open envelope
+	envelope, err := OpenGobEnvelope(buf)
+	if l.Check(err) {
+		return err
+	}
+	name := GobTypeName(object)
+	if "" == envelope.Type {
+		envelope.Type = name
+	}
+	if name != envelope.Type {
+		return l.Fail(ErrGobWrongType, fmt.Sprintf("want %s, have %s", name, envelope.Type))
+	}
+
+	// decode and upgrade
+	value, err := envelope.decode()
+	if l.Check(err) {
+		return err
+	}
+	reflect.ValueOf(object).Elem().Set(value.Elem())
+
+	// done
+	return nil
+}
+
+// GobDecodeAny decodes an envelope into a new object of the registered type,
+// migrated to the current version; returns a pointer to it
+func GobDecodeAny(buf []byte) (interface{}, error) {
+	envelope, err := OpenGobEnvelope(buf)
+	if l.Check(err) {
+		return nil, err
+	}
+	if "" == envelope.Type {
+		return nil, l.Fail(ErrGobNotRegistered, "bare gob bytes have no type name")
+	}
+	value, err := envelope.decode()
+	if l.Check(err) {
+		return nil, err
+	}
+	return value.Interface(), nil
+}
+
+// decode decodes the data of the envelope and migrates it to the current
+// version; returns a pointer to the current type
+func (envelope *GobEnvelope) decode() (reflect.Value, error) {
+
+	// find the type
+	schema, err := gobSchemaOf(envelope.Type)
+	if l.Check(err) {
+		return reflect.Value{}, err
+	}
+	if envelope.Version > schema.version {
+		return reflect.Value{}, l.Fail(fmt.Errorf("%s schema version %d is newer than %d", envelope.Type, envelope.Version, schema.version))
+	}
+
+	// decode into the type the data was written as; older versions, bare gob
+	// bytes included, need a migration
+	t := schema.t
+	if envelope.Version < schema.version {
+		old, found := schema.old[envelope.Version]
+		if !found {
+			return reflect.Value{}, l.Fail(ErrGobNoMigration, fmt.Sprintf("%s version %d", envelope.Type, envelope.Version))
+		}
+		t = old
+	}
+	value := reflect.New(t)
+	err = GobDecode(envelope.Data, value.Interface())
+	if l.Check(err) {
+		return reflect.Value{}, err
+	}
+	if envelope.Version == schema.version {
+		return value, nil
+	}
+
+	// upgrade a version at a time
+	object := value.Interface()
+	for version := envelope.Version; version < schema.version; version++ {
+		migrate, found := schema.migrations[version]
+		if !found {
+			return reflect.Value{}, l.Fail(ErrGobNoMigration, fmt.Sprintf("%s version %d", envelope.Type, version))
+		}
+		object, err = migrate(object)
+		if l.Check(err) {
+			return reflect.Value{}, err
+		}
+	}
+
+	// migrations may give a value or a pointer
+	result := reflect.ValueOf(object)
+	if reflect.Ptr != result.Kind() {
+		ptr := reflect.New(result.Type())
+		ptr.Elem().Set(result)
+		result = ptr
+	}
+	if result.Type().Elem() != schema.t {
+		return reflect.Value{}, l.Fail(fmt.Errorf("migrations of %s gave %s", envelope.Type, result.Type().String()))
+	}
+	return result, nil
+}
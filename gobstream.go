@@ -0,0 +1,500 @@
+package utl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+
+	l "github.com/stevenb256/log"
+)
+
+// kinds of frame in a gob stream; each frame is a kind byte, a uvarint length
+// and that many bytes holding gob messages
+const (
+	gobFrameType   = 't' // type descriptors, sent once per type
+	gobFrameRecord = 'r' // one encoded object
+	gobFrameIndex  = 'i' // offsets of every frame, last in a file
+)
+
+// gobIndexMagic ends a gob file that was closed with its index
+var gobIndexMagic = []byte("utlgobix")
+
+// gobRecord is where a record is in a file and how many type frames come before it
+type gobRecord struct {
+	offset int64
+	types  int
+}
+
+// GobStreamEncoder writes objects as length prefixed records sharing one gob
+// encoder, so type descriptors are only written the first time a type is seen
+type GobStreamEncoder struct {
+	w       io.Writer
+	buf     bytes.Buffer
+	enc     *gob.Encoder
+	n       int64
+	types   []int64
+	records []gobRecord
+}
+
+// NewGobStreamEncoder returns an encoder writing frames to w
+func NewGobStreamEncoder(w io.Writer) *GobStreamEncoder {
+	e := &GobStreamEncoder{w: w}
+	e.enc = gob.NewEncoder(&e.buf)
+	return e
+}
+
+// Encode writes object as the next record
+func (e *GobStreamEncoder) Encode(object interface{}) error {
+
+	// encode into buffer
+	e.buf.Reset()
+	err := e.enc.Encode(object)
+	if l.Check(err) {
+		return err
+	}
+
+	// type descriptors and the value go in their own frames
+	data := e.buf.Bytes()
+	for len(data) > 0 {
+		size, id, err := gobMessage(data)
+		if l.Check(err) {
+			return err
+		}
+		kind := byte(gobFrameRecord)
+		if id < 0 {
+			kind = gobFrameType
+			e.types = append(e.types, e.n)
+		} else {
+			e.records = append(e.records, gobRecord{offset: e.n, types: len(e.types)})
+		}
+		err = e.writeFrame(kind, data[:size])
+		if l.Check(err) {
+			return err
+		}
+		data = data[size:]
+	}
+
+	// done
+	return nil
+}
+
+// writeFrame writes a frame and counts the bytes
+func (e *GobStreamEncoder) writeFrame(kind byte, payload []byte) error {
+	head := make([]byte, 1, 1+binary.MaxVarintLen64)
+	head[0] = kind
+	head = binary.AppendUvarint(head, uint64(len(payload)))
+	for _, b := range [][]byte{head, payload} {
+		n, err := e.w.Write(b)
+		e.n += int64(n)
+		if l.Check(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// gobMessage returns the length of the first gob message in data and its type
+// id; negative ids define types
+func gobMessage(data []byte) (int, int64, error) {
+	count, n, err := gobUint(data)
+	if nil != err {
+		return 0, 0, err
+	}
+	if uint64(len(data)-n) < count {
+		return 0, 0, fmt.Errorf("gob message is cut short")
+	}
+	u, _, err := gobUint(data[n:])
+	if nil != err {
+		return 0, 0, err
+	}
+	id := int64(u >> 1)
+	if 0 != u&1 {
+		id = ^id
+	}
+	return n + int(count), id, nil
+}
+
+// gobUint decodes a gob unsigned int; small values are one byte, others a
+// negated byte count then big endian bytes
+func gobUint(data []byte) (uint64, int, error) {
+	if 0 == len(data) {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	if data[0] < 0x80 {
+		return uint64(data[0]), 1, nil
+	}
+	n := -int(int8(data[0]))
+	if n > 8 || len(data) < 1+n {
+		return 0, 0, fmt.Errorf("bad gob uint")
+	}
+	var u uint64
+	for _, b := range data[1 : 1+n] {
+		u = u<<8 | uint64(b)
+	}
+	return u, 1 + n, nil
+}
+
+// GobStreamDecoder reads objects written by a GobStreamEncoder in order
+type GobStreamDecoder struct {
+	frames *gobFrameReader
+	dec    *gob.Decoder
+}
+
+// NewGobStreamDecoder returns a decoder reading frames from r
+func NewGobStreamDecoder(r io.Reader) *GobStreamDecoder {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		b := bufio.NewReader(r)
+		r, br = b, b
+	}
+	frames := &gobFrameReader{r: r, br: br}
+	return &GobStreamDecoder{frames: frames, dec: gob.NewDecoder(frames)}
+}
+
+// Decode reads the next record into object; returns io.EOF after the last one
+func (d *GobStreamDecoder) Decode(object interface{}) error {
+	return d.dec.Decode(object)
+}
+
+// gobFrameReader joins the payloads of type and record frames back into one
+// gob stream; an index frame ends it
+type gobFrameReader struct {
+	r    io.Reader
+	br   io.ByteReader
+	left uint64
+	done bool
+}
+
+// next moves to the next frame once the current one is used up
+func (f *gobFrameReader) next() error {
+	for 0 == f.left {
+		if f.done {
+			return io.EOF
+		}
+		kind, err := f.br.ReadByte()
+		if nil != err {
+			f.done = true
+			return err
+		}
+		if gobFrameIndex == kind {
+			f.done = true
+			return io.EOF
+		}
+		if gobFrameType != kind && gobFrameRecord != kind {
+			f.done = true
+			return fmt.Errorf("bad gob frame kind %d", kind)
+		}
+		f.left, err = binary.ReadUvarint(f.br)
+		if nil != err {
+			f.done = true
+			return unexpectedEOF(err)
+		}
+	}
+	return nil
+}
+
+// Read reads frame payloads
+func (f *gobFrameReader) Read(p []byte) (int, error) {
+	err := f.next()
+	if nil != err {
+		return 0, err
+	}
+	if uint64(len(p)) > f.left {
+		p = p[:f.left]
+	}
+	n, err := f.r.Read(p)
+	f.left -= uint64(n)
+	if io.EOF == err && 0 != f.left {
+		err = io.ErrUnexpectedEOF
+	}
+	if io.EOF == err {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadByte reads a byte of frame payloads; gob needs it to not buffer past a message
+func (f *gobFrameReader) ReadByte() (byte, error) {
+	err := f.next()
+	if nil != err {
+		return 0, err
+	}
+	b, err := f.br.ReadByte()
+	if nil != err {
+		return 0, unexpectedEOF(err)
+	}
+	f.left--
+	return b, nil
+}
+
+// unexpectedEOF turns io.EOF inside a frame into io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if io.EOF == err {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// GobFileWriter writes records to a file and an index of them on Close so the
+// file can be read in any order with OpenGobFile
+type GobFileWriter struct {
+	file *os.File
+	w    *bufio.Writer
+	enc  *GobStreamEncoder
+}
+
+// CreateGobFile creates or truncates a gob file
+func CreateGobFile(path string) (*GobFileWriter, error) {
+	file, err := os.Create(path)
+	if l.Check(err) {
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	return &GobFileWriter{file: file, w: w, enc: NewGobStreamEncoder(w)}, nil
+}
+
+// Encode appends object as the next record
+func (g *GobFileWriter) Encode(object interface{}) error {
+	return g.enc.Encode(object)
+}
+
+// Len returns the number of records written
+func (g *GobFileWriter) Len() int {
+	return len(g.enc.records)
+}
+
+// Close writes the index and closes the file
+func (g *GobFileWriter) Close() error {
+
+	// index frame then its offset and magic
+	offset := g.enc.n
+	var index []byte
+	index = binary.AppendUvarint(index, uint64(len(g.enc.types)))
+	for _, n := range g.enc.types {
+		index = binary.AppendUvarint(index, uint64(n))
+	}
+	index = binary.AppendUvarint(index, uint64(len(g.enc.records)))
+	for _, record := range g.enc.records {
+		index = binary.AppendUvarint(index, uint64(record.offset))
+		index = binary.AppendUvarint(index, uint64(record.types))
+	}
+	err := g.enc.writeFrame(gobFrameIndex, index)
+	if nil == err {
+		tail := binary.BigEndian.AppendUint64(nil, uint64(offset))
+		_, err = g.w.Write(append(tail, gobIndexMagic...))
+	}
+
+	// flush and close
+	if nil == err {
+		err = g.w.Flush()
+	}
+	if e := g.file.Close(); nil == err {
+		err = e
+	}
+	if l.Check(err) {
+		return err
+	}
+	return nil
+}
+
+// GobFile reads records of a gob file by number
+type GobFile struct {
+	file    *os.File
+	size    int64
+	types   []int64
+	records []gobRecord
+	buf     bytes.Buffer
+	dec     *gob.Decoder
+	fed     int
+}
+
+// OpenGobFile opens a file written by GobFileWriter; a file that was not closed
+// is scanned to find its records
+func OpenGobFile(path string) (*GobFile, error) {
+
+	// open it
+	file, err := os.Open(path)
+	if l.Check(err) {
+		return nil, err
+	}
+	g := &GobFile{file: file}
+	g.dec = gob.NewDecoder(&g.buf)
+	info, err := file.Stat()
+	if l.Check(err) {
+		file.Close()
+		return nil, err
+	}
+	g.size = info.Size()
+
+	// read the index or rebuild it
+	err = g.readIndex()
+	if nil != err {
+		err = g.scan()
+	}
+	if l.Check(err) {
+		file.Close()
+		return nil, err
+	}
+
+	// done
+	return g, nil
+}
+
+// readIndex reads the index written on close
+func (g *GobFile) readIndex() error {
+
+	// tail has the offset of the index
+	tail := make([]byte, 8+len(gobIndexMagic))
+	if g.size < int64(len(tail)) {
+		return io.ErrUnexpectedEOF
+	}
+	_, err := g.file.ReadAt(tail, g.size-int64(len(tail)))
+	if nil != err {
+		return err
+	}
+	if !bytes.Equal(tail[8:], gobIndexMagic) {
+		return fmt.Errorf("gob file has no index")
+	}
+	offset := int64(binary.BigEndian.Uint64(tail))
+
+	// read the index frame
+	kind, index, err := g.frameAt(offset)
+	if nil != err {
+		return err
+	}
+	if gobFrameIndex != kind {
+		return fmt.Errorf("gob file index is not at %d", offset)
+	}
+	r := bytes.NewReader(index)
+	count, err := binary.ReadUvarint(r)
+	for i := uint64(0); nil == err && i < count; i++ {
+		var n uint64
+		n, err = binary.ReadUvarint(r)
+		g.types = append(g.types, int64(n))
+	}
+	if nil == err {
+		count, err = binary.ReadUvarint(r)
+	}
+	for i := uint64(0); nil == err && i < count; i++ {
+		var n, types uint64
+		n, err = binary.ReadUvarint(r)
+		if nil == err {
+			types, err = binary.ReadUvarint(r)
+		}
+		g.records = append(g.records, gobRecord{offset: int64(n), types: int(types)})
+	}
+	if nil != err {
+		g.types, g.records = nil, nil
+		return err
+	}
+	return nil
+}
+
+// scan finds the frames of a file with no index; a frame cut short ends it
+func (g *GobFile) scan() error {
+	r := bufio.NewReader(io.NewSectionReader(g.file, 0, 1<<62))
+	var offset int64
+	for {
+		kind, err := r.ReadByte()
+		if io.EOF == err || gobFrameIndex == kind {
+			return nil
+		}
+		if nil != err {
+			return err
+		}
+		if gobFrameType != kind && gobFrameRecord != kind {
+			return fmt.Errorf("bad gob frame kind %d at %d", kind, offset)
+		}
+		size, err := binary.ReadUvarint(r)
+		if nil != err {
+			return nil
+		}
+		head := 1 + int64(binary.PutUvarint(make([]byte, binary.MaxVarintLen64), size))
+		if size > uint64(g.size-offset-head) {
+			return nil
+		}
+		if n, _ := r.Discard(int(size)); n < int(size) {
+			return nil
+		}
+		if gobFrameType == kind {
+			g.types = append(g.types, offset)
+		} else {
+			g.records = append(g.records, gobRecord{offset: offset, types: len(g.types)})
+		}
+		offset += head + int64(size)
+	}
+}
+
+// frameAt reads the frame at offset; a size past the end of the file is an error
+func (g *GobFile) frameAt(offset int64) (byte, []byte, error) {
+	if offset < 0 || offset >= g.size {
+		return 0, nil, fmt.Errorf("gob frame at %d is past the end of the file", offset)
+	}
+	r := bufio.NewReader(io.NewSectionReader(g.file, offset, 1<<62))
+	kind, err := r.ReadByte()
+	if nil != err {
+		return 0, nil, unexpectedEOF(err)
+	}
+	size, err := binary.ReadUvarint(r)
+	if nil != err {
+		return 0, nil, unexpectedEOF(err)
+	}
+	head := 1 + int64(binary.PutUvarint(make([]byte, binary.MaxVarintLen64), size))
+	if size > uint64(g.size-offset-head) {
+		return 0, nil, fmt.Errorf("gob frame at %d of %d bytes is past the end of the file", offset, size)
+	}
+	payload := make([]byte, size)
+	_, err = io.ReadFull(r, payload)
+	if nil != err {
+		return 0, nil, unexpectedEOF(err)
+	}
+	return kind, payload, nil
+}
+
+// Len returns the number of records
+func (g *GobFile) Len() int {
+	return len(g.records)
+}
+
+// Read decodes record i into object
+func (g *GobFile) Read(i int, object interface{}) error {
+
+	// in range
+	if i < 0 || i >= len(g.records) {
+		return l.Fail(l.ErrInvalidArg, fmt.Sprintf("gob record %d of %d", i, len(g.records)))
+	}
+	record := g.records[i]
+
+	// decoder needs every type described before the record
+	g.buf.Reset()
+	for ; g.fed < record.types; g.fed++ {
+		_, payload, err := g.frameAt(g.types[g.fed])
+		if l.Check(err) {
+			return err
+		}
+		g.buf.Write(payload)
+	}
+
+	// then the record
+	_, payload, err := g.frameAt(record.offset)
+	if l.Check(err) {
+		return err
+	}
+	g.buf.Write(payload)
+	err = g.dec.Decode(object)
+	if l.Check(err) {
+		return err
+	}
+
+	// done
+	return nil
+}
+
+// Close closes the file
+func (g *GobFile) Close() error {
+	return g.file.Close()
+}
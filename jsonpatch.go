@@ -0,0 +1,621 @@
+package utl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	l "github.com/stevenb256/log"
+)
+
+// JSONPatchOperation is one operation of an rfc 6902 json patch
+type JSONPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatch is an rfc 6902 json patch; operations apply in order and all or none do
+type JSONPatch []JSONPatchOperation
+
+// ParseJSONPointer splits an rfc 6901 json pointer such as "/servers/2/port"
+// into its unescaped tokens; "" is the whole document
+func ParseJSONPointer(pointer string) ([]string, error) {
+	if "" == pointer {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("json pointer '%s' must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// JSONPointer joins tokens into an escaped json pointer
+func JSONPointer(tokens ...string) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteString("/")
+		b.WriteString(strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1))
+	}
+	return b.String()
+}
+
+// JSONPointerGet returns the value at pointer in doc, a value decoded from
+// json into interface{}
+func JSONPointerGet(doc interface{}, pointer string) (interface{}, error) {
+	tokens, err := ParseJSONPointer(pointer)
+	if nil != err {
+		return nil, err
+	}
+	for _, token := range tokens {
+		doc, err = jsonChild(doc, token)
+		if nil != err {
+			return nil, fmt.Errorf("%s: %s", pointer, err.Error())
+		}
+	}
+	return doc, nil
+}
+
+// JSONPointerSet sets the value at pointer in doc, adding a member or inserting
+// into an array as a json patch add does; returns doc as changed
+func JSONPointerSet(doc interface{}, pointer string, value interface{}) (interface{}, error) {
+	return jsonPatchAdd(doc, pointer, value)
+}
+
+// GetByJSONPointer copies the value at pointer in object into out
+func GetByJSONPointer(object interface{}, pointer string, out interface{}) error {
+	doc, err := toJSONGeneric(object)
+	if l.Check(err) {
+		return err
+	}
+	value, err := JSONPointerGet(doc, pointer)
+	if nil != err {
+		return l.Fail(err)
+	}
+	return fromJSONGeneric(value, out)
+}
+
+// SetByJSONPointer sets the value at pointer in object, a pointer to a go value
+func SetByJSONPointer(object interface{}, pointer string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if nil != err {
+		return l.Fail(err)
+	}
+	return ApplyJSONPatchToObject(object, JSONPatch{{Op: "add", Path: pointer, Value: raw}})
+}
+
+// ApplyJSONPatch applies an rfc 6902 patch to a json document
+func ApplyJSONPatch(doc []byte, patch JSONPatch) ([]byte, error) {
+
+	// decode
+	target, err := decodeJSONGeneric(doc)
+	if nil != err {
+		return nil, l.Fail(err)
+	}
+
+	// apply each
+	for i, op := range patch {
+		target, err = op.apply(target)
+		if nil != err {
+			return nil, l.Fail(fmt.Errorf("json patch operation %d %s %s: %w", i, op.Op, op.Path, err))
+		}
+	}
+
+	// done
+	return json.Marshal(target)
+}
+
+// ApplyJSONPatchToObject applies an rfc 6902 patch to object, a pointer to a go value
+func ApplyJSONPatchToObject(object interface{}, patch JSONPatch) error {
+	return patchObject(object, func(doc []byte) ([]byte, error) {
+		return ApplyJSONPatch(doc, patch)
+	})
+}
+
+// ApplyJSONPatchToFile applies an rfc 6902 patch to a json file
+func ApplyJSONPatchToFile(path string, patch JSONPatch) error {
+	return patchFile(path, func(doc []byte) ([]byte, error) {
+		return ApplyJSONPatch(doc, patch)
+	})
+}
+
+// MergePatch applies an rfc 7386 merge patch to a json document: members of
+// the patch replace those of the document and null members remove them
+func MergePatch(doc []byte, patch []byte) ([]byte, error) {
+	target, err := decodeJSONGeneric(doc)
+	if nil != err {
+		return nil, l.Fail(err)
+	}
+	changes, err := decodeJSONGeneric(patch)
+	if nil != err {
+		return nil, l.Fail(err)
+	}
+	return json.Marshal(mergePatch(target, changes))
+}
+
+// ApplyMergePatchToObject applies an rfc 7386 merge patch to object, a pointer to a go value
+func ApplyMergePatchToObject(object interface{}, patch []byte) error {
+	return patchObject(object, func(doc []byte) ([]byte, error) {
+		return MergePatch(doc, patch)
+	})
+}
+
+// ApplyMergePatchToFile applies an rfc 7386 merge patch to a json file
+func ApplyMergePatchToFile(path string, patch []byte) error {
+	return patchFile(path, func(doc []byte) ([]byte, error) {
+		return MergePatch(doc, patch)
+	})
+}
+
+// CreateJSONPatch returns a patch that turns old into new, both go values;
+// arrays of different lengths are replaced whole
+func CreateJSONPatch(old interface{}, new interface{}) (JSONPatch, error) {
+	a, err := toJSONGeneric(old)
+	if l.Check(err) {
+		return nil, err
+	}
+	b, err := toJSONGeneric(new)
+	if l.Check(err) {
+		return nil, err
+	}
+	patch := JSONPatch{}
+	err = diffJSON(&patch, nil, a, b)
+	if l.Check(err) {
+		return nil, err
+	}
+	return patch, nil
+}
+
+// mergePatch merges changes into target
+func mergePatch(target interface{}, changes interface{}) interface{} {
+	patch, ok := changes.(map[string]interface{})
+	if !ok {
+		return changes
+	}
+	doc, ok := target.(map[string]interface{})
+	if !ok {
+		doc = make(map[string]interface{})
+	}
+	for key, value := range patch {
+		if nil == value {
+			delete(doc, key)
+		} else {
+			doc[key] = mergePatch(doc[key], value)
+		}
+	}
+	return doc
+}
+
+// diffJSON adds operations turning a into b at path
+func diffJSON(patch *JSONPatch, path []string, a interface{}, b interface{}) error {
+
+	// objects compare member by member
+	ma, okA := a.(map[string]interface{})
+	mb, okB := b.(map[string]interface{})
+	if okA && okB {
+		for _, key := range sortedJSONKeys(ma) {
+			if _, found := mb[key]; !found {
+				*patch = append(*patch, JSONPatchOperation{Op: "remove", Path: JSONPointer(append(path, key)...)})
+			}
+		}
+		for _, key := range sortedJSONKeys(mb) {
+			child := append(append([]string{}, path...), key)
+			if value, found := ma[key]; found {
+				if err := diffJSON(patch, child, value, mb[key]); nil != err {
+					return err
+				}
+				continue
+			}
+			if err := patch.add("add", child, mb[key]); nil != err {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// arrays of the same length compare element by element
+	la, okA := a.([]interface{})
+	lb, okB := b.([]interface{})
+	if okA && okB && len(la) == len(lb) {
+		for i := range la {
+			child := append(append([]string{}, path...), strconv.Itoa(i))
+			if err := diffJSON(patch, child, la[i], lb[i]); nil != err {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// anything else is replaced
+	if !jsonEqual(a, b) {
+		return patch.add("replace", path, b)
+	}
+	return nil
+}
+
+// add appends an operation with a value
+func (patch *JSONPatch) add(op string, path []string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if nil != err {
+		return err
+	}
+	*patch = append(*patch, JSONPatchOperation{Op: op, Path: JSONPointer(path...), Value: raw})
+	return nil
+}
+
+// apply applies one operation to doc and returns it changed
+func (op *JSONPatchOperation) apply(doc interface{}) (interface{}, error) {
+
+	// value of the operation
+	var value interface{}
+	if "add" == op.Op || "replace" == op.Op || "test" == op.Op {
+		if nil == op.Value {
+			return nil, fmt.Errorf("missing value")
+		}
+		v, err := decodeJSONGeneric(op.Value)
+		if nil != err {
+			return nil, err
+		}
+		value = v
+	}
+
+	// apply
+	switch op.Op {
+	case "add":
+		return jsonPatchAdd(doc, op.Path, value)
+	case "remove":
+		doc, _, err := jsonPatchRemove(doc, op.Path)
+		return doc, err
+	case "replace":
+		if _, err := JSONPointerGet(doc, op.Path); nil != err {
+			return nil, err
+		}
+		if "" == op.Path {
+			return value, nil
+		}
+		doc, _, err := jsonPatchRemove(doc, op.Path)
+		if nil != err {
+			return nil, err
+		}
+		return jsonPatchAdd(doc, op.Path, value)
+	case "move":
+		if op.Path != op.From && strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("can't move %s into itself", op.From)
+		}
+		if op.Path == op.From {
+			_, err := JSONPointerGet(doc, op.From)
+			return doc, err
+		}
+		doc, moved, err := jsonPatchRemove(doc, op.From)
+		if nil != err {
+			return nil, err
+		}
+		return jsonPatchAdd(doc, op.Path, moved)
+	case "copy":
+		copied, err := JSONPointerGet(doc, op.From)
+		if nil != err {
+			return nil, err
+		}
+		return jsonPatchAdd(doc, op.Path, jsonDeepCopy(copied))
+	case "test":
+		current, err := JSONPointerGet(doc, op.Path)
+		if nil != err {
+			return nil, err
+		}
+		if !jsonEqual(current, value) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unknown operation '%s'", op.Op)
+}
+
+// jsonPatchAdd adds value at pointer
+func jsonPatchAdd(doc interface{}, pointer string, value interface{}) (interface{}, error) {
+	tokens, err := ParseJSONPointer(pointer)
+	if nil != err {
+		return nil, err
+	}
+	return jsonPatchAt(doc, tokens, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[token] = value
+			return c, nil
+		case []interface{}:
+			i := len(c)
+			if "-" != token {
+				i, err = jsonIndex(token, len(c)+1)
+				if nil != err {
+					return nil, err
+				}
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		}
+		return nil, fmt.Errorf("can't add '%s' to a %s", token, jsonKind(container))
+	}, value)
+}
+
+// jsonPatchRemove removes the value at pointer and returns it
+func jsonPatchRemove(doc interface{}, pointer string) (interface{}, interface{}, error) {
+	tokens, err := ParseJSONPointer(pointer)
+	if nil != err {
+		return nil, nil, err
+	}
+	if 0 == len(tokens) {
+		return nil, nil, fmt.Errorf("can't remove the whole document")
+	}
+	var removed interface{}
+	doc, err = jsonPatchAt(doc, tokens, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			value, found := c[token]
+			if !found {
+				return nil, fmt.Errorf("no member '%s'", token)
+			}
+			removed = value
+			delete(c, token)
+			return c, nil
+		case []interface{}:
+			i, err := jsonIndex(token, len(c))
+			if nil != err {
+				return nil, err
+			}
+			removed = c[i]
+			return append(c[:i], c[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("can't remove '%s' from a %s", token, jsonKind(container))
+	}, nil)
+	return doc, removed, err
+}
+
+// jsonPatchAt calls change on the container holding the last token and puts
+// the changed containers back; no tokens replaces doc with whole
+func jsonPatchAt(doc interface{}, tokens []string, change func(interface{}, string) (interface{}, error), whole interface{}) (interface{}, error) {
+	if 0 == len(tokens) {
+		return whole, nil
+	}
+	if 1 == len(tokens) {
+		return change(doc, tokens[0])
+	}
+	child, err := jsonChild(doc, tokens[0])
+	if nil != err {
+		return nil, err
+	}
+	child, err = jsonPatchAt(child, tokens[1:], change, whole)
+	if nil != err {
+		return nil, err
+	}
+	switch c := doc.(type) {
+	case map[string]interface{}:
+		c[tokens[0]] = child
+	case []interface{}:
+		i, _ := jsonIndex(tokens[0], len(c))
+		c[i] = child
+	}
+	return doc, nil
+}
+
+// jsonChild returns the member or element token of doc
+func jsonChild(doc interface{}, token string) (interface{}, error) {
+	switch c := doc.(type) {
+	case map[string]interface{}:
+		value, found := c[token]
+		if !found {
+			return nil, fmt.Errorf("no member '%s'", token)
+		}
+		return value, nil
+	case []interface{}:
+		i, err := jsonIndex(token, len(c))
+		if nil != err {
+			return nil, err
+		}
+		return c[i], nil
+	}
+	return nil, fmt.Errorf("can't find '%s' in a %s", token, jsonKind(doc))
+}
+
+// jsonIndex parses an array index below n; leading zeros are not allowed
+func jsonIndex(token string, n int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if nil != err || i < 0 || (len(token) > 1 && '0' == token[0]) || '+' == token[0] {
+		return 0, fmt.Errorf("bad array index '%s'", token)
+	}
+	if i >= n {
+		return 0, fmt.Errorf("array index %d is out of range", i)
+	}
+	return i, nil
+}
+
+// jsonKind names the json type of v for errors
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number, float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+// jsonEqual compares json values; numbers are equal by value
+func jsonEqual(a interface{}, b interface{}) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, okX := new(big.Float).SetString(string(x))
+		fy, okY := new(big.Float).SetString(string(y))
+		return okX && okY && 0 == fx.Cmp(fy)
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, value := range x {
+			other, found := y[key]
+			if !found || !jsonEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// jsonDeepCopy copies objects and arrays
+func jsonDeepCopy(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for key, value := range x {
+			m[key] = jsonDeepCopy(value)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(x))
+		for i, value := range x {
+			list[i] = jsonDeepCopy(value)
+		}
+		return list
+	}
+	return v
+}
+
+// sortedJSONKeys returns the keys of m in order
+func sortedJSONKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// decodeJSONGeneric decodes json keeping numbers exact
+func decodeJSONGeneric(data []byte) (interface{}, error) {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&v)
+	if nil != err {
+		return nil, err
+	}
+	return v, nil
+}
+
+// toJSONGeneric turns a go value into generic json values
+func toJSONGeneric(object interface{}) (interface{}, error) {
+	data, err := json.Marshal(object)
+	if nil != err {
+		return nil, l.Fail(err)
+	}
+	return decodeJSONGeneric(data)
+}
+
+// fromJSONGeneric turns generic json values into a go value
+func fromJSONGeneric(v interface{}, out interface{}) error {
+	data, err := json.Marshal(v)
+	if nil != err {
+		return l.Fail(err)
+	}
+	err = json.Unmarshal(data, out)
+	if nil != err {
+		return l.Fail(err)
+	}
+	return nil
+}
+
+// patchObject runs object through patch as json; members the patch removed
+// end up zero and fields json doesn't carry, such as json:"-", are kept
+func patchObject(object interface{}, patch func([]byte) ([]byte, error)) error {
+	v := reflect.ValueOf(object)
+	if reflect.Ptr != v.Kind() || v.IsNil() {
+		return l.Fail(l.ErrInvalidArg, "patching needs a pointer")
+	}
+	doc, err := json.Marshal(object)
+	if nil != err {
+		return l.Fail(err)
+	}
+	doc, err = patch(doc)
+	if l.Check(err) {
+		return err
+	}
+	fresh := reflect.New(v.Elem().Type())
+	err = json.Unmarshal(doc, fresh.Interface())
+	if nil != err {
+		return l.Fail(err)
+	}
+	copyJSONFields(v.Elem(), fresh.Elem())
+	return nil
+}
+
+// jsonUnmarshalerType is a type that reads itself from json
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// copyJSONFields copies the parts of src that json carries into dst
+func copyJSONFields(dst, src reflect.Value) {
+	t := dst.Type()
+	switch {
+	case reflect.Ptr == t.Kind() && !src.IsNil() && !dst.IsNil():
+		copyJSONFields(dst.Elem(), src.Elem())
+	case reflect.Struct == t.Kind() && reflect.TypeOf(time.Time{}) != t &&
+		!reflect.PtrTo(t).Implements(jsonUnmarshalerType) && !reflect.PtrTo(t).Implements(textUnmarshalerType):
+		for _, f := range GetTaggedFields(t, "json") {
+			copyJSONFields(GetFieldValue(dst, f.Index, true), GetFieldValue(src, f.Index, false))
+		}
+	default:
+		dst.Set(src)
+	}
+}
+
+// patchFile runs a json file through patch and writes it back indented
+func patchFile(path string, patch func([]byte) ([]byte, error)) error {
+	doc, err := ioutil.ReadFile(path)
+	if nil != err {
+		return l.Fail(err)
+	}
+	doc, err = patch(doc)
+	if l.Check(err) {
+		return err
+	}
+	var pretty bytes.Buffer
+	err = json.Indent(&pretty, doc, "", "\t")
+	if nil != err {
+		return l.Fail(err)
+	}
+	return WriteFile(path, pretty.Bytes())
+}
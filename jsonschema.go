@@ -0,0 +1,577 @@
+package utl
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	l "github.com/stevenb256/log"
+)
+
+// JSONSchemaDraft is the json schema version generated
+const JSONSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchemaType is one type name or a list of them
+type JSONSchemaType []string
+
+// MarshalJSON writes a single type as a string
+func (t JSONSchemaType) MarshalJSON() ([]byte, error) {
+	if 1 == len(t) {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// UnmarshalJSON reads a string or a list of strings
+func (t *JSONSchemaType) UnmarshalJSON(data []byte) error {
+	var one string
+	if nil == json.Unmarshal(data, &one) {
+		*t = JSONSchemaType{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+// JSONSchema is the part of json schema draft 2020-12 that is generated and
+// validated; Bool is set for the true and false schemas
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	ID                   string                 `json:"$id,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Defs                 map[string]*JSONSchema `json:"$defs,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 JSONSchemaType         `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Const                interface{}            `json:"const,omitempty"`
+	Default              interface{}            `json:"default,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+	MinProperties        *int                   `json:"minProperties,omitempty"`
+	MaxProperties        *int                   `json:"maxProperties,omitempty"`
+	AllOf                []*JSONSchema          `json:"allOf,omitempty"`
+	AnyOf                []*JSONSchema          `json:"anyOf,omitempty"`
+	OneOf                []*JSONSchema          `json:"oneOf,omitempty"`
+	Not                  *JSONSchema            `json:"not,omitempty"`
+	Bool                 *bool                  `json:"-"`
+}
+
+// jsonSchemaFields lets JSONSchema marshal its fields without recursing
+type jsonSchemaFields JSONSchema
+
+// MarshalJSON writes true and false schemas as booleans
+func (s *JSONSchema) MarshalJSON() ([]byte, error) {
+	if nil != s.Bool {
+		return json.Marshal(*s.Bool)
+	}
+	return json.Marshal((*jsonSchemaFields)(s))
+}
+
+// UnmarshalJSON reads true and false schemas
+func (s *JSONSchema) UnmarshalJSON(data []byte) error {
+	var b bool
+	if nil == json.Unmarshal(data, &b) {
+		*s = JSONSchema{Bool: &b}
+		return nil
+	}
+	return json.Unmarshal(data, (*jsonSchemaFields)(s))
+}
+
+// LoadJSONSchema reads a schema from a json file
+func LoadJSONSchema(path string) (*JSONSchema, error) {
+	var schema JSONSchema
+	err := LoadJSONObject(path, &schema)
+	if l.Check(err) {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// GenerateJSONSchema returns a schema for the type of object from its json
+// tags; validate tags give required, min, max and oneof, and default and
+// description tags fill those keywords; named structs go in $defs under
+// their name, with their package too when two share a name
+func GenerateJSONSchema(object interface{}) *JSONSchema {
+	g := &jsonSchemaGenerator{root: GetNonPtrType(object), defs: make(map[string]*JSONSchema), names: make(map[reflect.Type]string)}
+	schema := g.schema(g.root, true)
+	schema.Schema = JSONSchemaDraft
+	schema.Title = g.root.Name()
+	if len(g.defs) > 0 {
+		schema.Defs = g.defs
+	}
+	return schema
+}
+
+// jsonSchemaGenerator remembers the structs already described
+type jsonSchemaGenerator struct {
+	root  reflect.Type
+	defs  map[string]*JSONSchema
+	names map[reflect.Type]string
+}
+
+// types with their own schema
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schema describes type t
+func (g *jsonSchemaGenerator) schema(t reflect.Type, root bool) *JSONSchema {
+
+	// pointers can be null
+	if reflect.Ptr == t.Kind() {
+		schema := g.schema(t.Elem(), root)
+		if "" != schema.Ref {
+			return &JSONSchema{AnyOf: []*JSONSchema{schema, {Type: JSONSchemaType{"null"}}}}
+		}
+		if 0 != len(schema.Type) && !schema.hasType("null") {
+			schema.Type = append(schema.Type, "null")
+		}
+		return schema
+	}
+
+	// special types
+	switch {
+	case reflect.TypeOf(time.Time{}) == t:
+		return &JSONSchema{Type: JSONSchemaType{"string"}, Format: "date-time"}
+	case t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType):
+		return &JSONSchema{}
+	case t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType):
+		return &JSONSchema{Type: JSONSchemaType{"string"}}
+	}
+
+	// kinds
+	switch t.Kind() {
+	case reflect.Bool:
+		return &JSONSchema{Type: JSONSchemaType{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &JSONSchema{Type: JSONSchemaType{"integer"}}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &JSONSchema{Type: JSONSchemaType{"integer"}, Minimum: &zero}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: JSONSchemaType{"number"}}
+	case reflect.String:
+		return &JSONSchema{Type: JSONSchemaType{"string"}}
+	case reflect.Slice, reflect.Array:
+		if reflect.Uint8 == t.Elem().Kind() {
+			return &JSONSchema{Type: JSONSchemaType{"string"}}
+		}
+		schema := &JSONSchema{Type: JSONSchemaType{"array"}, Items: g.schema(t.Elem(), false)}
+		if reflect.Slice == t.Kind() {
+			schema.Type = append(schema.Type, "null")
+		}
+		return schema
+	case reflect.Map:
+		return &JSONSchema{Type: JSONSchemaType{"object", "null"}, AdditionalProperties: g.schema(t.Elem(), false)}
+	case reflect.Struct:
+		return g.structSchema(t, root)
+	}
+	return &JSONSchema{}
+}
+
+// structSchema describes a struct, by reference when it is named
+func (g *jsonSchemaGenerator) structSchema(t reflect.Type, root bool) *JSONSchema {
+
+	// refer to named structs
+	if !root && "" != t.Name() {
+		if t == g.root {
+			return &JSONSchema{Ref: "#"}
+		}
+		name, found := g.names[t]
+		if !found {
+			name = g.defName(t)
+			g.names[t] = name
+			g.defs[name] = &JSONSchema{}
+			*g.defs[name] = *g.structSchema(t, true)
+		}
+		return &JSONSchema{Ref: "#/$defs/" + name}
+	}
+
+	// properties
+	no := false
+	schema := &JSONSchema{Type: JSONSchemaType{"object"}, Properties: make(map[string]*JSONSchema), AdditionalProperties: &JSONSchema{Bool: &no}}
+	for _, f := range GetTaggedFields(t, "json") {
+		field := t.FieldByIndex(f.Index)
+		property := g.schema(f.Type, false)
+		if "" != property.Ref {
+			property = &JSONSchema{AllOf: []*JSONSchema{property}}
+		}
+		property.Description = field.Tag.Get("description")
+		if def, found := field.Tag.Lookup("default"); found {
+			value := reflect.New(f.Type).Elem()
+			if nil == setConfigValue(value, def) {
+				property.Default = value.Interface()
+			}
+		}
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			if "required" == strings.TrimSpace(rule) {
+				schema.Required = append(schema.Required, f.Name)
+			} else {
+				property.applyRule(strings.TrimSpace(rule))
+			}
+		}
+		schema.Properties[f.Name] = property
+	}
+
+	// done
+	return schema
+}
+
+// defName returns a $defs key for named struct t that no other type has
+func (g *jsonSchemaGenerator) defName(t reflect.Type) string {
+	name := t.Name()
+	if _, taken := g.defs[name]; taken {
+		name = strings.Replace(t.PkgPath(), "/", ".", -1) + "." + t.Name()
+	}
+	for i := 2; ; i++ {
+		if _, taken := g.defs[name]; !taken {
+			return name
+		}
+		name = fmt.Sprintf("%s.%s%d", strings.Replace(t.PkgPath(), "/", ".", -1), t.Name(), i)
+	}
+}
+
+// applyRule turns a validate rule into keywords
+func (s *JSONSchema) applyRule(rule string) {
+	i := strings.Index(rule, "=")
+	if i < 0 {
+		return
+	}
+	name, arg := rule[:i], rule[i+1:]
+	switch name {
+	case "min", "max":
+		n, err := strconv.ParseFloat(arg, 64)
+		if nil != err {
+			return
+		}
+		count := int(n)
+		switch {
+		case s.hasType("string"):
+			if "min" == name {
+				s.MinLength = &count
+			} else {
+				s.MaxLength = &count
+			}
+		case s.hasType("array"):
+			if "min" == name {
+				s.MinItems = &count
+			} else {
+				s.MaxItems = &count
+			}
+		case s.hasType("object"):
+			if "min" == name {
+				s.MinProperties = &count
+			} else {
+				s.MaxProperties = &count
+			}
+		case "min" == name:
+			s.Minimum = &n
+		default:
+			s.Maximum = &n
+		}
+	case "oneof":
+		for _, choice := range strings.Split(arg, "|") {
+			var value interface{} = choice
+			if !s.hasType("string") {
+				if n, err := strconv.ParseFloat(choice, 64); nil == err {
+					value = n
+				}
+			}
+			s.Enum = append(s.Enum, value)
+		}
+	}
+}
+
+// hasType returns true if the schema allows type name
+func (s *JSONSchema) hasType(name string) bool {
+	for _, t := range s.Type {
+		if name == t {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateJSON checks a json document against schema and returns ConfigErrors
+// with the json path of every violation
+func ValidateJSON(schema *JSONSchema, data []byte) error {
+	doc, err := decodeJSONGeneric(data)
+	if nil != err {
+		return l.Fail(err)
+	}
+	v := &jsonSchemaValidator{root: schema, patterns: make(map[string]*regexp.Regexp), refs: make(map[string]bool)}
+	v.validate(schema, doc, "")
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+// LoadJSONObjectWithSchema checks a json file against schema, or one generated
+// from object when nil, before reading it into object
+func LoadJSONObjectWithSchema(path string, object interface{}, schema *JSONSchema) error {
+	if nil == schema {
+		schema = GenerateJSONSchema(object)
+	}
+	return LoadJSONObjectWithOptions(path, object, &JSONOptions{Schema: schema})
+}
+
+// jsonSchemaValidator collects violations
+type jsonSchemaValidator struct {
+	root     *JSONSchema
+	patterns map[string]*regexp.Regexp
+	errs     ConfigErrors
+	refs     map[string]bool // refs being followed at a path, to stop cycles
+	top      string          // path the check started at
+	mismatch bool            // value at top was not of the type wanted
+}
+
+// fail records a violation
+func (v *jsonSchemaValidator) fail(path string, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ConfigError{Path: path, Err: fmt.Errorf(format, args...)})
+}
+
+// check validates against schema without recording anything
+func (v *jsonSchemaValidator) check(schema *JSONSchema, value interface{}, path string) *jsonSchemaValidator {
+	inner := &jsonSchemaValidator{root: v.root, patterns: v.patterns, refs: v.refs, top: path}
+	inner.validate(schema, value, path)
+	return inner
+}
+
+// validate checks value at path against schema
+func (v *jsonSchemaValidator) validate(schema *JSONSchema, value interface{}, path string) {
+
+	// boolean schemas and references
+	if nil == schema {
+		return
+	}
+	if nil != schema.Bool {
+		if !*schema.Bool {
+			v.fail(path, "is not allowed")
+		}
+		return
+	}
+	if "" != schema.Ref {
+		target, err := v.resolve(schema.Ref)
+		if nil != err {
+			v.fail(path, "%s", err.Error())
+			return
+		}
+		key := schema.Ref + " " + path
+		if v.refs[key] {
+			v.fail(path, "$ref '%s' refers to itself", schema.Ref)
+			return
+		}
+		v.refs[key] = true
+		v.validate(target, value, path)
+		delete(v.refs, key)
+	}
+
+	// type, enum and const
+	if 0 != len(schema.Type) && !jsonSchemaTypeOf(schema.Type, value) {
+		v.fail(path, "is %s, want %s", jsonKind(value), strings.Join(schema.Type, " or "))
+		v.mismatch = v.mismatch || path == v.top
+		return
+	}
+	if 0 != len(schema.Enum) && !jsonSchemaIn(schema.Enum, value) {
+		v.fail(path, "is not one of the allowed values")
+	}
+	if nil != schema.Const && !jsonSchemaIn([]interface{}{schema.Const}, value) {
+		v.fail(path, "is not the allowed value")
+	}
+
+	// by kind
+	switch x := value.(type) {
+	case json.Number:
+		v.number(schema, x, path)
+	case string:
+		v.text(schema, x, path)
+	case []interface{}:
+		if nil != schema.MinItems && len(x) < *schema.MinItems {
+			v.fail(path, "has %d items, want at least %d", len(x), *schema.MinItems)
+		}
+		if nil != schema.MaxItems && len(x) > *schema.MaxItems {
+			v.fail(path, "has %d items, want at most %d", len(x), *schema.MaxItems)
+		}
+		for i, item := range x {
+			v.validate(schema.Items, item, path+"["+strconv.Itoa(i)+"]")
+		}
+	case map[string]interface{}:
+		v.object(schema, x, path)
+	}
+
+	// combinations; anyOf reports the branch that came closest
+	for _, sub := range schema.AllOf {
+		v.validate(sub, value, path)
+	}
+	if 0 != len(schema.AnyOf) {
+		var closest *jsonSchemaValidator
+		for _, sub := range schema.AnyOf {
+			inner := v.check(sub, value, path)
+			if 0 == len(inner.errs) {
+				closest = nil
+				break
+			}
+			if nil == closest || closest.mismatch && !inner.mismatch ||
+				closest.mismatch == inner.mismatch && len(inner.errs) < len(closest.errs) {
+				closest = inner
+			}
+		}
+		if nil != closest {
+			v.errs = append(v.errs, closest.errs...)
+		}
+	}
+	if 0 != len(schema.OneOf) {
+		n := 0
+		for _, sub := range schema.OneOf {
+			if 0 == len(v.check(sub, value, path).errs) {
+				n++
+			}
+		}
+		if 1 != n {
+			v.fail(path, "matches %d of oneOf, want 1", n)
+		}
+	}
+	if nil != schema.Not && 0 == len(v.check(schema.Not, value, path).errs) {
+		v.fail(path, "matches not")
+	}
+}
+
+// number checks the limits of a number
+func (v *jsonSchemaValidator) number(schema *JSONSchema, x json.Number, path string) {
+	f, _ := x.Float64()
+	if nil != schema.Minimum && f < *schema.Minimum {
+		v.fail(path, "is %s, want at least %v", x, *schema.Minimum)
+	}
+	if nil != schema.Maximum && f > *schema.Maximum {
+		v.fail(path, "is %s, want at most %v", x, *schema.Maximum)
+	}
+	if nil != schema.ExclusiveMinimum && f <= *schema.ExclusiveMinimum {
+		v.fail(path, "is %s, want more than %v", x, *schema.ExclusiveMinimum)
+	}
+	if nil != schema.ExclusiveMaximum && f >= *schema.ExclusiveMaximum {
+		v.fail(path, "is %s, want less than %v", x, *schema.ExclusiveMaximum)
+	}
+}
+
+// text checks the length, pattern and format of a string
+func (v *jsonSchemaValidator) text(schema *JSONSchema, x string, path string) {
+	n := utf8.RuneCountInString(x)
+	if nil != schema.MinLength && n < *schema.MinLength {
+		v.fail(path, "is %d long, want at least %d", n, *schema.MinLength)
+	}
+	if nil != schema.MaxLength && n > *schema.MaxLength {
+		v.fail(path, "is %d long, want at most %d", n, *schema.MaxLength)
+	}
+	if "" != schema.Pattern {
+		re, found := v.patterns[schema.Pattern]
+		if !found {
+			var err error
+			re, err = regexp.Compile(schema.Pattern)
+			if nil != err {
+				v.fail(path, "bad pattern '%s'", schema.Pattern)
+				return
+			}
+			v.patterns[schema.Pattern] = re
+		}
+		if !re.MatchString(x) {
+			v.fail(path, "does not match '%s'", schema.Pattern)
+		}
+	}
+	if "date-time" == schema.Format {
+		if _, err := time.Parse(time.RFC3339Nano, x); nil != err {
+			v.fail(path, "is not a date-time")
+		}
+	}
+}
+
+// object checks the count of properties and required, known and additional ones
+func (v *jsonSchemaValidator) object(schema *JSONSchema, x map[string]interface{}, path string) {
+	if nil != schema.MinProperties && len(x) < *schema.MinProperties {
+		v.fail(path, "has %d properties, want at least %d", len(x), *schema.MinProperties)
+	}
+	if nil != schema.MaxProperties && len(x) > *schema.MaxProperties {
+		v.fail(path, "has %d properties, want at most %d", len(x), *schema.MaxProperties)
+	}
+	for _, name := range schema.Required {
+		if _, found := x[name]; !found {
+			v.fail(strings.TrimPrefix(path+jsonPathKey(name), "."), "is required")
+		}
+	}
+	for _, key := range sortedJSONKeys(x) {
+		child := strings.TrimPrefix(path+jsonPathKey(key), ".")
+		if property, found := schema.Properties[key]; found {
+			v.validate(property, x[key], child)
+		} else if nil != schema.AdditionalProperties {
+			if nil != schema.AdditionalProperties.Bool && !*schema.AdditionalProperties.Bool {
+				v.fail(child, "is not a known property")
+			} else {
+				v.validate(schema.AdditionalProperties, x[key], child)
+			}
+		}
+	}
+}
+
+// resolve finds the schema a local $ref points to
+func (v *jsonSchemaValidator) resolve(ref string) (*JSONSchema, error) {
+	if "#" == ref {
+		return v.root, nil
+	}
+	if strings.HasPrefix(ref, "#/$defs/") {
+		tokens, err := ParseJSONPointer(ref[1:])
+		if nil == err && 2 == len(tokens) {
+			if schema, found := v.root.Defs[tokens[1]]; found {
+				return schema, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("can't resolve $ref '%s'", ref)
+}
+
+// jsonSchemaTypeOf returns true if value is one of types
+func jsonSchemaTypeOf(types JSONSchemaType, value interface{}) bool {
+	kind := jsonKind(value)
+	for _, t := range types {
+		if t == kind {
+			return true
+		}
+		if "integer" == t && "number" == kind {
+			f, err := value.(json.Number).Float64()
+			if nil == err && f == math.Trunc(f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonSchemaIn returns true if value equals one of values
+func jsonSchemaIn(values []interface{}, value interface{}) bool {
+	for _, allowed := range values {
+		generic, err := toJSONGeneric(allowed)
+		if nil == err && jsonEqual(generic, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,337 @@
+package utl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+
+	l "github.com/stevenb256/log"
+)
+
+// JSONOptions makes json decoding stricter
+type JSONOptions struct {
+	DisallowUnknownFields bool        // keys with no struct field are errors
+	DisallowDuplicateKeys bool        // a key given twice in one object is an error
+	Schema                *JSONSchema // the document must pass this schema
+}
+
+// StrictJSON turns on every check
+var StrictJSON = &JSONOptions{DisallowUnknownFields: true, DisallowDuplicateKeys: true}
+
+// JSONError is a json error with where it happened
+type JSONError struct {
+	Line    int    // 1 based
+	Column  int    // 1 based, in bytes
+	Path    string // such as servers[2].port; empty for the top value
+	Snippet string // the line the error is on
+	Err     error
+}
+
+// Error returns the error text with the line and a marker under the column
+func (e *JSONError) Error() string {
+	at := fmt.Sprintf("line %d column %d", e.Line, e.Column)
+	if "" != e.Path {
+		at += " at " + e.Path
+	}
+	text := fmt.Sprintf("%s: %s", at, e.Err.Error())
+	if "" != e.Snippet {
+		marker := []byte(e.Snippet)
+		if e.Column-1 < len(marker) {
+			marker = marker[:e.Column-1]
+		}
+		for i, b := range marker {
+			if '\t' != b {
+				marker[i] = ' '
+			}
+		}
+		text += fmt.Sprintf("\n\t%s\n\t%s^", e.Snippet, marker)
+	}
+	return text
+}
+
+// Unwrap returns the json error
+func (e *JSONError) Unwrap() error {
+	return e.Err
+}
+
+// LoadJSONObjectWithOptions reads a json object from a file with the checks of options
+func LoadJSONObjectWithOptions(path string, object interface{}, options *JSONOptions) error {
+	jsonText, err := ioutil.ReadFile(path)
+	if nil != err {
+		return l.Fail(err)
+	}
+	err = DecodeJSON(jsonText, object, options)
+	if nil != err {
+		return l.Fail(err)
+	}
+	return nil
+}
+
+// DecodeJSON unmarshals data into object; errors are *JSONError giving the
+// line, column and path of the problem, or ConfigErrors from the schema
+func DecodeJSON(data []byte, object interface{}, options *JSONOptions) error {
+
+	// locals
+	o := JSONOptions{}
+	if nil != options {
+		o = *options
+	}
+
+	// check the text against the type first when asked to
+	if o.DisallowUnknownFields || o.DisallowDuplicateKeys {
+		w := newJSONWalker(data, &o, -1)
+		err := w.walk(reflect.TypeOf(object))
+		if nil != err {
+			return err
+		}
+	}
+	if nil != o.Schema {
+		err := ValidateJSON(o.Schema, data)
+		if nil != err {
+			return err
+		}
+	}
+
+	// then decode it
+	err := json.Unmarshal(data, object)
+	if nil == err {
+		return nil
+	}
+
+	// walk the text only now to find where it went wrong
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		finder := newJSONWalker(data, &JSONOptions{}, typeErr.Offset)
+		finder.walk(nil)
+		return jsonErrorAt(data, finder.offset, finder.path(), err)
+	}
+	finder := newJSONWalker(data, &JSONOptions{}, -1)
+	if walkErr := finder.walk(nil); nil != walkErr {
+		return walkErr
+	}
+	return jsonErrorAt(data, 0, "", err)
+}
+
+// jsonWalker reads json tokens following the go type they decode into
+type jsonWalker struct {
+	data    []byte
+	dec     *json.Decoder
+	options *JSONOptions
+	target  int64 // find the value read at this offset, when not -1
+	offset  int64 // where the error or target is
+	stack   []string
+}
+
+// errJSONFound stops a walk once the target is found
+var errJSONFound = errors.New("found")
+
+// newJSONWalker returns a walker over data
+func newJSONWalker(data []byte, options *JSONOptions, target int64) *jsonWalker {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return &jsonWalker{data: data, dec: dec, options: options, target: target}
+}
+
+// walk checks the whole text as a value of type t
+func (w *jsonWalker) walk(t reflect.Type) error {
+	err := w.value(t)
+	if errJSONFound == err {
+		return nil
+	}
+	if nil != err {
+		return err
+	}
+	if _, err := w.dec.Token(); io.EOF != err {
+		return w.fail(w.dec.InputOffset(), fmt.Errorf("extra data after json value"))
+	}
+	return nil
+}
+
+// path returns the path of the value being read
+func (w *jsonWalker) path() string {
+	return strings.TrimPrefix(strings.Join(w.stack, ""), ".")
+}
+
+// fail returns an error located at offset
+func (w *jsonWalker) fail(offset int64, err error) error {
+	w.offset = skipJSONSpace(w.data, offset)
+	return jsonErrorAt(w.data, w.offset, w.path(), err)
+}
+
+// token reads the next token; a value ending at or past the target ends the walk
+func (w *jsonWalker) token() (json.Token, error) {
+	start := w.dec.InputOffset()
+	tok, err := w.dec.Token()
+	if nil != err {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			w.offset = syntaxErr.Offset - 1
+			if syntaxErr.Offset >= int64(len(w.data)) {
+				w.offset = int64(len(w.data))
+			}
+			return nil, jsonErrorAt(w.data, w.offset, w.path(), err)
+		}
+		if io.EOF == err {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, w.fail(start, err)
+	}
+	if w.target >= 0 && w.dec.InputOffset() >= w.target {
+		w.offset = skipJSONSpace(w.data, start)
+		return nil, errJSONFound
+	}
+	return tok, nil
+}
+
+// value reads one value that decodes into t; t is nil when anything goes
+func (w *jsonWalker) value(t reflect.Type) error {
+
+	// read it
+	t = jsonTargetType(t)
+	tok, err := w.token()
+	if nil != err {
+		return err
+	}
+
+	// objects and arrays hold more values
+	switch tok {
+	case json.Delim('{'):
+		seen := make(map[string]bool)
+		for w.dec.More() {
+			start := w.dec.InputOffset()
+			tok, err := w.token()
+			if nil != err {
+				return err
+			}
+			key, _ := tok.(string)
+			w.stack = append(w.stack, jsonPathKey(key))
+			if w.options.DisallowDuplicateKeys && seen[key] {
+				return w.fail(start, fmt.Errorf("duplicate key \"%s\"", key))
+			}
+			seen[key] = true
+			child, known := jsonFieldType(t, key)
+			if !known && w.options.DisallowUnknownFields {
+				return w.fail(start, fmt.Errorf("unknown field \"%s\"", key))
+			}
+			err = w.value(child)
+			if nil != err {
+				return err
+			}
+			w.stack = w.stack[:len(w.stack)-1]
+		}
+		_, err = w.token()
+		return err
+	case json.Delim('['):
+		var elem reflect.Type
+		if nil != t && (reflect.Slice == t.Kind() || reflect.Array == t.Kind()) {
+			elem = t.Elem()
+		}
+		for i := 0; w.dec.More(); i++ {
+			w.stack = append(w.stack, "["+strconv.Itoa(i)+"]")
+			err = w.value(elem)
+			if nil != err {
+				return err
+			}
+			w.stack = w.stack[:len(w.stack)-1]
+		}
+		_, err = w.token()
+		return err
+	}
+
+	// done
+	return nil
+}
+
+// jsonPathKey returns how key is shown in a path
+func jsonPathKey(key string) string {
+	for _, r := range key {
+		if !(r == '_' || r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return "[" + strconv.Quote(key) + "]"
+		}
+	}
+	return "." + key
+}
+
+// jsonTargetType follows pointers; types that decode themselves give nil
+func jsonTargetType(t reflect.Type) reflect.Type {
+	for nil != t && reflect.Ptr == t.Kind() {
+		t = t.Elem()
+	}
+	if nil == t || reflect.PtrTo(t).Implements(jsonUnmarshalerType) {
+		return nil
+	}
+	return t
+}
+
+// jsonFieldType returns the type a key of an object decodes into and whether
+// anything takes the key
+func jsonFieldType(t reflect.Type, key string) (reflect.Type, bool) {
+	if nil == t {
+		return nil, true
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Elem(), true
+	case reflect.Struct:
+		if f := jsonField(t, key); nil != f {
+			return f.Type, true
+		}
+		return nil, false
+	case reflect.Interface:
+		return nil, true
+	}
+	return nil, true
+}
+
+// jsonField returns the field of struct type t that a key decodes into or
+// nil; matching is like encoding/json, exact then any case
+func jsonField(t reflect.Type, key string) *TaggedField {
+	fields := GetTaggedFields(t, "json")
+	for _, f := range fields {
+		if key == f.Name {
+			return f
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(key, f.Name) {
+			return f
+		}
+	}
+	return nil
+}
+
+// skipJSONSpace moves offset past white space, commas and colons to the next token
+func skipJSONSpace(data []byte, offset int64) int64 {
+	if offset < 0 {
+		offset = 0
+	}
+	for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,:", data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// jsonErrorAt makes a JSONError for offset into data
+func jsonErrorAt(data []byte, offset int64, path string, err error) *JSONError {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	start := bytes.LastIndexByte(data[:offset], '\n') + 1
+	end := bytes.IndexByte(data[start:], '\n')
+	if end < 0 {
+		end = len(data) - start
+	}
+	return &JSONError{
+		Line:    bytes.Count(data[:offset], []byte("\n")) + 1,
+		Column:  int(offset) - start + 1,
+		Path:    path,
+		Snippet: strings.TrimRight(string(data[start:start+end]), "\r"),
+		Err:     err,
+	}
+}
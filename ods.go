@@ -0,0 +1,287 @@
+package utl
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	l "github.com/stevenb256/log"
+)
+
+// odsMaxRepeat caps how many times a repeated ods row or cell is expanded
+const odsMaxRepeat = 10000
+
+// odsReader reads rows of one table from the content.xml of an ods file
+type odsReader struct {
+	decoder *xml.Decoder
+	content io.ReadCloser
+	options *ExcelOptions
+	n       int
+	table   int
+	inTable bool
+	row     []string
+	repeat  int
+}
+
+// OpenODS opens an OpenDocument spreadsheet as a Tabular; reads the sheet
+// picked by options.Sheet or options.SheetIndex and skips empty rows, though
+// Row still gives the row number in the sheet; the table closes itself at the
+// end of the sheet, call Close when stopping early
+func OpenODS(reader io.ReaderAt, size int64, columns []string, options *ExcelOptions) (*Table, error) {
+
+	// default options
+	if nil == options {
+		options = &ExcelOptions{}
+	}
+
+	// open the zip
+	z, err := zip.NewReader(reader, size)
+	if l.Check(err) {
+		return nil, l.Fail(fmt.Errorf("can't open ods file: %s", err.Error()))
+	}
+
+	// find the content
+	var content io.ReadCloser
+	for _, f := range z.File {
+		if "content.xml" == f.Name {
+			content, err = f.Open()
+			if l.Check(err) {
+				return nil, err
+			}
+			break
+		}
+	}
+	if nil == content {
+		return nil, l.Fail(fmt.Errorf("ods file has no content.xml"))
+	}
+
+	// read it a row at a time
+	ods := &odsReader{decoder: xml.NewDecoder(content), content: content, options: options, table: -1}
+	table, err := openTable(ods.next, columns, options)
+	if l.Check(err) {
+		ods.close()
+		return nil, err
+	}
+	table.number = func() int { return ods.n }
+	table.close = ods.close
+	return table, nil
+}
+
+// close closes the content once
+func (r *odsReader) close() error {
+	if nil == r.content {
+		return nil
+	}
+	content := r.content
+	r.content = nil
+	return content.Close()
+}
+
+// next returns the next non empty row of the selected table or io.EOF; the
+// content is closed at the end or on error
+func (r *odsReader) next() ([]string, error) {
+	row, err := r.read()
+	if nil != err {
+		r.close()
+	}
+	return row, err
+}
+
+// read returns the next non empty row of the selected table or io.EOF and
+// counts the rows of the sheet up to it
+func (r *odsReader) read() ([]string, error) {
+
+	// closed
+	if nil == r.content {
+		return nil, io.EOF
+	}
+
+	// repeated rows
+	if r.repeat > 0 {
+		r.repeat--
+		r.n++
+		return append([]string{}, r.row...), nil
+	}
+
+	// find the next row
+	for {
+		token, err := r.decoder.Token()
+		if l.Check(err) {
+			if io.EOF != err {
+				err = l.Fail(fmt.Errorf("can't read ods content: %s", err.Error()))
+			}
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "table":
+				r.table++
+				if r.isSelected(attribute(t, "name")) {
+					r.inTable = true
+				} else {
+					r.decoder.Skip()
+				}
+			case "table-row":
+				if !r.inTable {
+					continue
+				}
+				row, err := r.readRow()
+				if l.Check(err) {
+					return nil, err
+				}
+				if isEmptyRow(row) {
+					r.n += repeated(t, "number-rows-repeated")
+					continue
+				}
+				r.n++
+				r.row = row
+				r.repeat = minInt(repeated(t, "number-rows-repeated"), odsMaxRepeat) - 1
+				return append([]string{}, row...), nil
+			}
+		case xml.EndElement:
+			if "table" == t.Name.Local && r.inTable {
+				return nil, io.EOF
+			}
+		}
+	}
+}
+
+// isSelected returns true if the current table is the one to read
+func (r *odsReader) isSelected(name string) bool {
+	if "" != r.options.Sheet {
+		return strings.EqualFold(r.options.Sheet, name)
+	}
+	return r.table == r.options.SheetIndex
+}
+
+// readRow reads the cells of a table-row element
+func (r *odsReader) readRow() ([]string, error) {
+
+	// locals
+	var row []string
+	empty := 0
+
+	// read cells until end of row
+	for {
+		token, err := r.decoder.Token()
+		if l.Check(err) {
+			return nil, l.Fail(fmt.Errorf("can't read ods content: %s", err.Error()))
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if "table-cell" != t.Name.Local && "covered-table-cell" != t.Name.Local {
+				continue
+			}
+			value, err := r.readCell(t)
+			if l.Check(err) {
+				return nil, err
+			}
+			n := minInt(repeated(t, "number-columns-repeated"), odsMaxRepeat)
+
+			// hold on to empty cells until something follows them
+			if "" == value {
+				empty += n
+				continue
+			}
+			for ; empty > 0; empty-- {
+				row = append(row, "")
+			}
+			for i := 0; i < n; i++ {
+				row = append(row, value)
+			}
+		case xml.EndElement:
+			if "table-row" == t.Name.Local {
+				return row, nil
+			}
+		}
+	}
+}
+
+// readCell returns the value of a cell as text
+func (r *odsReader) readCell(start xml.StartElement) (string, error) {
+
+	// locals
+	var text strings.Builder
+	paragraphs := 0
+
+	// typed values are in attributes
+	value := ""
+	switch attribute(start, "value-type") {
+	case "float", "percentage", "currency":
+		value = attribute(start, "value")
+	case "date":
+		value = attribute(start, "date-value")
+	case "time":
+		value = attribute(start, "time-value")
+	case "boolean":
+		value = attribute(start, "boolean-value")
+	}
+
+	// text is in paragraphs; comments are skipped
+	inParagraph := 0
+	for depth := 1; depth > 0; {
+		token, err := r.decoder.Token()
+		if nil != err {
+			return "", l.Fail(fmt.Errorf("can't read ods content: %s", err.Error()))
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "annotation":
+				r.decoder.Skip()
+				continue
+			case "p":
+				if paragraphs > 0 {
+					text.WriteByte('\n')
+				}
+				paragraphs++
+				inParagraph++
+			case "s":
+				text.WriteString(strings.Repeat(" ", repeated(t, "c")))
+			case "tab":
+				text.WriteByte('\t')
+			case "line-break":
+				text.WriteByte('\n')
+			}
+			depth++
+		case xml.EndElement:
+			if "p" == t.Name.Local {
+				inParagraph--
+			}
+			depth--
+		case xml.CharData:
+			if inParagraph > 0 {
+				text.Write(t)
+			}
+		}
+	}
+
+	// typed value wins over display text
+	if "" != value {
+		return value, nil
+	}
+	return text.String(), nil
+}
+
+// repeated returns a repeat count attribute of an element, 1 if not there
+func repeated(start xml.StartElement, name string) int {
+	n, err := strconv.Atoi(attribute(start, name))
+	if nil != err || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// isEmptyRow returns true if every cell of row is empty
+func isEmptyRow(row []string) bool {
+	for _, value := range row {
+		if "" != strings.TrimSpace(value) {
+			return false
+		}
+	}
+	return true
+}
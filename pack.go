@@ -0,0 +1,364 @@
+package utl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	l "github.com/stevenb256/log"
+)
+
+// ErrNotPacked bytes were not written by PackObject
+var ErrNotPacked = l.NewError(400, "pack", "not a packed object")
+
+// ErrPackedNeedsKey packed object is encrypted and no key was given
+var ErrPackedNeedsKey = l.NewError(401, "pack", "packed object is encrypted")
+
+// ErrPackedNotEncrypted a key was given for a packed object that is not encrypted
+var ErrPackedNotEncrypted = l.NewError(402, "pack", "packed object is not encrypted")
+
+// ErrPackedTooLarge packed object expands past the limit of its options
+var ErrPackedTooLarge = l.NewError(403, "pack", "packed object expands past the limit")
+
+// DefaultMaxExpanded bytes a packed object may expand to when unpacking
+const DefaultMaxExpanded int64 = 1 << 30
+
+// compression names
+const (
+	CompressNone   = ""
+	CompressGzip   = "gzip"
+	CompressZstd   = "zstd"
+	CompressSnappy = "snappy"
+)
+
+// packMagic starts every packed object, then a version byte
+var packMagic = []byte("UPK")
+
+// packVersion is the header layout written
+const packVersion = 1
+
+// packEncrypted flag is set when the payload is encrypted
+const packEncrypted = 1
+
+// PackOptions picks the transforms PackObject applies, in order codec,
+// compression then encryption
+type PackOptions struct {
+	Codec       Codec  // gob when nil
+	Compression string // CompressNone, CompressGzip, CompressZstd, CompressSnappy or a registered name
+	Key         Key    // encrypts with EncryptBytes when set
+	MaxExpanded int64  // unpacking fails past this many expanded bytes; DefaultMaxExpanded when 0
+}
+
+// compressor compresses and expands bytes; expand stops past max bytes
+type compressor struct {
+	compress func([]byte) ([]byte, error)
+	expand   func([]byte, int64) ([]byte, error)
+}
+
+// registry of compressions by name
+var compressors = struct {
+	sync.RWMutex
+	names map[string]compressor
+}{names: make(map[string]compressor)}
+
+// register the built in compressions; they stop expanding at the limit
+func init() {
+	compressors.names[CompressGzip] = compressor{compress: gzipCompress, expand: gzipExpand}
+	compressors.names[CompressZstd] = compressor{compress: zstdCompress, expand: zstdExpand}
+	compressors.names[CompressSnappy] = compressor{compress: func(in []byte) ([]byte, error) {
+		return snappy.Encode(nil, in), nil
+	}, expand: snappyExpand}
+}
+
+// RegisterCompression adds or replaces a compression by name; the size limit
+// of unpacking is checked once expand returns, so expand should bound itself
+func RegisterCompression(name string, compress func([]byte) ([]byte, error), expand func([]byte) ([]byte, error)) {
+	compressors.Lock()
+	defer compressors.Unlock()
+	compressors.names[name] = compressor{compress: compress, expand: func(in []byte, max int64) ([]byte, error) {
+		out, err := expand(in)
+		if nil == err && int64(len(out)) > max {
+			return nil, ErrPackedTooLarge
+		}
+		return out, err
+	}}
+}
+
+// compressorOf returns the compression registered as name
+func compressorOf(name string) (compressor, error) {
+	compressors.RLock()
+	defer compressors.RUnlock()
+	c, found := compressors.names[name]
+	if !found {
+		return c, l.Fail(l.ErrInvalidArg, fmt.Sprintf("no compression named '%s'", name))
+	}
+	return c, nil
+}
+
+// PackObject encodes object and applies the transforms of options; a header
+// records them so UnpackObject can reverse them. When encrypting, a copy of
+// the header is sealed with the payload so it can't be changed unnoticed
+func PackObject(object interface{}, options *PackOptions) ([]byte, error) {
+
+	// locals
+	o := PackOptions{}
+	if nil != options {
+		o = *options
+	}
+	if nil == o.Codec {
+		o.Codec = GobCodec
+	}
+	if len(o.Codec.Name()) > 255 || len(o.Compression) > 255 {
+		return nil, l.Fail(l.ErrInvalidArg, "codec and compression names must be under 256 bytes")
+	}
+
+	// encode
+	data, err := o.Codec.Marshal(object)
+	if nil != err {
+		return nil, l.Fail(err)
+	}
+
+	// compress
+	if CompressNone != o.Compression {
+		c, err := compressorOf(o.Compression)
+		if l.Check(err) {
+			return nil, err
+		}
+		data, err = c.compress(data)
+		if nil != err {
+			return nil, l.Fail(err)
+		}
+	}
+
+	// header
+	flags := byte(0)
+	if nil != o.Key {
+		flags |= packEncrypted
+	}
+	var buf bytes.Buffer
+	buf.Write(packMagic)
+	buf.WriteByte(packVersion)
+	buf.WriteByte(byte(len(o.Codec.Name())))
+	buf.WriteString(o.Codec.Name())
+	buf.WriteByte(byte(len(o.Compression)))
+	buf.WriteString(o.Compression)
+	buf.WriteByte(flags)
+
+	// encrypt header and payload together
+	if nil != o.Key {
+		data, err = EncryptBytes(append(append([]byte{}, buf.Bytes()...), data...), o.Key)
+		if l.Check(err) {
+			return nil, err
+		}
+	}
+	buf.Write(data)
+
+	// done
+	return buf.Bytes(), nil
+}
+
+// UnpackObject reverses PackObject into object; key is only needed when the
+// object was encrypted, and when given the object must be encrypted with it
+func UnpackObject(data []byte, object interface{}, key Key) error {
+	return UnpackObjectWithOptions(data, object, &PackOptions{Key: key})
+}
+
+// UnpackObjectWithOptions reverses PackObject into object using the Key and
+// MaxExpanded of options; the codec and compression come from the header
+func UnpackObjectWithOptions(data []byte, object interface{}, options *PackOptions) error {
+
+	// locals
+	o := PackOptions{}
+	if nil != options {
+		o = *options
+	}
+	if 0 >= o.MaxExpanded {
+		o.MaxExpanded = DefaultMaxExpanded
+	}
+	key := o.Key
+
+	// header
+	if !bytes.HasPrefix(data, packMagic) || len(data) < len(packMagic)+1 {
+		return l.Fail(ErrNotPacked)
+	}
+	packed := data
+	data = data[len(packMagic):]
+	if packVersion != data[0] {
+		return l.Fail(ErrNotPacked, fmt.Sprintf("version %d", data[0]))
+	}
+	data = data[1:]
+	codecName, data, ok := packString(data)
+	compression, data, ok2 := packString(data)
+	if !ok || !ok2 || 0 == len(data) {
+		return l.Fail(ErrNotPacked, "header is cut short")
+	}
+	flags := data[0]
+	data = data[1:]
+	header := packed[:len(packed)-len(data)]
+	codec, err := CodecByName(codecName)
+	if l.Check(err) {
+		return err
+	}
+
+	// decrypt; the sealed copy of the header must match the one in the clear
+	if 0 != flags&packEncrypted {
+		if nil == key {
+			return l.Fail(ErrPackedNeedsKey)
+		}
+		if len(data) < NonceSize {
+			return l.Fail(ErrCantDecryptBytes)
+		}
+		data, err = DecryptBytes(data, key)
+		if l.Check(err) {
+			return err
+		}
+		if !bytes.HasPrefix(data, header) {
+			return l.Fail(ErrCantDecryptBytes, "packed header was changed")
+		}
+		data = data[len(header):]
+	} else if nil != key {
+		return l.Fail(ErrPackedNotEncrypted)
+	}
+
+	// expand
+	if CompressNone != compression {
+		c, err := compressorOf(compression)
+		if l.Check(err) {
+			return err
+		}
+		data, err = c.expand(data, o.MaxExpanded)
+		if nil != err {
+			return l.Fail(err)
+		}
+	}
+
+	// decode
+	err = codec.Unmarshal(data, object)
+	if nil != err {
+		return l.Fail(err)
+	}
+
+	// done
+	return nil
+}
+
+// packString reads a string prefixed with its length byte
+func packString(data []byte) (string, []byte, bool) {
+	if 0 == len(data) || len(data) < 1+int(data[0]) {
+		return "", nil, false
+	}
+	n := 1 + int(data[0])
+	return string(data[1:n]), data[n:], true
+}
+
+// SavePackedObject packs object and writes it to path
+func SavePackedObject(path string, object interface{}, options *PackOptions) error {
+	data, err := PackObject(object, options)
+	if l.Check(err) {
+		return err
+	}
+	return WriteFile(path, data)
+}
+
+// LoadPackedObject reads path and unpacks it into object
+func LoadPackedObject(path string, object interface{}, key Key) error {
+	return LoadPackedObjectWithOptions(path, object, &PackOptions{Key: key})
+}
+
+// LoadPackedObjectWithOptions reads path and unpacks it into object using
+// the Key and MaxExpanded of options
+func LoadPackedObjectWithOptions(path string, object interface{}, options *PackOptions) error {
+	data, err := ioutil.ReadFile(path)
+	if nil != err {
+		return l.Fail(err)
+	}
+	return UnpackObjectWithOptions(data, object, options)
+}
+
+// readExpanded reads r to the end, failing once it passes max bytes
+func readExpanded(r io.Reader, max int64) ([]byte, error) {
+	out, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if nil != err {
+		return nil, err
+	}
+	if int64(len(out)) > max {
+		return nil, ErrPackedTooLarge
+	}
+	return out, nil
+}
+
+// gzipCompress compresses with gzip
+func gzipCompress(in []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(in)
+	if nil == err {
+		err = w.Close()
+	}
+	if nil != err {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gzipExpand expands gzip bytes up to max
+func gzipExpand(in []byte, max int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(in))
+	if nil != err {
+		return nil, err
+	}
+	defer r.Close()
+	return readExpanded(r, max)
+}
+
+// snappyExpand expands snappy bytes up to max; the length is in the header
+func snappyExpand(in []byte, max int64) ([]byte, error) {
+	n, err := snappy.DecodedLen(in)
+	if nil != err {
+		return nil, err
+	}
+	if int64(n) > max {
+		return nil, ErrPackedTooLarge
+	}
+	return snappy.Decode(nil, in)
+}
+
+// zstd encoder is safe to share for whole buffers
+var zstdCoders struct {
+	sync.Once
+	encoder *zstd.Encoder
+	err     error
+}
+
+// zstdInit makes the shared zstd encoder
+func zstdInit() error {
+	zstdCoders.Do(func() {
+		zstdCoders.encoder, zstdCoders.err = zstd.NewWriter(nil)
+	})
+	return zstdCoders.err
+}
+
+// zstdCompress compresses with zstd
+func zstdCompress(in []byte) ([]byte, error) {
+	err := zstdInit()
+	if nil != err {
+		return nil, err
+	}
+	return zstdCoders.encoder.EncodeAll(in, nil), nil
+}
+
+// zstdExpand expands zstd bytes up to max; it streams so a frame can't
+// allocate its whole claimed size up front
+func zstdExpand(in []byte, max int64) ([]byte, error) {
+	r, err := zstd.NewReader(bytes.NewReader(in), zstd.WithDecoderConcurrency(1))
+	if nil != err {
+		return nil, err
+	}
+	defer r.Close()
+	return readExpanded(r, max)
+}
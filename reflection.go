@@ -1,7 +1,12 @@
 package utl
 
 import (
+	"encoding"
+	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	l "github.com/stevenb256/log"
 )
@@ -112,3 +117,286 @@ func Clone(inter interface{}) interface{} {
 	}
 	return nInter.Interface()
 }
+
+// TaggedField - describes an exported struct field and the name given to it by a tag
+type TaggedField struct {
+	Name    string
+	Field   string
+	Index   []int
+	Type    reflect.Type
+	Options []string
+}
+
+// HasOption - returns true if the field tag had option o, e.g. "required"
+func (f *TaggedField) HasOption(o string) bool {
+	for _, option := range f.Options {
+		if option == o {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTaggedFields - returns exported fields of struct type t named by tag key;
+// fields without a tag use their field name and fields tagged "-" are skipped;
+// embedded structs and pointers to structs are flattened with the rules of
+// encoding/json, so the shallowest name wins, then a tagged one, and names
+// still tied are dropped
+func GetTaggedFields(t reflect.Type, key string) []*TaggedField {
+
+	// locals
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.Struct != t.Kind() {
+		return nil
+	}
+
+	// gather every field with its depth and whether its name came from a tag
+	var candidates []*taggedCandidate
+	collectTaggedFields(t, key, nil, map[reflect.Type]bool{t: true}, &candidates)
+
+	// keep the dominant field of each name
+	named := make(map[string][]*taggedCandidate)
+	for _, c := range candidates {
+		named[c.field.Name] = append(named[c.field.Name], c)
+	}
+	var fields []*TaggedField
+	for _, c := range candidates {
+		if dominantField(named[c.field.Name]) == c {
+			fields = append(fields, c.field)
+		}
+	}
+	return fields
+}
+
+// taggedCandidate is a field that may be hidden by another of the same name
+type taggedCandidate struct {
+	field  *TaggedField
+	tagged bool
+}
+
+// collectTaggedFields adds the fields of t found through index to candidates;
+// types holds the structs being walked so embedded cycles stop
+func collectTaggedFields(t reflect.Type, key string, index []int, types map[reflect.Type]bool, candidates *[]*taggedCandidate) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, tagged := f.Tag.Lookup(key)
+		if "-" == tag {
+			continue
+		}
+		fieldIndex := append(append([]int{}, index...), i)
+		if f.Anonymous && !tagged {
+			embedded := f.Type
+			if reflect.Ptr == embedded.Kind() && "" == f.PkgPath {
+				embedded = embedded.Elem()
+			}
+			if reflect.Struct == embedded.Kind() {
+				if !types[embedded] {
+					types[embedded] = true
+					collectTaggedFields(embedded, key, fieldIndex, types, candidates)
+					delete(types, embedded)
+				}
+				continue
+			}
+		}
+		if "" != f.PkgPath {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		field := &TaggedField{
+			Name:    strings.TrimSpace(parts[0]),
+			Field:   f.Name,
+			Index:   fieldIndex,
+			Type:    f.Type,
+			Options: parts[1:],
+		}
+		if "" == field.Name {
+			field.Name = f.Name
+		}
+		*candidates = append(*candidates, &taggedCandidate{field: field, tagged: "" != strings.TrimSpace(parts[0])})
+	}
+}
+
+// dominantField returns the field that wins among fields of one name or nil
+// if none does
+func dominantField(candidates []*taggedCandidate) *taggedCandidate {
+	var winner *taggedCandidate
+	tied := false
+	for _, c := range candidates {
+		switch {
+		case nil == winner || len(c.field.Index) < len(winner.field.Index):
+			winner, tied = c, false
+		case len(c.field.Index) > len(winner.field.Index):
+		case c.tagged && !winner.tagged:
+			winner, tied = c, false
+		case c.tagged == winner.tagged:
+			tied = true
+		}
+	}
+	if tied {
+		return nil
+	}
+	return winner
+}
+
+// GetFieldValue - returns the field of struct v at index; embedded pointers
+// that are nil are made when alloc is true, otherwise the field reads as zero
+func GetFieldValue(v reflect.Value, index []int, alloc bool) reflect.Value {
+	for i, x := range index {
+		if i > 0 && reflect.Ptr == v.Kind() {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Zero(v.Type().Elem().FieldByIndex(index[i:]).Type)
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// SetValueFromString - converts s to the type of v and sets it; handles strings,
+// ints, uints, floats, bools, durations, time.Time, pointers and TextUnmarshalers
+func SetValueFromString(v reflect.Value, s string) error {
+
+	// allocate pointers as needed
+	if reflect.Ptr == v.Kind() {
+		if "" == s {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return SetValueFromString(v.Elem(), s)
+	}
+
+	// empty leaves zero value
+	s = strings.TrimSpace(s)
+	if "" == s {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	// special types
+	switch v.Type() {
+	case reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(s)
+		if nil != err {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	case reflect.TypeOf(time.Time{}):
+		t, err := ParseTime(s)
+		if nil != err {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	// types that know how to parse themselves
+	if v.CanAddr() {
+		if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(s))
+		}
+	}
+
+	// basic kinds
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if nil != err {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if nil != err {
+			return err
+		}
+		v.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if nil != err {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := ParseBool(s)
+		if nil != err {
+			return err
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("can't convert string to %s", v.Type().String())
+	}
+
+	// done
+	return nil
+}
+
+// GetStringFromValue - converts v to text the way SetValueFromString reads it;
+// nil pointers and zero times are empty
+func GetStringFromValue(v reflect.Value) string {
+
+	// follow pointers
+	for v.IsValid() && reflect.Ptr == v.Kind() {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return ""
+	}
+
+	// special types
+	switch t := v.Interface().(type) {
+	case time.Time:
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339Nano)
+	case time.Duration:
+		return t.String()
+	case encoding.TextMarshaler:
+		text, err := t.MarshalText()
+		if nil != err {
+			return ""
+		}
+		return string(text)
+	case fmt.Stringer:
+		return t.String()
+	}
+	if v.CanAddr() {
+		if m, ok := v.Addr().Interface().(encoding.TextMarshaler); ok {
+			text, err := m.MarshalText()
+			if nil != err {
+				return ""
+			}
+			return string(text)
+		}
+	}
+
+	// basic kinds
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits())
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	}
+	return fmt.Sprint(v.Interface())
+}
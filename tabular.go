@@ -0,0 +1,497 @@
+package utl
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	l "github.com/stevenb256/log"
+)
+
+// Tabular formats
+const (
+	TabularXLSX = "xlsx"
+	TabularODS  = "ods"
+	TabularCSV  = "csv"
+	TabularTSV  = "tsv"
+)
+
+// Tabular is a forward only cursor over the rows of a table under a header row
+type Tabular interface {
+	IsDone() bool
+	Header() []string
+	Values() []string
+	String(name string) string
+	Int(name string) int
+	Date(name string) (time.Time, error)
+	Row() int
+	Err() error
+}
+
+// TabularOptions used to control how OpenTabular reads a file
+type TabularOptions struct {
+	ExcelOptions
+	Format    string // one of the Tabular formats; detected from content when empty
+	Delimiter rune   // csv field delimiter; sniffed from content when 0
+}
+
+// Table is a Tabular over rows of text from csv, tsv or ods files
+type Table struct {
+	next    func() ([]string, error)
+	number  func() int
+	close   func() error
+	matcher *headerMatcher
+	columns map[string]int
+	header  []string
+	row     []string
+	n       int
+	dates   ExcelDates
+	err     error
+}
+
+// OpenTabular opens xlsx, ods, csv or tsv content as a Tabular; the format is
+// detected from content unless given in options
+func OpenTabular(reader io.Reader, columns []string, options *TabularOptions) (Tabular, error) {
+
+	// default options
+	if nil == options {
+		options = &TabularOptions{}
+	}
+
+	// look at the start of the content
+	buffered := bufio.NewReaderSize(reader, 64*1024)
+	head, err := buffered.Peek(8192)
+	if nil != err && io.EOF != err && bufio.ErrBufferFull != err {
+		return nil, l.Fail(err)
+	}
+
+	// zip based formats need the whole thing
+	format := options.Format
+	if ("" == format && bytes.HasPrefix(head, []byte("PK\x03\x04"))) || TabularXLSX == format || TabularODS == format {
+		data, err := ioutil.ReadAll(buffered)
+		if l.Check(err) {
+			return nil, err
+		}
+		if "" == format {
+			format = DetectTabularFormat(data)
+		}
+		if TabularODS == format {
+			table, err := OpenODS(bytes.NewReader(data), int64(len(data)), columns, &options.ExcelOptions)
+			if l.Check(err) {
+				return nil, err
+			}
+			return table, nil
+		}
+		excel, err := OpenExcelWithOptions(bytes.NewReader(data), columns, &options.ExcelOptions)
+		if l.Check(err) {
+			return nil, err
+		}
+		return excel, nil
+	}
+
+	// text formats
+	text := decodeText(buffered, head)
+	delimiter := options.Delimiter
+	if TabularTSV == format {
+		delimiter = '\t'
+	}
+	if 0 == delimiter {
+		delimiter = sniffDelimiter(decodeSample(head))
+	}
+	table, err := OpenCSV(text, delimiter, columns, &options.ExcelOptions)
+	if l.Check(err) {
+		return nil, err
+	}
+	return table, nil
+}
+
+// DetectTabularFormat returns the Tabular format of content, or "" if unknown
+func DetectTabularFormat(data []byte) string {
+
+	// zip containers
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if nil != err {
+			return ""
+		}
+		for _, f := range z.File {
+			switch f.Name {
+			case "xl/workbook.xml":
+				return TabularXLSX
+			case "mimetype":
+				r, err := f.Open()
+				if nil != err {
+					return ""
+				}
+				mime, _ := ioutil.ReadAll(io.LimitReader(r, 100))
+				r.Close()
+				if strings.HasPrefix(string(mime), "application/vnd.oasis.opendocument.spreadsheet") {
+					return TabularODS
+				}
+			}
+		}
+		return ""
+	}
+
+	// text
+	if '\t' == sniffDelimiter(decodeSample(data)) {
+		return TabularTSV
+	}
+	return TabularCSV
+}
+
+// OpenCSV opens delimited text as a Tabular; the reader must give utf8 text
+func OpenCSV(reader io.Reader, delimiter rune, columns []string, options *ExcelOptions) (*Table, error) {
+	r := csv.NewReader(reader)
+	r.Comma = delimiter
+	r.LazyQuotes = true
+	r.FieldsPerRecord = -1
+	return openTable(r.Read, columns, options)
+}
+
+// openTable finds the header and maps columns for a table of rows from next
+func openTable(next func() ([]string, error), columns []string, options *ExcelOptions) (*Table, error) {
+
+	// default options
+	if nil == options {
+		options = &ExcelOptions{}
+	}
+	t := &Table{next: next, matcher: newHeaderMatcher(columns, options), dates: options.Dates}
+
+	// header is the first row with every required column, otherwise the row
+	// missing the fewest is reported
+	var missing []string
+	for i := 0; 0 == i || i < options.HeaderRows; i++ {
+		if !t.read() {
+			break
+		}
+		found, rowMissing := t.matcher.match(t.row)
+		if 0 == i || len(rowMissing) < len(missing) {
+			t.columns, t.header, missing = found, t.row, rowMissing
+		}
+		if 0 == len(missing) {
+			break
+		}
+	}
+	if l.Check(t.err) {
+		return nil, t.err
+	}
+	if nil == t.columns {
+		return nil, l.Fail(fmt.Errorf("no rows in table"))
+	}
+	if len(missing) > 0 {
+		return nil, l.Fail(fmt.Errorf("table must have column headers: %s", strings.Join(missing, ", ")))
+	}
+
+	// done
+	return t, nil
+}
+
+// read reads the next row; false at end or on error
+func (t *Table) read() bool {
+	row, err := t.next()
+	if io.EOF == err {
+		t.row = nil
+		return false
+	}
+	if l.Check(err) {
+		t.err = err
+		t.row = nil
+		return false
+	}
+	t.row = row
+	t.n++
+	return true
+}
+
+// IsDone returns true if no more rows
+func (t *Table) IsDone() bool {
+	return !t.read()
+}
+
+// Row returns the 1 based row number of the current row
+func (t *Table) Row() int {
+	if nil != t.number {
+		return t.number()
+	}
+	return t.n
+}
+
+// Close stops reading and releases what the table reads from
+func (t *Table) Close() error {
+	if nil == t.close {
+		return nil
+	}
+	close := t.close
+	t.close = nil
+	return close()
+}
+
+// Header returns the titles of the header row
+func (t *Table) Header() []string {
+	return t.header
+}
+
+// Values returns the values of the current row under the header
+func (t *Table) Values() []string {
+	values := make([]string, len(t.header))
+	copy(values, t.row)
+	return values
+}
+
+// Err returns error that stopped reading, if any
+func (t *Table) Err() error {
+	return t.err
+}
+
+// String gets current row/column as string
+func (t *Table) String(name string) string {
+	i, found := columnIndex(t.columns, name)
+	if !found || i >= len(t.row) {
+		return ""
+	}
+	return t.row[i]
+}
+
+// Int gets current row/column as int
+func (t *Table) Int(name string) int {
+	i, found := columnIndex(t.columns, name)
+	if !found || i >= len(t.row) {
+		return 0
+	}
+	return Atoi(strings.TrimSpace(t.row[i]))
+}
+
+// Date gets current row column as date; text is parsed as set by ExcelOptions.Dates
+func (t *Table) Date(name string) (time.Time, error) {
+	s := strings.TrimSpace(t.String(name))
+	if "" == s {
+		return time.Time{}, nil
+	}
+	return t.dates.parse(s)
+}
+
+// sniffDelimiter picks the delimiter that splits the first lines of text into
+// the same number of fields most consistently, ignoring quoted text
+func sniffDelimiter(text string) rune {
+
+	// locals
+	best := ','
+	bestScore := 0
+
+	// only whole lines
+	lines := strings.Split(text, "\n")
+	if len(lines) > 1 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > 20 {
+		lines = lines[:20]
+	}
+
+	// score each candidate
+	for _, delimiter := range []rune{',', '\t', ';', '|'} {
+		first := -1
+		score := 0
+		for _, line := range lines {
+			n := countOutsideQuotes(line, delimiter)
+			if -1 == first {
+				first = n
+			}
+			if n > 0 && n == first {
+				score += n + 1
+			}
+		}
+		if score > bestScore {
+			best, bestScore = delimiter, score
+		}
+	}
+	return best
+}
+
+// countOutsideQuotes counts delimiter in line when not inside double quotes
+func countOutsideQuotes(line string, delimiter rune) int {
+	n := 0
+	quoted := false
+	for _, r := range line {
+		if '"' == r {
+			quoted = !quoted
+		} else if r == delimiter && !quoted {
+			n++
+		}
+	}
+	return n
+}
+
+// decodeText returns a utf8 reader over text that may be utf16, utf8 with a
+// byte order mark or windows-1252; head is the start of the text
+func decodeText(reader io.Reader, head []byte) io.Reader {
+	switch textEncoding(head) {
+	case "utf-16le":
+		return &utf16Reader{reader: bufio.NewReader(reader), little: true, skip: hasUTF16BOM(head)}
+	case "utf-16be":
+		return &utf16Reader{reader: bufio.NewReader(reader), skip: hasUTF16BOM(head)}
+	case "windows-1252":
+		return &cp1252Reader{reader: reader}
+	}
+	if bytes.HasPrefix(head, []byte("\xEF\xBB\xBF")) {
+		io.CopyN(ioutil.Discard, reader, 3)
+	}
+	return reader
+}
+
+// decodeSample decodes the start of some text to utf8 for sniffing
+func decodeSample(head []byte) string {
+	data, _ := ioutil.ReadAll(decodeText(bytes.NewReader(head), head))
+	return string(data)
+}
+
+// textEncoding guesses encoding of text from its start
+func textEncoding(head []byte) string {
+
+	// byte order marks
+	if bytes.HasPrefix(head, []byte("\xFF\xFE")) {
+		return "utf-16le"
+	}
+	if bytes.HasPrefix(head, []byte("\xFE\xFF")) {
+		return "utf-16be"
+	}
+	if bytes.HasPrefix(head, []byte("\xEF\xBB\xBF")) {
+		return "utf-8"
+	}
+
+	// utf16 without a mark has lots of zero bytes on one side and few on the
+	// other, where only some code units like surrogates put them
+	var even, odd int
+	for i, b := range head {
+		if 0 == b {
+			if 0 == i%2 {
+				even++
+			} else {
+				odd++
+			}
+		}
+	}
+	if len(head) >= 4 && odd > len(head)/4 && even < odd/16 {
+		return "utf-16le"
+	}
+	if len(head) >= 4 && even > len(head)/4 && odd < even/16 {
+		return "utf-16be"
+	}
+
+	// invalid utf8 is most likely windows-1252; allow a rune cut off at the end
+	valid := head
+	for i := 0; i < utf8.UTFMax && len(valid) > 0 && !utf8.Valid(valid); i++ {
+		valid = valid[:len(valid)-1]
+	}
+	if !utf8.Valid(valid) {
+		return "windows-1252"
+	}
+	return "utf-8"
+}
+
+// hasUTF16BOM returns true if head starts with a utf16 byte order mark
+func hasUTF16BOM(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("\xFF\xFE")) || bytes.HasPrefix(head, []byte("\xFE\xFF"))
+}
+
+// utf16Reader decodes utf16 to utf8
+type utf16Reader struct {
+	reader  *bufio.Reader
+	little  bool
+	skip    bool
+	pending []byte
+}
+
+// Read reads utf8 bytes
+func (r *utf16Reader) Read(p []byte) (int, error) {
+
+	// drop byte order mark
+	if r.skip {
+		r.skip = false
+		r.reader.Discard(2)
+	}
+
+	// decode units until we have something to give back; a high surrogate at
+	// the end needs the unit after it
+	for len(r.pending) == 0 {
+		units := make([]uint16, 0, 512)
+		var err error
+		for len(units) < cap(units) {
+			var pair [2]byte
+			_, err = io.ReadFull(r.reader, pair[:])
+			if nil != err {
+				break
+			}
+			if r.little {
+				units = append(units, uint16(pair[0])|uint16(pair[1])<<8)
+			} else {
+				units = append(units, uint16(pair[1])|uint16(pair[0])<<8)
+			}
+		}
+		if n := len(units); n > 0 && nil == err && units[n-1] >= 0xd800 && units[n-1] < 0xdc00 {
+			var pair [2]byte
+			if _, e := io.ReadFull(r.reader, pair[:]); nil == e {
+				if r.little {
+					units = append(units, uint16(pair[0])|uint16(pair[1])<<8)
+				} else {
+					units = append(units, uint16(pair[1])|uint16(pair[0])<<8)
+				}
+			}
+		}
+		r.pending = []byte(string(utf16.Decode(units)))
+		if 0 == len(r.pending) && nil != err {
+			if io.ErrUnexpectedEOF == err {
+				err = io.EOF
+			}
+			return 0, err
+		}
+	}
+
+	// hand it out
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// cp1252 maps the windows-1252 bytes 0x80-0x9f that differ from latin-1
+var cp1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8d, 'Ž', 0x8f,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9d, 'ž', 'Ÿ',
+}
+
+// cp1252Reader decodes windows-1252 to utf8
+type cp1252Reader struct {
+	reader  io.Reader
+	pending []byte
+}
+
+// Read reads utf8 bytes
+func (r *cp1252Reader) Read(p []byte) (int, error) {
+	if 0 == len(r.pending) {
+		buf := make([]byte, len(p)/2+1)
+		n, err := r.reader.Read(buf)
+		var b strings.Builder
+		for _, c := range buf[:n] {
+			if c >= 0x80 && c < 0xa0 {
+				b.WriteRune(cp1252[c-0x80])
+			} else {
+				b.WriteRune(rune(c))
+			}
+		}
+		r.pending = []byte(b.String())
+		if 0 == len(r.pending) {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,462 @@
+package utl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	l "github.com/stevenb256/log"
+)
+
+// kinds of row change
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+// colors of rows and cells in a diff report
+var (
+	DiffAddedFill   = "FFC6EFCE"
+	DiffRemovedFill = "FFFFC7CE"
+	DiffChangedFill = "FFFFEB9C"
+)
+
+// TabularDiffOptions controls how rows are matched and compared
+type TabularDiffOptions struct {
+	Keys       []string // columns that together identify a row
+	Columns    []string // columns compared; every column of either header when empty
+	IgnoreCase bool     // compare keys and values without case
+}
+
+// TabularCellDiff is a value that changed
+type TabularCellDiff struct {
+	Column string `json:"column"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+}
+
+// TabularRowDiff is a row that was added, removed or changed
+type TabularRowDiff struct {
+	Change string            `json:"change"`
+	Key    []string          `json:"key"`
+	OldRow int               `json:"oldRow,omitempty"`
+	NewRow int               `json:"newRow,omitempty"`
+	Values map[string]string `json:"values"`
+	Cells  []TabularCellDiff `json:"cells,omitempty"`
+}
+
+// TabularDiff holds the differences between two tables; Values of a row are
+// the new values, or the old ones for a removed row
+type TabularDiff struct {
+	Keys      []string          `json:"keys"`
+	Columns   []string          `json:"columns"`
+	Rows      []*TabularRowDiff `json:"rows"`
+	Unchanged int               `json:"unchanged"`
+}
+
+// TabularMerge holds the rows of two tables merged by key columns
+type TabularMerge struct {
+	Columns []string   `json:"columns"`
+	Rows    [][]string `json:"rows"`
+}
+
+// diffRow is a row read from one side of a diff
+type diffRow struct {
+	row    int
+	key    []string
+	values map[string]string
+	match  *diffRow
+}
+
+// diffColumn is a compared column and its title in each table
+type diffColumn struct {
+	title string
+	old   string
+	new   string
+}
+
+// DiffSpreadsheets compares two xlsx, ods, csv or tsv files by key columns
+func DiffSpreadsheets(old io.Reader, new io.Reader, options *TabularDiffOptions) (*TabularDiff, error) {
+	before, after, err := openDiffTables(old, new, options)
+	if l.Check(err) {
+		return nil, err
+	}
+	return DiffTabular(before, after, options)
+}
+
+// MergeSpreadsheets merges two xlsx, ods, csv or tsv files by key columns
+func MergeSpreadsheets(old io.Reader, new io.Reader, options *TabularDiffOptions) (*TabularMerge, error) {
+	before, after, err := openDiffTables(old, new, options)
+	if l.Check(err) {
+		return nil, err
+	}
+	return MergeTabular(before, after, options)
+}
+
+// openDiffTables opens both sides of a diff needing the key columns
+func openDiffTables(old io.Reader, new io.Reader, options *TabularDiffOptions) (Tabular, Tabular, error) {
+
+	// need keys
+	if nil == options || 0 == len(options.Keys) {
+		return nil, nil, l.Fail(l.ErrInvalidArg, "diff needs at least one key column")
+	}
+
+	// open both
+	before, err := OpenTabular(old, options.Keys, nil)
+	if l.Check(err) {
+		return nil, nil, err
+	}
+	after, err := OpenTabular(new, options.Keys, nil)
+	if l.Check(err) {
+		return nil, nil, err
+	}
+	return before, after, nil
+}
+
+// DiffTabular compares the rows of two tables matched by key columns; rows with
+// the same key on one side are paired in order
+func DiffTabular(old Tabular, new Tabular, options *TabularDiffOptions) (*TabularDiff, error) {
+
+	// pair the rows
+	before, after, columns, err := matchTabular(old, new, options)
+	if l.Check(err) {
+		return nil, err
+	}
+	diff := &TabularDiff{Keys: options.Keys}
+	for _, column := range columns {
+		diff.Columns = append(diff.Columns, column.title)
+	}
+
+	// new rows are changed or added
+	for _, row := range after {
+		if nil == row.match {
+			diff.Rows = append(diff.Rows, &TabularRowDiff{Change: DiffAdded, Key: row.key, NewRow: row.row, Values: row.values})
+			continue
+		}
+		change := &TabularRowDiff{Change: DiffChanged, Key: row.key, OldRow: row.match.row, NewRow: row.row, Values: row.values}
+		for _, column := range columns {
+			a, b := row.match.values[column.old], row.values[column.new]
+			if !sameValue(a, b, options) {
+				change.Cells = append(change.Cells, TabularCellDiff{Column: column.title, Old: a, New: b})
+			}
+		}
+		if 0 == len(change.Cells) {
+			diff.Unchanged++
+		} else {
+			diff.Rows = append(diff.Rows, change)
+		}
+	}
+
+	// old rows left over were removed; their values go under the titles of the diff
+	for _, row := range before {
+		if nil != row.match {
+			continue
+		}
+		values := make(map[string]string)
+		for title, value := range row.values {
+			values[title] = value
+		}
+		for _, column := range columns {
+			if "" != column.old && column.old != column.title {
+				values[column.title] = values[column.old]
+				delete(values, column.old)
+			}
+		}
+		diff.Rows = append(diff.Rows, &TabularRowDiff{Change: DiffRemoved, Key: row.key, OldRow: row.row, Values: values})
+	}
+
+	// done
+	return diff, nil
+}
+
+// MergeTabular merges two tables by key columns; old rows keep their order and
+// take the new values of compared columns from their matching row, rows only
+// in old are kept and rows only in new are added at the end. Columns are those
+// of either header
+func MergeTabular(old Tabular, new Tabular, options *TabularDiffOptions) (*TabularMerge, error) {
+
+	// pair the rows
+	before, after, columns, err := matchTabular(old, new, options)
+	if l.Check(err) {
+		return nil, err
+	}
+
+	// every column of either table
+	all, err := diffColumns(old.Header(), new.Header(), nil)
+	if l.Check(err) {
+		return nil, err
+	}
+	merge := &TabularMerge{}
+	compared := make(map[string]bool)
+	for _, column := range columns {
+		compared[column.title] = true
+	}
+	for _, column := range all {
+		merge.Columns = append(merge.Columns, column.title)
+	}
+
+	// old rows updated from new ones
+	for _, row := range before {
+		values := make([]string, len(all))
+		for i, column := range all {
+			values[i] = row.values[column.old]
+			if nil != row.match && compared[column.title] && "" != column.new {
+				values[i] = row.match.values[column.new]
+			}
+		}
+		merge.Rows = append(merge.Rows, values)
+	}
+
+	// then the added ones
+	for _, row := range after {
+		if nil != row.match {
+			continue
+		}
+		values := make([]string, len(all))
+		for i, column := range all {
+			values[i] = row.values[column.new]
+		}
+		merge.Rows = append(merge.Rows, values)
+	}
+
+	// done
+	return merge, nil
+}
+
+// matchTabular reads both tables, resolves the columns to compare and pairs
+// rows by key; paired rows point at each other
+func matchTabular(old Tabular, new Tabular, options *TabularDiffOptions) ([]*diffRow, []*diffRow, []diffColumn, error) {
+
+	// need keys
+	if nil == options || 0 == len(options.Keys) {
+		return nil, nil, nil, l.Fail(l.ErrInvalidArg, "diff needs at least one key column")
+	}
+
+	// columns to compare
+	columns, err := diffColumns(old.Header(), new.Header(), options.Columns)
+	if l.Check(err) {
+		return nil, nil, nil, err
+	}
+
+	// read the old rows
+	before, err := readDiffRows(old, options)
+	if l.Check(err) {
+		return nil, nil, nil, err
+	}
+	byKey := make(map[string][]*diffRow)
+	for _, row := range before {
+		k := diffKey(row.key, options)
+		byKey[k] = append(byKey[k], row)
+	}
+
+	// match new rows against them
+	after, err := readDiffRows(new, options)
+	if l.Check(err) {
+		return nil, nil, nil, err
+	}
+	for _, row := range after {
+		k := diffKey(row.key, options)
+		if list := byKey[k]; len(list) > 0 {
+			byKey[k] = list[1:]
+			row.match, list[0].match = list[0], row
+		}
+	}
+
+	// done
+	return before, after, columns, nil
+}
+
+// diffColumns finds columns in the headers of two tables the way header
+// titles are matched, so "Unit Price" names "unit_price"; every column of
+// either header when names is empty, otherwise a name in neither is an error
+func diffColumns(old []string, new []string, names []string) ([]diffColumn, error) {
+
+	// titles of each header by normalized name
+	oldTitles, newTitles := headerTitles(old), headerTitles(new)
+	if 0 == len(names) {
+		names = append(append([]string{}, old...), new...)
+	}
+
+	// resolve each once
+	var columns []diffColumn
+	seen := make(map[string]bool)
+	for _, name := range names {
+		key := normalizeHeader(name)
+		if "" == key || seen[key] {
+			continue
+		}
+		seen[key] = true
+		column := diffColumn{old: oldTitles[key], new: newTitles[key]}
+		column.title = column.new
+		if "" == column.title {
+			column.title = column.old
+		}
+		if "" == column.title {
+			return nil, l.Fail(fmt.Errorf("column '%s' is in neither table", name))
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// headerTitles maps the normalized titles of a header to the titles
+func headerTitles(header []string) map[string]string {
+	titles := make(map[string]string)
+	for _, title := range header {
+		key := normalizeHeader(title)
+		if _, found := titles[key]; !found && "" != key {
+			titles[key] = strings.TrimSpace(title)
+		}
+	}
+	return titles
+}
+
+// readDiffRows reads every row of a table keyed by header title
+func readDiffRows(t Tabular, options *TabularDiffOptions) ([]*diffRow, error) {
+	var rows []*diffRow
+	for !t.IsDone() {
+		row := &diffRow{row: t.Row(), values: make(map[string]string)}
+		header := t.Header()
+		for i, value := range t.Values() {
+			title := strings.TrimSpace(header[i])
+			if _, found := row.values[title]; !found && "" != title {
+				row.values[title] = value
+			}
+		}
+		for _, key := range options.Keys {
+			row.key = append(row.key, strings.TrimSpace(t.String(key)))
+		}
+		rows = append(rows, row)
+	}
+	if l.Check(t.Err()) {
+		return nil, t.Err()
+	}
+	return rows, nil
+}
+
+// diffKey joins key values into a map key
+func diffKey(key []string, options *TabularDiffOptions) string {
+	k := strings.Join(key, "\x00")
+	if options.IgnoreCase {
+		k = strings.ToLower(k)
+	}
+	return k
+}
+
+// sameValue compares two values ignoring surrounding space
+func sameValue(a string, b string, options *TabularDiffOptions) bool {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	if options.IgnoreCase {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// Count returns the number of rows with a kind of change
+func (d *TabularDiff) Count(change string) int {
+	n := 0
+	for _, row := range d.Rows {
+		if change == row.Change {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteJSON writes the diff as indented json
+func (d *TabularDiff) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(d)
+}
+
+// WriteExcel writes the diff as a workbook with a row per change; key columns
+// come first so each row can be found, added rows are green, removed rows red
+// and changed cells yellow showing "old → new"
+func (d *TabularDiff) WriteExcel(writer io.Writer) error {
+
+	// compared columns that are not keys follow the keys
+	keys := make(map[string]bool)
+	for _, key := range d.Keys {
+		keys[normalizeHeader(key)] = true
+	}
+	var columns []string
+	for _, column := range d.Columns {
+		if !keys[normalizeHeader(column)] {
+			columns = append(columns, column)
+		}
+	}
+
+	// a sheet with a row per change
+	w := NewExcelWriter(writer)
+	header := append(append([]string{"Change"}, d.Keys...), columns...)
+	err := w.AddSheet("Diff", header, &ExcelSheetOptions{BoldHeader: true, FreezeHeader: true})
+	if l.Check(err) {
+		return err
+	}
+	for _, row := range d.Rows {
+
+		// whole row colored when added or removed
+		fill := ""
+		switch row.Change {
+		case DiffAdded:
+			fill = DiffAddedFill
+		case DiffRemoved:
+			fill = DiffRemovedFill
+		}
+		values := []interface{}{row.Change}
+		for i := range d.Keys {
+			key := ""
+			if i < len(row.Key) {
+				key = row.Key[i]
+			}
+			values = append(values, key)
+		}
+		for _, column := range columns {
+			values = append(values, row.Values[column])
+		}
+
+		// changed cells show both values
+		for _, cell := range row.Cells {
+			for j, column := range columns {
+				if column == cell.Column {
+					values[1+len(d.Keys)+j] = ExcelFill{Value: fmt.Sprintf("%s → %s", cell.Old, cell.New), Color: DiffChangedFill}
+				}
+			}
+		}
+		if "" != fill {
+			for j, value := range values {
+				values[j] = ExcelFill{Value: value, Color: fill}
+			}
+		}
+		err = w.AddRow(values...)
+		if l.Check(err) {
+			return err
+		}
+	}
+
+	// done
+	return w.Close()
+}
+
+// WriteExcel writes the merged rows as a workbook
+func (m *TabularMerge) WriteExcel(writer io.Writer) error {
+	w := NewExcelWriter(writer)
+	err := w.AddSheet("Merged", m.Columns, &ExcelSheetOptions{BoldHeader: true, FreezeHeader: true})
+	if l.Check(err) {
+		return err
+	}
+	for _, row := range m.Rows {
+		values := make([]interface{}, len(row))
+		for i, value := range row {
+			values[i] = value
+		}
+		err = w.AddRow(values...)
+		if l.Check(err) {
+			return err
+		}
+	}
+	return w.Close()
+}
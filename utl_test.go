@@ -1,10 +1,27 @@
 package utl
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"time"
+	"unicode/utf16"
 
 	l "github.com/stevenb256/log"
+	"github.com/tealeg/xlsx"
 )
 
 // object
@@ -132,3 +149,2662 @@ func TestMain(t *testing.T) {
 	// set rawSlice pointer into slicecopy
 	SetPointer(rawSlice.Interface(), &sliceCopy)
 }
+
+// order is a row read from a spreadsheet
+type order struct {
+	Customer string    `xlsx:"Customer,required"`
+	Quantity *int      `xlsx:"Qty"`
+	Price    float64   `xlsx:"Price"`
+	Shipped  time.Time `xlsx:"Shipped"`
+}
+
+// TestUnmarshalExcel - tests reading rows into structs
+func TestUnmarshalExcel(t *testing.T) {
+
+	// build a workbook
+	file := xlsx.NewFile()
+	sheet, err := file.AddSheet("Orders")
+	if nil != err {
+		t.Fatal(err)
+	}
+	row := sheet.AddRow()
+	for _, header := range []string{"Customer", "Qty", "Price", "Shipped"} {
+		row.AddCell().SetString(header)
+	}
+	row = sheet.AddRow()
+	row.AddCell().SetString("acme")
+	row.AddCell().SetInt(3)
+	row.AddCell().SetFloat(1.5)
+	row.AddCell().SetDate(time.Date(2021, 4, 3, 0, 0, 0, 0, time.UTC))
+	row = sheet.AddRow()
+	row.AddCell().SetString("")
+	row.AddCell().SetString("three")
+	row.AddCell().SetFloat(2)
+	row.AddCell().SetString("2021-05-01")
+	var buf bytes.Buffer
+	err = file.Write(&buf)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	// read it
+	var orders []*order
+	err = UnmarshalExcel(bytes.NewReader(buf.Bytes()), &orders, nil)
+	errs, ok := err.(ExcelErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expected two cell errors: %v", err)
+	}
+	if errs[0].Row != 3 || errs[0].Column != "Customer" || errs[1].Column != "Qty" {
+		t.Errorf("wrong error coordinates: %v", err)
+	}
+
+	// validate
+	if len(orders) != 2 || orders[0].Customer != "acme" || nil == orders[0].Quantity || *orders[0].Quantity != 3 {
+		t.Errorf("first row not read right")
+	}
+	if orders[1].Price != 2 || orders[1].Shipped.Month() != time.May || orders[0].Shipped.Day() != 3 {
+		t.Errorf("second row not read right")
+	}
+}
+
+// BenchmarkGobEncode - encodes each object with its own encoder
+func BenchmarkGobEncode(b *testing.B) {
+	o := &object{S: "benchmark", I: 42, F: 3.5}
+	for i := 0; i < b.N; i++ {
+		if _, err := GobEncode(o); nil != err {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGobStreamEncode - encodes objects as records of one stream
+func BenchmarkGobStreamEncode(b *testing.B) {
+	o := &object{S: "benchmark", I: 42, F: 3.5}
+	encoder := NewGobStreamEncoder(io.Discard)
+	for i := 0; i < b.N; i++ {
+		if err := encoder.Encode(o); nil != err {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGobDecode - decodes each object with its own decoder
+func BenchmarkGobDecode(b *testing.B) {
+	buf, err := GobEncode(&object{S: "benchmark", I: 42, F: 3.5})
+	if nil != err {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var o object
+		if err := GobDecode(buf, &o); nil != err {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGobStreamDecode - decodes records of one stream
+func BenchmarkGobStreamDecode(b *testing.B) {
+	var buf bytes.Buffer
+	encoder := NewGobStreamEncoder(&buf)
+	for i := 0; i < b.N; i++ {
+		if err := encoder.Encode(&object{S: "benchmark", I: i, F: 3.5}); nil != err {
+			b.Fatal(err)
+		}
+	}
+	decoder := NewGobStreamDecoder(&buf)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var o object
+		if err := decoder.Decode(&o); nil != err {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestJSONPatch - runs the examples of rfc 6901, 6902 and 7386
+func TestJSONPatch(t *testing.T) {
+
+	// rfc 6902 appendix a; empty result means the patch must fail
+	patches := []struct{ doc, patch, result string }{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, ``},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, ``},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, ``},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+	}
+	for i, c := range patches {
+		var patch JSONPatch
+		if err := json.Unmarshal([]byte(c.patch), &patch); nil != err {
+			t.Fatal(err)
+		}
+		result, err := ApplyJSONPatch([]byte(c.doc), patch)
+		if "" == c.result {
+			if nil == err {
+				t.Errorf("patch %d should have failed", i)
+			}
+			continue
+		}
+		if nil != err || !sameJSON(t, result, c.result) {
+			t.Errorf("patch %d gave %s %v", i, result, err)
+		}
+	}
+
+	// rfc 7386 appendix a
+	merges := []struct{ doc, patch, result string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for i, c := range merges {
+		result, err := MergePatch([]byte(c.doc), []byte(c.patch))
+		if nil != err || !sameJSON(t, result, c.result) {
+			t.Errorf("merge %d gave %s %v", i, result, err)
+		}
+	}
+
+	// rfc 6901 section 5
+	doc, _ := decodeJSONGeneric([]byte(`{"foo":["bar","baz"],"":0,"a/b":1,"c%d":2,"e^f":3,"g|h":4,"i\\j":5,"k\"l":6," ":7,"m~n":8}`))
+	pointers := map[string]string{
+		"":     `{"foo":["bar","baz"],"":0,"a/b":1,"c%d":2,"e^f":3,"g|h":4,"i\\j":5,"k\"l":6," ":7,"m~n":8}`,
+		"/foo": `["bar","baz"]`, "/foo/0": `"bar"`, "/": `0`, "/a~1b": `1`, "/c%d": `2`, "/e^f": `3`,
+		"/g|h": `4`, "/i\\j": `5`, "/k\"l": `6`, "/ ": `7`, "/m~0n": `8`,
+	}
+	for pointer, want := range pointers {
+		value, err := JSONPointerGet(doc, pointer)
+		got, _ := json.Marshal(value)
+		if nil != err || !sameJSON(t, got, want) {
+			t.Errorf("pointer '%s' gave %s %v", pointer, got, err)
+		}
+	}
+
+	// diff of go values applies back
+	before := map[string]interface{}{"a": 1, "b": []int{1, 2}, "c": map[string]string{"x": "y"}}
+	after := map[string]interface{}{"a": 2, "b": []int{1, 2, 3}, "d": true}
+	patch, err := CreateJSONPatch(before, after)
+	if nil != err {
+		t.Fatal(err)
+	}
+	err = ApplyJSONPatchToObject(&before, patch)
+	if nil != err || !reflect.DeepEqual(before, map[string]interface{}{"a": 2.0, "b": []interface{}{1.0, 2.0, 3.0}, "d": true}) {
+		t.Errorf("diff patch gave %v %v", before, err)
+	}
+
+	// fields json doesn't carry survive patching, nested ones too
+	account := patchAccount{Name: "a", Secret: "secret", count: 3, Limits: &patchLimits{Max: 1, cache: "c"}, Tags: []string{"x"}}
+	err = ApplyJSONPatchToObject(&account, JSONPatch{{Op: "replace", Path: "/name", Value: json.RawMessage(`"b"`)}, {Op: "replace", Path: "/limits/max", Value: json.RawMessage(`2`)}})
+	want := patchAccount{Name: "b", Secret: "secret", count: 3, Limits: &patchLimits{Max: 2, cache: "c"}, Tags: []string{"x"}}
+	if nil != err || !reflect.DeepEqual(want, account) {
+		t.Fatal(account, err)
+	}
+	err = ApplyMergePatchToObject(&account, []byte(`{"tags": null, "limits": null}`))
+	want = patchAccount{Name: "b", Secret: "secret", count: 3}
+	if nil != err || !reflect.DeepEqual(want, account) {
+		t.Fatal(account, err)
+	}
+}
+
+// patchLimits - a nested part of a patched object with a field json doesn't carry
+type patchLimits struct {
+	Max   int `json:"max"`
+	cache string
+}
+
+// patchAccount - a patched object with fields json doesn't carry
+type patchAccount struct {
+	Name   string `json:"name"`
+	Secret string `json:"-"`
+	count  int
+	Limits *patchLimits `json:"limits"`
+	Tags   []string     `json:"tags"`
+}
+
+// sameJSON - compares json text by value
+func sameJSON(t *testing.T, got []byte, want string) bool {
+	a, err := decodeJSONGeneric(got)
+	if nil != err {
+		t.Fatal(err)
+	}
+	b, err := decodeJSONGeneric([]byte(want))
+	if nil != err {
+		t.Fatal(err)
+	}
+	return jsonEqual(a, b)
+}
+
+// TestFileSystems - runs the file helpers against memory and overlay file systems
+func TestFileSystems(t *testing.T) {
+
+	// lower layer holds a config and a data file
+	lower := NewMemoryFS()
+	err := SaveJSONObjectFS(lower, "config/app.json", &object{S: "base", I: 1})
+	if nil != err {
+		t.Fatal(err)
+	}
+	err = WriteFileFS(lower, "data/a.txt", []byte("a"))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	// changes to the overlay don't reach the lower layer
+	overlay := NewOverlayFS(lower, nil)
+	err = SaveJSONObjectFS(overlay, "config/app.json", &object{S: "changed", I: 2})
+	if nil != err {
+		t.Fatal(err)
+	}
+	err = MoveFileFS(overlay, "data/a.txt", "data/b.txt")
+	if nil != err {
+		t.Fatal(err)
+	}
+	var o object
+	err = LoadJSONObjectFS(overlay, "config/app.json", &o)
+	if nil != err || "changed" != o.S {
+		t.Fatal(err, o)
+	}
+	err = LoadJSONObjectFS(lower, "config/app.json", &o)
+	if nil != err || "base" != o.S {
+		t.Fatal(err, o)
+	}
+	if DoesFileExistFS(overlay, "data/a.txt") || !DoesFileExistFS(overlay, "data/b.txt") ||
+		!DoesFileExistFS(lower, "data/a.txt") || DoesFileExistFS(lower, "data/b.txt") {
+		t.Fatal("move through overlay")
+	}
+
+	// both behave as an fs.FS
+	err = fstest.TestFS(lower, "config/app.json", "data/a.txt")
+	if nil != err {
+		t.Fatal(err)
+	}
+	err = fstest.TestFS(overlay, "config/app.json", "data/b.txt")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if !IsDirectoryFS(overlay, "data") || IsDirectoryFS(overlay, "data/b.txt") {
+		t.Fatal("directories")
+	}
+}
+
+// testWorkbook - builds an xlsx file with a sheet per name holding rows of text
+func testWorkbook(t *testing.T, names []string, sheets ...[][]string) []byte {
+	file := xlsx.NewFile()
+	for i, name := range names {
+		sheet, err := file.AddSheet(name)
+		if nil != err {
+			t.Fatal(err)
+		}
+		for _, values := range sheets[i] {
+			row := sheet.AddRow()
+			for _, value := range values {
+				row.AddCell().SetString(value)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	err := file.Write(&buf)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestExcelSheets - tests picking sheets and reading them all in order
+func TestExcelSheets(t *testing.T) {
+
+	// three sheets, the middle one empty
+	data := testWorkbook(t, []string{"One", "Empty", "Two"},
+		[][]string{{"Name", "Qty"}, {"a", "1"}, {"b", "2"}},
+		[][]string{},
+		[][]string{{"Qty", "Name"}, {"3", "c"}})
+
+	// names in workbook order
+	names, err := ExcelSheetNames(bytes.NewReader(data))
+	if nil != err || !AreStringSliceSame(names, []string{"One", "Empty", "Two"}) {
+		t.Fatal(err, names)
+	}
+
+	// every sheet in order, columns found by header on each
+	excel, err := OpenExcelAllSheets(bytes.NewReader(data), []string{"Name", "Qty"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	var got []string
+	for !excel.IsDone() {
+		got = append(got, fmt.Sprintf("%s:%d:%s=%d", excel.SheetName(), excel.Row(), excel.String("Name"), excel.Int("Qty")))
+	}
+	if nil != excel.Err() || !AreStringSliceSame(got, []string{"One:2:a=1", "One:3:b=2", "Two:2:c=3"}) {
+		t.Fatal(excel.Err(), got)
+	}
+
+	// one sheet by name or index
+	excel, err = OpenExcelSheet(bytes.NewReader(data), "two", []string{"Name"})
+	if nil != err || excel.IsDone() || "c" != excel.String("Name") || !excel.IsDone() {
+		t.Fatal("sheet by name", err)
+	}
+	_, err = OpenExcelSheet(bytes.NewReader(data), "Three", []string{"Name"})
+	if nil == err {
+		t.Fatal("missing sheet opened")
+	}
+	_, err = OpenExcelWithOptions(bytes.NewReader(data), []string{"Name"}, &ExcelOptions{SheetIndex: 3})
+	if nil == err {
+		t.Fatal("sheet index out of range opened")
+	}
+	_, err = OpenExcelWithOptions(bytes.NewReader(data), []string{"Name"}, &ExcelOptions{SheetIndex: 1})
+	if nil == err {
+		t.Fatal("empty sheet opened")
+	}
+}
+
+// TestMarshalExcel - writes structs and rows then reads them back
+func TestMarshalExcel(t *testing.T) {
+
+	// a sheet from structs and one from rows
+	qty := 7
+	orders := []*order{
+		{Customer: "acme & co", Quantity: &qty, Price: 1.25, Shipped: time.Date(2021, 4, 3, 0, 0, 0, 0, time.UTC)},
+		{Customer: "<b>", Price: 2},
+	}
+	var buf bytes.Buffer
+	w := NewExcelWriter(&buf)
+	err := w.AddSlice("Orders", orders, &ExcelSheetOptions{
+		BoldHeader:   true,
+		FreezeHeader: true,
+		Widths:       map[string]float64{"Customer": 30},
+		Formats:      map[string]string{"Price": "#,##0.00"},
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	err = w.AddRows("Totals", []string{"Name", "Total", "Paid"}, [][]interface{}{
+		{"acme", 8.75, true},
+		{ExcelFill{Value: "late", Color: "FFFFC7CE"}, nil, false},
+		{"odd", float32(0.1), math.NaN()},
+	}, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if nil == w.AddSheet("orders", []string{"x"}, nil) {
+		t.Fatal("duplicate sheet name added")
+	}
+	err = w.Close()
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	// structs come back the same
+	var back []*order
+	err = UnmarshalExcel(bytes.NewReader(buf.Bytes()), &back, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 2 != len(back) || "acme & co" != back[0].Customer || nil == back[0].Quantity || 7 != *back[0].Quantity ||
+		1.25 != back[0].Price || !back[0].Shipped.Equal(orders[0].Shipped) || "<b>" != back[1].Customer ||
+		nil != back[1].Quantity || !back[1].Shipped.IsZero() {
+		t.Fatalf("round trip lost values: %+v %+v", back[0], back[1])
+	}
+
+	// formats, styles and the second sheet
+	file, err := xlsx.OpenBinary(buf.Bytes())
+	if nil != err {
+		t.Fatal(err)
+	}
+	sheet := file.Sheet["Orders"]
+	if "#,##0.00" != sheet.Rows[1].Cells[2].NumFmt || !sheet.Rows[0].Cells[0].GetStyle().Font.Bold ||
+		30 != sheet.Cols[0].Width || "frozen" != sheet.SheetViews[0].Pane.State {
+		t.Error("sheet options not written")
+	}
+	totals := file.Sheet["Totals"]
+	if 4 != len(totals.Rows) || "8.75" != totals.Rows[1].Cells[1].Value || "1" != totals.Rows[1].Cells[2].Value ||
+		"late" != totals.Rows[2].Cells[0].Value || "FFFFC7CE" != totals.Rows[2].Cells[0].GetStyle().Fill.FgColor {
+		t.Error("rows sheet not written")
+	}
+	if "0.1" != totals.Rows[3].Cells[1].Value || (3 == len(totals.Rows[3].Cells) && "" != totals.Rows[3].Cells[2].Value) {
+		t.Error("float32 or NaN not written as expected", totals.Rows[3].Cells)
+	}
+	if _, text := cellText(math.Inf(-1)); "" != text {
+		t.Error("infinity written as", text)
+	}
+
+	// the streaming reader sees the same
+	stream, err := OpenExcelStream(bytes.NewReader(buf.Bytes()), int64(buf.Len()), []string{"Name", "Total"}, &ExcelOptions{Sheet: "Totals"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if stream.IsDone() || "acme" != stream.String("Name") || stream.IsDone() || "late" != stream.String("Name") || stream.IsDone() || !stream.IsDone() {
+		t.Error("streaming reader didn't read written rows")
+	}
+}
+
+// testZip - zips named parts
+func testZip(t *testing.T, parts map[string]string) []byte {
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	for name, part := range parts {
+		w, err := z.Create(name)
+		if nil != err {
+			t.Fatal(err)
+		}
+		io.WriteString(w, part)
+	}
+	err := z.Close()
+	if nil != err {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testStreamBook - makes an xlsx from raw sheet data xml; rows have no r attribute
+func testStreamBook(t *testing.T, sheets ...string) []byte {
+	parts := make(map[string]string)
+	workbook := `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`
+	rels := `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`
+	for i, data := range sheets {
+		workbook += fmt.Sprintf(`<sheet name="S%d" sheetId="%d" r:id="rId%d"/>`, i+1, i+1, i+1)
+		rels += fmt.Sprintf(`<Relationship Id="rId%d" Type="worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+		parts[fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1)] = `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` + data + `</sheetData></worksheet>`
+	}
+	parts["xl/workbook.xml"] = workbook + `</sheets></workbook>`
+	parts["xl/_rels/workbook.xml.rels"] = rels + `</Relationships>`
+	return testZip(t, parts)
+}
+
+// TestExcelStream - tests row numbers across sheets and header errors
+func TestExcelStream(t *testing.T) {
+
+	// rows without r attributes are counted from the top of each sheet
+	row := func(values ...string) string {
+		s := "<row>"
+		for _, value := range values {
+			s += `<c t="inlineStr"><is><t>` + value + `</t></is></c>`
+		}
+		return s + "</row>"
+	}
+	data := testStreamBook(t, row("Name")+row("a")+row("b"), row("Name")+row("c"))
+	stream, err := OpenExcelStream(bytes.NewReader(data), int64(len(data)), []string{"Name"}, &ExcelOptions{AllSheets: true})
+	if nil != err {
+		t.Fatal(err)
+	}
+	var got []string
+	for !stream.IsDone() {
+		got = append(got, fmt.Sprintf("%s:%d:%s", stream.SheetName(), stream.Row(), stream.String("Name")))
+	}
+	stream.Close()
+	if nil != stream.Err() || !AreStringSliceSame(got, []string{"S1:2:a", "S1:3:b", "S2:2:c"}) {
+		t.Fatal(stream.Err(), got)
+	}
+
+	// missing columns are those of the row closest to being a header
+	data = testStreamBook(t, row("Report")+row("Name", "Price")+row("x", "y"))
+	_, err = OpenExcelStream(bytes.NewReader(data), int64(len(data)), []string{"Name", "Qty"}, &ExcelOptions{HeaderRows: 3})
+	if nil == err || !strings.HasSuffix(err.Error(), "must have column headers: Qty") {
+		t.Fatal(err)
+	}
+}
+
+// TestExcelHeaders - tests finding columns by alias, abbreviation, typo and merged cells
+func TestExcelHeaders(t *testing.T) {
+
+	// header under a title row with messy, aliased, abbreviated and misspelled titles
+	data := testWorkbook(t, []string{"S"}, [][]string{
+		{"Orders for May"},
+		{" Customer_Name ", "Cust. No", "Qty", "Adress", "Notes"},
+		{"acme", "17", "3", "1 Main St", "x"},
+	})
+	options := &ExcelOptions{
+		HeaderRows:   3,
+		FuzzyHeaders: true,
+		Columns: []ExcelColumn{
+			{Name: "Number", Aliases: []string{"Customer Number", "Cust No"}},
+			{Name: "Phone", Optional: true},
+		},
+	}
+	excel, err := OpenExcelWithOptions(bytes.NewReader(data), []string{"customer name", "Quantity", "Address", "Number"}, options)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if excel.IsDone() || 3 != excel.Row() || "acme" != excel.String("Customer Name") || 17 != excel.Int("Number") ||
+		3 != excel.Int("quantity") || "1 Main St" != excel.String("Address") || excel.Has("Phone") {
+		t.Fatalf("columns not found: %v", excel.Values())
+	}
+
+	// without fuzzy matching abbreviations and typos are missing
+	_, err = OpenExcelWithOptions(bytes.NewReader(data), []string{"Quantity", "Address"}, &ExcelOptions{HeaderRows: 3})
+	if nil == err || !strings.HasSuffix(err.Error(), "must have column headers: Quantity, Address") {
+		t.Fatal(err)
+	}
+
+	// one abbreviation can't be claimed by several columns
+	data = testWorkbook(t, []string{"S"}, [][]string{{"St", "Status"}, {"x", "y"}})
+	_, err = OpenExcelWithOptions(bytes.NewReader(data), []string{"State", "Street", "Status"}, &ExcelOptions{FuzzyHeaders: true})
+	if nil == err || !strings.HasSuffix(err.Error(), "State (ambiguous: St), Street (ambiguous: St)") {
+		t.Fatal(err)
+	}
+
+	// a header merged over two rows names the column in the row below
+	file := xlsx.NewFile()
+	sheet, _ := file.AddSheet("S")
+	row := sheet.AddRow()
+	cell := row.AddCell()
+	cell.SetString("Name")
+	cell.Merge(0, 1)
+	row.AddCell().SetString("Amount")
+	row.AddCell()
+	row.Cells[1].Merge(1, 0)
+	row = sheet.AddRow()
+	row.AddCell()
+	row.AddCell().SetString("Net")
+	row.AddCell().SetString("Tax")
+	row = sheet.AddRow()
+	row.AddCell().SetString("acme")
+	row.AddCell().SetInt(10)
+	row.AddCell().SetInt(2)
+	var buf bytes.Buffer
+	err = file.Write(&buf)
+	if nil != err {
+		t.Fatal(err)
+	}
+	excel, err = OpenExcelWithOptions(&buf, []string{"Name", "Tax"}, &ExcelOptions{HeaderRows: 2})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if excel.IsDone() || "acme" != excel.String("Name") || 2 != excel.Int("Tax") {
+		t.Fatalf("merged header not read: %v", excel.Header())
+	}
+}
+
+// TestExcelTypedCells - tests typed accessors, number formats and their errors
+func TestExcelTypedCells(t *testing.T) {
+
+	// a row of typed cells
+	file := xlsx.NewFile()
+	sheet, _ := file.AddSheet("S")
+	header := []string{"Int", "Frac", "Money", "Flag", "Pct", "PctText", "Plain", "Time", "Clock", "Days", "Empty", "Big", "Min"}
+	row := sheet.AddRow()
+	for _, title := range header {
+		row.AddCell().SetString(title)
+	}
+	row = sheet.AddRow()
+	row.AddCell().SetInt(42)
+	row.AddCell().SetFloat(1.5)
+	row.AddCell().SetString("($1,234.50)")
+	row.AddCell().SetBool(true)
+	row.AddCell().SetFloatWithFormat(0.15, "0.00%")
+	row.AddCell().SetString("15%")
+	row.AddCell().SetFloat(90)
+	row.AddCell().SetFloatWithFormat(0.0625, "[h]:mm")
+	row.AddCell().SetString("1:30:15")
+	row.AddCell().SetFloatWithFormat(2, "yyyy-mm-dd")
+	row.AddCell().SetString(" ")
+	row.AddCell().SetString("9223372036854775808")
+	row.AddCell().SetString("-9223372036854775808")
+	var buf bytes.Buffer
+	err := file.Write(&buf)
+	if nil != err {
+		t.Fatal(err)
+	}
+	excel, err := OpenExcel(&buf, header)
+	if nil != err || excel.IsDone() {
+		t.Fatal(err)
+	}
+
+	// values
+	i, err := excel.Int64("Int")
+	if nil != err || 42 != i {
+		t.Error("Int64", i, err)
+	}
+	f, err := excel.Float64("Money")
+	if nil != err || -1234.5 != f {
+		t.Error("Float64", f, err)
+	}
+	b, err := excel.Bool("Flag")
+	if nil != err || !b {
+		t.Error("Bool", b, err)
+	}
+	for _, name := range []string{"Pct", "PctText"} {
+		f, err = excel.Percent(name)
+		if nil != err || 0.15 != f {
+			t.Error("Percent", name, f, err)
+		}
+	}
+	d, err := excel.Duration("Time")
+	if nil != err || 90*time.Minute != d {
+		t.Error("Duration", d, err)
+	}
+	d, err = excel.Duration("Clock")
+	if nil != err || time.Hour+30*time.Minute+15*time.Second != d {
+		t.Error("Duration", d, err)
+	}
+
+	// errors say what is wrong and where
+	var cellErr *ExcelCellError
+	_, err = excel.Int64("Frac")
+	if !errors.As(err, &cellErr) || ErrNotWholeNumber != cellErr.Err || 2 != cellErr.Row || 1 != cellErr.Index {
+		t.Error("fraction as int", err)
+	}
+	_, err = excel.Int64("Big")
+	if !errors.As(err, &cellErr) || ErrNotWholeNumber != cellErr.Err {
+		t.Error("2^63 as int", err)
+	}
+	i, err = excel.Int64("Min")
+	if nil != err || math.MinInt64 != i {
+		t.Error("-2^63 as int", i, err)
+	}
+	_, err = excel.Float64("Nope")
+	if !errors.As(err, &cellErr) || ErrMissingColumn != cellErr.Err || excel.Has("Nope") {
+		t.Error("missing column", err)
+	}
+	_, err = excel.Text("Empty")
+	if !errors.As(err, &cellErr) || ErrEmptyCell != cellErr.Err || !excel.IsEmpty("Empty") {
+		t.Error("empty cell", err)
+	}
+	_, err = excel.Percent("Plain")
+	if nil == err {
+		t.Error("plain number read as a percent")
+	}
+	for _, name := range []string{"Plain", "Days"} {
+		_, err = excel.Duration(name)
+		if nil == err {
+			t.Error("number without a time format read as a duration", name)
+		}
+	}
+}
+
+// TestValidateExcel - tests column and row rules across sheets and the report
+func TestValidateExcel(t *testing.T) {
+
+	// two sheets of orders
+	data := testWorkbook(t, []string{"East", "West"},
+		[][]string{
+			{"Id", "Email", "Qty", "Status", "Due", "Ship"},
+			{"1", "a@x.com", "5", "open", "2021-03-01", "2021-03-02"},
+			{"2", "bad", "0", "lost", "2019-01-01", "2021-03-01"},
+			{"3", "", "500", "Closed", "2021-04-01", "2021-03-01"},
+		},
+		[][]string{
+			{"Id", "Email", "Qty", "Status", "Due", "Ship"},
+			{"2", "c@x.com", "1", "open", "2021-05-01", "2021-05-01"},
+		})
+	min, max := 1.0, 100.0
+	rules := &ExcelRules{
+		Columns: []ExcelColumnRule{
+			{Column: "Id", Required: true, Unique: true},
+			{Column: "Email", Required: true, Pattern: `^[^@]+@[^@]+$`},
+			{Column: "Qty", Min: &min, Max: &max},
+			{Column: "Status", OneOf: []string{"open", "closed"}},
+			{Column: "Due", NotBefore: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		Rows: []ExcelRowRule{{Column: "Ship", Check: func(e *Excel) error {
+			due, _ := e.Time("Due")
+			ship, _ := e.Time("Ship")
+			if ship.Before(due) {
+				return errors.New("must not be before Due")
+			}
+			return nil
+		}}},
+	}
+
+	// every problem with its coordinates
+	var report bytes.Buffer
+	errs, err := ValidateExcel(bytes.NewReader(data), rules, &ExcelOptions{AllSheets: true}, &report)
+	if nil != err {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, fmt.Sprintf("%s!%d %s: %s", e.Sheet, e.Row, e.Column, e.Err.Error()))
+	}
+	want := []string{
+		"East!3 Email: must match ^[^@]+@[^@]+$",
+		"East!3 Qty: must be at least 1",
+		"East!3 Status: must be one of: open, closed",
+		"East!3 Due: must not be before 2020-01-01",
+		"East!4 Email: " + ErrRequiredValue.Error(),
+		"East!4 Qty: must be at most 100",
+		"East!4 Ship: must not be before Due",
+		"West!2 Id: duplicate of sheet 'East' row 3",
+	}
+	if !AreStringSliceSame(got, want) {
+		t.Fatalf("got\n%s", strings.Join(got, "\n"))
+	}
+
+	// report has the bad cells filled and commented
+	file, err := xlsx.OpenBinary(report.Bytes())
+	if nil != err {
+		t.Fatal(err)
+	}
+	if ExcelErrorFill != file.Sheet["East"].Cell(2, 1).GetStyle().Fill.FgColor || ExcelErrorFill == file.Sheet["East"].Cell(1, 1).GetStyle().Fill.FgColor {
+		t.Error("report cells not highlighted")
+	}
+	z, err := zip.NewReader(bytes.NewReader(report.Bytes()), int64(report.Len()))
+	if nil != err {
+		t.Fatal(err)
+	}
+	comments := ""
+	for _, f := range z.File {
+		if strings.HasPrefix(f.Name, "xl/comments") {
+			r, _ := f.Open()
+			text, _ := io.ReadAll(r)
+			comments += string(text)
+		}
+	}
+	if !strings.Contains(comments, `<comment ref="C3" authorId="0"><text><r><t xml:space="preserve">must be at least 1</t>`) ||
+		!strings.Contains(comments, "duplicate of sheet &#39;East&#39; row 3") {
+		t.Error("report comments missing", comments)
+	}
+
+	// writing a report leaves the workbook being read alone
+	excel, err := OpenExcel(bytes.NewReader(data), []string{"Email"})
+	if nil != err || excel.IsDone() {
+		t.Fatal(err)
+	}
+	before := *excel.sheet.Cell(1, 1).GetStyle()
+	err = excel.WriteReport(io.Discard, ExcelErrors{excel.cellError("Email", excel.cell("Email"), ErrRequiredValue)})
+	if nil != err || !reflect.DeepEqual(before, *excel.sheet.Cell(1, 1).GetStyle()) {
+		t.Error("report changed the workbook", err)
+	}
+}
+
+// testUTF16 - encodes text as utf16 with an optional byte order mark
+func testUTF16(text string, little, bom bool) []byte {
+	var b []byte
+	if bom && little {
+		b = append(b, 0xFF, 0xFE)
+	} else if bom {
+		b = append(b, 0xFE, 0xFF)
+	}
+	for _, u := range utf16.Encode([]rune(text)) {
+		if little {
+			b = append(b, byte(u), byte(u>>8))
+		} else {
+			b = append(b, byte(u>>8), byte(u))
+		}
+	}
+	return b
+}
+
+// TestTabular - tests format and delimiter sniffing, text encodings and ods
+func TestTabular(t *testing.T) {
+
+	// reads Name and Qty of every row
+	read := func(name string, data []byte, options *TabularOptions) []string {
+		tab, err := OpenTabular(bytes.NewReader(data), []string{"Name", "Qty"}, options)
+		if nil != err {
+			t.Fatal(name, err)
+		}
+		var got []string
+		for !tab.IsDone() {
+			got = append(got, fmt.Sprintf("%d:%s=%d", tab.Row(), tab.String("Name"), tab.Int("Qty")))
+		}
+		if nil != tab.Err() {
+			t.Fatal(name, tab.Err())
+		}
+		return got
+	}
+
+	// delimiters are sniffed outside of quotes
+	if got := read("csv", []byte("Name,Qty\n\"a;b|c\",1\nc,2\n"), nil); !AreStringSliceSame(got, []string{"2:a;b|c=1", "3:c=2"}) {
+		t.Fatal(got)
+	}
+	if got := read("semicolon", []byte("Name;Qty\n\"x,y\";1\nz;2\n"), nil); !AreStringSliceSame(got, []string{"2:x,y=1", "3:z=2"}) {
+		t.Fatal(got)
+	}
+	if got := read("tsv", []byte("Name\tQty\nx\t3\n"), nil); !AreStringSliceSame(got, []string{"2:x=3"}) {
+		t.Fatal(got)
+	}
+	if got := read("pipe", []byte("Name|Qty\nx|4\n"), &TabularOptions{Delimiter: '|'}); !AreStringSliceSame(got, []string{"2:x=4"}) {
+		t.Fatal(got)
+	}
+
+	// utf16 with and without a mark; a surrogate pair split by the read buffer
+	// is still one rune and a lone low surrogate doesn't eat the next unit
+	long := strings.Repeat("a", 511-len("Name,Qty\n")) + "\U0001F600"
+	for _, bom := range []bool{true, false} {
+		for _, little := range []bool{true, false} {
+			data := testUTF16("Name,Qty\nñ,4\n"+long+",5\n", little, bom)
+			if got := read("utf16", data, nil); !AreStringSliceSame(got, []string{"2:ñ=4", "3:" + long + "=5"}) {
+				t.Fatal(bom, little, got)
+			}
+		}
+	}
+	pad := strings.Repeat("a", 511-len("Name,Qty\n"))
+	data := testUTF16("Name,Qty\n"+pad, true, true)
+	data = append(data, 0x00, 0xdc)
+	data = append(data, testUTF16("\U0001F600,6\n", true, false)...)
+	if got := read("low surrogate", data, nil); !AreStringSliceSame(got, []string{"2:" + pad + "\uFFFD\U0001F600=6"}) {
+		t.Fatal(got)
+	}
+
+	// windows-1252 and utf8 with a mark
+	if got := read("cp1252", []byte("Name,Qty\n\x80uro \xe9,5\n"), nil); !AreStringSliceSame(got, []string{"2:€uro é=5"}) {
+		t.Fatal(got)
+	}
+	if got := read("bom", []byte("\xEF\xBB\xBFName,Qty\nx,7\n"), nil); !AreStringSliceSame(got, []string{"2:x=7"}) {
+		t.Fatal(got)
+	}
+
+	// ods rows are numbered as in the sheet across empty and repeated rows
+	cell := func(value string) string {
+		return `<table:table-cell><text:p>` + value + `</text:p></table:table-cell>`
+	}
+	ods := testZip(t, map[string]string{
+		"mimetype": "application/vnd.oasis.opendocument.spreadsheet",
+		"content.xml": `<?xml version="1.0"?><office:document-content xmlns:office="o" xmlns:table="t" xmlns:text="x"><office:body><office:spreadsheet>` +
+			`<table:table table:name="Other"><table:table-row>` + cell("Skip") + `</table:table-row></table:table>` +
+			`<table:table table:name="S1"><table:table-row>` + cell("Name") + cell("Qty") + `</table:table-row>` +
+			`<table:table-row table:number-rows-repeated="3"><table:table-cell table:number-columns-repeated="2"/></table:table-row>` +
+			`<table:table-row table:number-rows-repeated="2"><table:table-cell><text:p>a<text:s text:c="2"/>b</text:p><office:annotation><text:p>note</text:p></office:annotation></table:table-cell>` +
+			`<table:table-cell office:value-type="float" office:value="7"><text:p>7.00</text:p></table:table-cell><table:table-cell table:number-columns-repeated="1000"/></table:table-row>` +
+			`<table:table-row><table:table-cell/></table:table-row><table:table-row>` + cell("c") + cell("8") + `</table:table-row>` +
+			`<table:table-row table:number-rows-repeated="100000"><table:table-cell table:number-columns-repeated="1000"/></table:table-row></table:table>` +
+			`</office:spreadsheet></office:body></office:document-content>`,
+	})
+	if got := read("ods", ods, &TabularOptions{ExcelOptions: ExcelOptions{Sheet: "s1"}}); !AreStringSliceSame(got, []string{"5:a  b=7", "6:a  b=7", "8:c=8"}) {
+		t.Fatal(got)
+	}
+
+	// formats are detected from content
+	xlsxData := testWorkbook(t, []string{"Sheet1"}, [][]string{{"Name", "Qty"}, {"x", "9"}})
+	for want, data := range map[string][]byte{TabularXLSX: xlsxData, TabularODS: ods, TabularCSV: []byte("a,b\n1,2\n"), TabularTSV: []byte("a\tb\n1\t2\n"), "": testZip(t, map[string]string{"other.txt": "x"})} {
+		if got := DetectTabularFormat(data); want != got {
+			t.Fatal(want, got)
+		}
+	}
+	if got := read("xlsx", xlsxData, nil); !AreStringSliceSame(got, []string{"2:x=9"}) {
+		t.Fatal(got)
+	}
+
+	// errors come back as a nil interface
+	tab, err := OpenTabular(bytes.NewReader(ods), []string{"Missing"}, nil)
+	if nil == err || nil != tab {
+		t.Fatal(err, tab)
+	}
+	tab, err = OpenTabular(bytes.NewReader(xlsxData), []string{"Missing"}, nil)
+	if nil == err || nil != tab {
+		t.Fatal(err, tab)
+	}
+	tab, err = OpenTabular(strings.NewReader("Name\n"), []string{"Missing"}, nil)
+	if nil == err || nil != tab {
+		t.Fatal(err, tab)
+	}
+
+	// the row that came closest to a header is the one reported
+	_, err = OpenCSV(strings.NewReader("Report\nName,Qty\nx,1\n"), ',', []string{"Name", "Qty", "Price"}, &ExcelOptions{HeaderRows: 3})
+	if nil == err || !strings.HasSuffix(err.Error(), "column headers: Price") {
+		t.Fatal(err)
+	}
+}
+
+// TestExcelFormulas - tests computing formulas and typing their results
+func TestExcelFormulas(t *testing.T) {
+
+	// a sheet of orders, a price list and rates sorted for approximate lookups
+	file := xlsx.NewFile()
+	add := func(name string, rows ...[]interface{}) *xlsx.Sheet {
+		sheet, err := file.AddSheet(name)
+		if nil != err {
+			t.Fatal(err)
+		}
+		for _, values := range rows {
+			row := sheet.AddRow()
+			for _, value := range values {
+				row.AddCell().SetValue(value)
+			}
+		}
+		return sheet
+	}
+	data := add("Data", []interface{}{"Item", "Qty", "Total", "Label", "When", "Big", "Loop"}, []interface{}{"apple", 3}, []interface{}{"pear", 4})
+	add("Price List", []interface{}{"apple", 1.5}, []interface{}{"pear", 2})
+	add("Rates", []interface{}{0, "low"}, []interface{}{10, "mid"}, []interface{}{100, "high"})
+	data.Cell(1, 2).SetFormula("B2*VLOOKUP(A2,'Price List'!A:B,2,FALSE)")
+	data.Cell(2, 2).SetFormula("B3*VLOOKUP(A3,'Price List'!$A$1:$B$2,2,0)")
+	data.Cell(1, 3).SetStringFormula(`UPPER(A2)&" x"&B2`)
+	data.Cell(1, 4).SetStringFormula("EDATE(DATE(2021,1,31),1)")
+	data.Cell(1, 5).SetStringFormula("C2>5")
+	data.Cell(1, 6).SetFormula("G3+1")
+	data.Cell(2, 6).SetFormula("G2+1")
+	data.Cell(2, 7).SetFormula("Loop*2")
+
+	// names that refer to themselves or to each other
+	parts, err := file.MarshallParts()
+	if nil != err {
+		t.Fatal(err)
+	}
+	parts["xl/workbook.xml"] = strings.Replace(parts["xl/workbook.xml"], "</workbook>",
+		`<definedNames><definedName name="Loop">Loop+1</definedName><definedName name="Ping">Pong+1</definedName><definedName name="Pong">Ping*2</definedName></definedNames></workbook>`, 1)
+	buf := bytes.NewBuffer(testZip(t, parts))
+
+	// formulas as seen from the data sheet
+	e, err := OpenExcel(bytes.NewReader(buf.Bytes()), []string{"Item"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		formula string
+		want    string
+	}{
+		{"1+2*3", "7"},
+		{"(1+2)*3", "9"},
+		{"-2^2", "4"},
+		{"2^3^2", "64"},
+		{"2*-3", "-6"},
+		{"50%", "0.5"},
+		{"10+50%*2", "11"},
+		{"1&2+3", "15"},
+		{"1+2=3", "TRUE"},
+		{`"a"<>"A"`, "FALSE"},
+		{"SUM(B2:B3)", "7"},
+		{"SUM(B:B,1)", "8"},
+		{"AVERAGE(B2:B3)", "3.5"},
+		{"AVERAGE(A2:A3)", "#DIV/0!"},
+		{"SUM(C2:C3)", "12.5"},
+		{`IF(B2>3,"big","small")`, "small"},
+		{`IF(B3>3,"big")`, "big"},
+		{`IF(B2>3,"big")`, "FALSE"},
+		{"1/0", "#DIV/0!"},
+		{`IFERROR(1/0,"div")`, "div"},
+		{`IFERROR(B2,"div")`, "3"},
+		{`VLOOKUP("PEAR",'Price List'!A:B,2,FALSE)`, "2"},
+		{`VLOOKUP("plum",'Price List'!A:B,2,FALSE)`, "#N/A"},
+		{"VLOOKUP(50,Rates!A1:B3,2)", "mid"},
+		{"VLOOKUP(100,Rates!A1:B3,2,TRUE)", "high"},
+		{"VLOOKUP(5000,Rates!A1:B3,2,1)", "high"},
+		{"VLOOKUP(-1,Rates!A1:B3,2)", "#N/A"},
+		{`MATCH("pear",'Price List'!A1:A2,0)`, "2"},
+		{"MATCH(50,Rates!A1:A3)", "2"},
+		{"INDEX('Price List'!A1:B2,2,2)", "2"},
+		{`INDEX('Price List'!B1:B2,MATCH("apple",'Price List'!A1:A2,0))`, "1.5"},
+		{"'Price List'!B1*2", "3"},
+		{"SUM('Price List'!B1:B2)+Data!B2", "6.5"},
+		{"DATE(2021,1,31)", "44227"},
+		{"DATE(2021,13,1)", "44562"},
+		{"EDATE(DATE(2021,1,31),1)", "44255"},
+		{"EDATE(DATE(2021,3,31),-1)", "44255"},
+		{"EOMONTH(DATE(2020,2,10),0)", "43890"},
+		{"Loop", "#REF!"},
+		{"Ping+1", "#REF!"},
+		{"IFERROR(Pong,0)", "0"},
+	} {
+		got, err := e.evaluator().evaluate(e.sheet, test.formula)
+		if nil != err || test.want != got {
+			t.Fatal(test.formula, got, err)
+		}
+	}
+
+	// bad formulas, unknown functions and circular references are errors
+	for _, formula := range []string{"1+", "FOO(1)", "SUM(1"} {
+		if _, err := e.evaluator().evaluate(e.sheet, formula); nil == err {
+			t.Fatal(formula)
+		}
+	}
+	if _, err = e.EvaluateCell("G2"); nil == err || !strings.Contains(err.Error(), "circular reference") {
+		t.Fatal(err)
+	}
+	if got, err := e.EvaluateCell("'Price List'!B2"); nil != err || "2" != got {
+		t.Fatal(got, err)
+	}
+	if got, err := e.EvaluateCell("H3"); nil != err || "#REF!" != got {
+		t.Fatal(got, err)
+	}
+	if _, err = e.NamedRange("Loop"); nil == err {
+		t.Fatal("a loop is not a range")
+	}
+
+	// computed cells read like literal ones
+	e, err = OpenExcelWithOptions(bytes.NewReader(buf.Bytes()), []string{"Item", "Total", "Label", "When", "Big", "Loop"}, &ExcelOptions{Evaluate: true})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if e.IsDone() {
+		t.Fatal("no rows")
+	}
+	total, err := e.Float64("Total")
+	if nil != err || 4.5 != total {
+		t.Fatal(total, err)
+	}
+	when, err := e.Time("When")
+	if nil != err || !when.Equal(time.Date(2021, 2, 28, 0, 0, 0, 0, time.UTC)) {
+		t.Fatal(when, err)
+	}
+	big, err := e.Bool("Big")
+	if nil != err || big {
+		t.Fatal(big, err)
+	}
+	if "APPLE x3" != e.String("Label") || !e.IsEmpty("Loop") {
+		t.Fatal(e.String("Label"), e.String("Loop"))
+	}
+	if e.IsDone() {
+		t.Fatal("one row")
+	}
+	qty, err := e.Int64("Total")
+	if nil != err || 8 != qty {
+		t.Fatal(qty, err)
+	}
+}
+
+// TestExcelRanges - tests cell and range references, defined names and tables
+func TestExcelRanges(t *testing.T) {
+
+	// two sheets of cells named by where they are; the second has a quote in its name
+	file := xlsx.NewFile()
+	for _, name := range []string{"Main", "O'Brien"} {
+		sheet, err := file.AddSheet(name)
+		if nil != err {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			row := sheet.AddRow()
+			for j := 0; j < 3; j++ {
+				row.AddCell().SetString(name[:1] + xlsx.GetCellIDStringFromCoords(j, i))
+			}
+		}
+	}
+
+	// a defined name and a table with a totals row on the second sheet
+	parts, err := file.MarshallParts()
+	if nil != err {
+		t.Fatal(err)
+	}
+	parts["xl/workbook.xml"] = strings.Replace(parts["xl/workbook.xml"], "</workbook>",
+		`<definedNames><definedName name="Block">'O''Brien'!$B$2:$C$3</definedName><definedName name="Cols">Main!$A:$A</definedName></definedNames></workbook>`, 1)
+	parts["xl/tables/table1.xml"] = `<table xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" id="1" name="Table1" displayName="Orders" ref="A1:C4" totalsRowCount="1">` +
+		`<tableColumns count="3"><tableColumn id="1" name="A"/><tableColumn id="2" name="B"/><tableColumn id="3" name="C"/></tableColumns></table>`
+	parts["xl/worksheets/_rels/sheet2.xml.rels"] = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/table" Target="../tables/table1.xml"/></Relationships>`
+	data := testZip(t, parts)
+	e, err := OpenExcel(bytes.NewReader(data), nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	// cells
+	for ref, want := range map[string]string{"C3": "MC3", "c3": "MC3", "B7": "", "'O''Brien'!A1": "OA1", "Main!$B$2": "MB2"} {
+		got, err := e.Cell(ref)
+		if nil != err || want != got {
+			t.Fatal(ref, got, err)
+		}
+	}
+	if _, err = e.Cell("Nowhere!A1"); nil == err {
+		t.Fatal("no sheet")
+	}
+
+	// ranges, whole columns and defined names
+	for ref, want := range map[string][][]string{
+		"A1:B2":         {{"MA1", "MB1"}, {"MA2", "MB2"}},
+		"b:b":           {{"MB1"}, {"MB2"}, {"MB3"}, {"MB4"}, {"MB5"}},
+		"C5:D6":         {{"MC5", ""}, {"", ""}},
+		"'O''Brien'!C1": {{"OC1"}},
+		"Block":         {{"OB2", "OC2"}, {"OB3", "OC3"}},
+	} {
+		got, err := e.Range(ref)
+		if nil != err || !reflect.DeepEqual(want, got) {
+			t.Fatal(ref, got, err)
+		}
+	}
+	if _, err = e.Range("nope"); nil == err || !strings.Contains(err.Error(), "does not refer to cells") {
+		t.Fatal(err)
+	}
+	if !AreStringSliceSame(e.Names(), []string{"Block", "Cols"}) {
+		t.Fatal(e.Names())
+	}
+	block, err := e.NamedRange("block")
+	if nil != err || !reflect.DeepEqual(block, [][]string{{"OB2", "OC2"}, {"OB3", "OC3"}}) {
+		t.Fatal(block, err)
+	}
+	cols, err := e.NamedRange("Cols")
+	if nil != err || 5 != len(cols) || "MA5" != cols[4][0] {
+		t.Fatal(cols, err)
+	}
+	if _, err = e.NamedRange("Other"); nil == err {
+		t.Fatal("no name")
+	}
+
+	// tables leave out the header and totals rows
+	table, err := e.Table("orders")
+	if nil != err {
+		t.Fatal(err)
+	}
+	want := &ExcelTable{Name: "Orders", Sheet: "O'Brien", Ref: "A1:C4", Columns: []string{"A", "B", "C"}, Rows: [][]string{{"OA2", "OB2", "OC2"}, {"OA3", "OB3", "OC3"}}}
+	if !reflect.DeepEqual(want, table) {
+		t.Fatal(table)
+	}
+	tables, err := e.Tables()
+	if nil != err || 1 != len(tables) {
+		t.Fatal(tables, err)
+	}
+	if _, err = e.Table("Missing"); nil == err {
+		t.Fatal("no table")
+	}
+}
+
+// TestExcelDates - tests serial dates in both date systems and text dates
+func TestExcelDates(t *testing.T) {
+
+	// the 1900 system counts a 29 Feb 1900 that never was; 60 reads as 1 Mar
+	day := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+	for _, test := range []struct {
+		serial   float64
+		date1904 bool
+		want     time.Time
+		back     float64
+	}{
+		{1, false, day(1900, 1, 1), 1},
+		{59, false, day(1900, 2, 28), 59},
+		{60, false, day(1900, 3, 1), 61},
+		{61, false, day(1900, 3, 1), 61},
+		{44289.75, false, day(2021, 4, 3).Add(18 * time.Hour), 44289.75},
+		{0.5, true, day(1904, 1, 1).Add(12 * time.Hour), 0.5},
+		{42827, true, day(2021, 4, 3), 42827},
+	} {
+		got := excelSerialTime(test.serial, test.date1904)
+		if !got.Equal(test.want) || test.back != excelTimeSerial(got, test.date1904) {
+			t.Fatal(test.serial, got, excelTimeSerial(got, test.date1904))
+		}
+	}
+
+	// a 1904 workbook with serial and text dates
+	file := xlsx.NewFile()
+	sheet, err := file.AddSheet("S")
+	if nil != err {
+		t.Fatal(err)
+	}
+	sheet.AddRow().AddCell().SetString("When")
+	sheet.AddRow().AddCell().SetFloat(42827.25)
+	sheet.AddRow().AddCell().SetString("03/04/2021")
+	sheet.AddRow().AddCell().SetString("2021-04-03T10:00:00+02:00")
+	sheet.AddRow().AddCell().SetString("3-Apr-2021")
+	parts, err := file.MarshallParts()
+	if nil != err {
+		t.Fatal(err)
+	}
+	parts["xl/workbook.xml"] = strings.Replace(parts["xl/workbook.xml"], `date1904="false"`, `date1904="1"`, 1)
+	data := testZip(t, parts)
+
+	// month first in utc, then day first in a zone; text with an offset keeps it
+	zone := time.FixedZone("EST", -5*60*60)
+	for _, test := range []struct {
+		options *ExcelOptions
+		want    []string
+	}{
+		{nil, []string{"2021-04-03T06:00:00Z", "2021-03-04T00:00:00Z", "2021-04-03T10:00:00+02:00", "2021-04-03T00:00:00Z"}},
+		{&ExcelOptions{Dates: ExcelDates{DayFirst: true, Location: zone}}, []string{"2021-04-03T06:00:00-05:00", "2021-04-03T00:00:00-05:00", "2021-04-03T10:00:00+02:00", "2021-04-03T00:00:00-05:00"}},
+	} {
+		e, err := OpenExcelWithOptions(bytes.NewReader(data), []string{"When"}, test.options)
+		if nil != err {
+			t.Fatal(err)
+		}
+		var got []string
+		for !e.IsDone() {
+			d, err := e.Date("When")
+			if nil != err {
+				t.Fatal(err)
+			}
+			got = append(got, d.Format(time.RFC3339))
+		}
+		if !AreStringSliceSame(test.want, got) {
+			t.Fatal(got)
+		}
+	}
+
+	// DateOnly drops the time of day but keeps the zone
+	e, err := OpenExcelWithOptions(bytes.NewReader(data), []string{"When"}, &ExcelOptions{Dates: ExcelDates{Location: zone}})
+	if nil != err || e.IsDone() {
+		t.Fatal(err)
+	}
+	d, err := e.DateOnly("When")
+	if nil != err || !d.Equal(time.Date(2021, 4, 3, 0, 0, 0, 0, zone)) {
+		t.Fatal(d, err)
+	}
+
+	// text that is no date
+	dates := ExcelDates{Layouts: []string{"20060102"}}
+	if d, err := dates.parse("20210403"); nil != err || !d.Equal(day(2021, 4, 3)) {
+		t.Fatal(d, err)
+	}
+	if _, err := dates.parse("soon"); nil == err {
+		t.Fatal("not a date")
+	}
+}
+
+// TestDiffTabular - tests diffing and merging tables by key columns
+func TestDiffTabular(t *testing.T) {
+
+	// last month and this month; columns move, are spelled differently and come and go
+	old := "Vendor,Item,Unit Price,Note\nacme,bolt,1.00,x\nacme,nut,0.5,\nbeta,gear,9,\n"
+	new := "Item;vendor;unit_price;Extra\nbolt;ACME;1.10;e\ngear;beta;9;\nwheel;beta;3;\n"
+	options := &TabularDiffOptions{Keys: []string{"Vendor", "Item"}, IgnoreCase: true}
+	diff, err := DiffSpreadsheets(strings.NewReader(old), strings.NewReader(new), options)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if !AreStringSliceSame(diff.Columns, []string{"vendor", "Item", "unit_price", "Note", "Extra"}) || 1 != diff.Unchanged {
+		t.Fatal(diff.Columns, diff.Unchanged)
+	}
+	if 1 != diff.Count(DiffAdded) || 1 != diff.Count(DiffRemoved) || 1 != diff.Count(DiffChanged) {
+		t.Fatal(diff.Rows)
+	}
+	want := []*TabularRowDiff{
+		{Change: DiffChanged, Key: []string{"ACME", "bolt"}, OldRow: 2, NewRow: 2, Values: map[string]string{"Item": "bolt", "vendor": "ACME", "unit_price": "1.10", "Extra": "e"},
+			Cells: []TabularCellDiff{{"unit_price", "1.00", "1.10"}, {"Note", "x", ""}, {"Extra", "", "e"}}},
+		{Change: DiffAdded, Key: []string{"beta", "wheel"}, NewRow: 4, Values: map[string]string{"Item": "wheel", "vendor": "beta", "unit_price": "3", "Extra": ""}},
+		{Change: DiffRemoved, Key: []string{"acme", "nut"}, OldRow: 3, Values: map[string]string{"vendor": "acme", "Item": "nut", "unit_price": "0.5", "Note": ""}},
+	}
+	if !reflect.DeepEqual(want, diff.Rows) {
+		data, _ := json.Marshal(diff.Rows)
+		t.Fatal(string(data))
+	}
+
+	// the report shows removed rows under the new titles and old → new in changed cells
+	var buf bytes.Buffer
+	err = diff.WriteExcel(&buf)
+	if nil != err {
+		t.Fatal(err)
+	}
+	e, err := OpenExcel(bytes.NewReader(buf.Bytes()), []string{"Change", "unit_price"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	var got []string
+	for !e.IsDone() {
+		got = append(got, e.String("Change")+":"+e.String("unit_price"))
+	}
+	if !AreStringSliceSame(got, []string{"changed:1.00 → 1.10", "added:3", "removed:0.5"}) {
+		t.Fatal(got)
+	}
+
+	// columns are named like header titles; one in neither table is an error
+	options.Columns = []string{"Unit Price"}
+	diff, err = DiffSpreadsheets(strings.NewReader(old), strings.NewReader(new), options)
+	if nil != err || !AreStringSliceSame(diff.Columns, []string{"unit_price"}) || 1 != diff.Unchanged || 1 != len(diff.Rows[0].Cells) {
+		t.Fatal(diff, err)
+	}
+
+	// the report still leads with the keys, each once
+	for _, columns := range [][]string{{"Unit Price"}, {"item", "Unit Price"}} {
+		options.Columns = columns
+		diff, err = DiffSpreadsheets(strings.NewReader(old), strings.NewReader(new), options)
+		if nil != err {
+			t.Fatal(err)
+		}
+		buf.Reset()
+		if err = diff.WriteExcel(&buf); nil != err {
+			t.Fatal(err)
+		}
+		e, err = OpenExcel(bytes.NewReader(buf.Bytes()), []string{"Change", "Vendor", "Item", "unit_price"})
+		if nil != err || !AreStringSliceSame(e.Header(), []string{"Change", "Vendor", "Item", "unit_price"}) {
+			t.Fatal(e.Header(), err)
+		}
+		got = nil
+		for !e.IsDone() {
+			got = append(got, e.String("Vendor")+"/"+e.String("Item")+":"+e.String("unit_price"))
+		}
+		if !AreStringSliceSame(got, []string{"ACME/bolt:1.00 → 1.10", "beta/wheel:3", "acme/nut:0.5"}) {
+			t.Fatal(got)
+		}
+	}
+	options.Columns = []string{"Price"}
+	_, err = DiffSpreadsheets(strings.NewReader(old), strings.NewReader(new), options)
+	if nil == err || !strings.Contains(err.Error(), "column 'Price' is in neither table") {
+		t.Fatal(err)
+	}
+	if _, err = DiffSpreadsheets(strings.NewReader(old), strings.NewReader(new), &TabularDiffOptions{}); nil == err {
+		t.Fatal("no keys")
+	}
+
+	// a merge updates compared columns, keeps old rows and adds new ones
+	options.Columns = []string{"Unit Price"}
+	merge, err := MergeSpreadsheets(strings.NewReader(old), strings.NewReader(new), options)
+	if nil != err {
+		t.Fatal(err)
+	}
+	wantMerge := &TabularMerge{
+		Columns: []string{"vendor", "Item", "unit_price", "Note", "Extra"},
+		Rows:    [][]string{{"acme", "bolt", "1.10", "x", ""}, {"acme", "nut", "0.5", "", ""}, {"beta", "gear", "9", "", ""}, {"beta", "wheel", "3", "", ""}},
+	}
+	if !reflect.DeepEqual(wantMerge, merge) {
+		t.Fatal(merge)
+	}
+	buf.Reset()
+	err = merge.WriteExcel(&buf)
+	if nil != err {
+		t.Fatal(err)
+	}
+	e, err = OpenExcel(bytes.NewReader(buf.Bytes()), merge.Columns)
+	if nil != err {
+		t.Fatal(err)
+	}
+	rows, err := e.Range("A1:E5")
+	if nil != err || !reflect.DeepEqual(append([][]string{merge.Columns}, merge.Rows...), rows) {
+		t.Fatal(rows, err)
+	}
+}
+
+// csvItem - a row of csv
+type csvItem struct {
+	Name  string     `csv:"Name,required"`
+	Qty   int        `csv:"Quantity,alias=Qty"`
+	When  *time.Time `csv:"When"`
+	Price float64
+	Skip  string `csv:"-"`
+	D     time.Duration
+}
+
+// TestCSV - tests reading and writing structs as csv
+func TestCSV(t *testing.T) {
+
+	// structs survive a round trip with text that needs quoting
+	when := time.Date(2021, 4, 3, 0, 0, 0, 0, time.UTC)
+	rows := []csvItem{
+		{Name: `a, "b"`, Qty: 3, When: &when, Price: 1.5, D: time.Hour},
+		{Name: "two\nlines", Qty: -1},
+	}
+	for _, options := range []*CSVOptions{nil, {Delimiter: ';', CRLF: true, AlwaysQuote: true, TimeLayout: "02/01/2006"}} {
+		var buf bytes.Buffer
+		err := MarshalCSV(&buf, rows, options)
+		if nil != err {
+			t.Fatal(err)
+		}
+		var back []*csvItem
+		err = UnmarshalCSV(bytes.NewReader(buf.Bytes()), &back, options)
+		if nil != err || 2 != len(back) || !reflect.DeepEqual(rows[0], *back[0]) || !reflect.DeepEqual(rows[1], *back[1]) {
+			t.Fatal(buf.String(), err)
+		}
+	}
+
+	// header, quoting and line ends when writing
+	var buf bytes.Buffer
+	err := MarshalCSV(&buf, rows[:1], &CSVOptions{TimeLayout: "02/01/2006", CRLF: true})
+	if nil != err || "Name,Quantity,When,Price,D\r\n\"a, \"\"b\"\"\",3,03/04/2021,1.5,1h0m0s\r\n" != buf.String() {
+		t.Fatalf("%q %v", buf.String(), err)
+	}
+	buf.Reset()
+	err = MarshalCSV(&buf, []csvItem{}, &CSVOptions{AlwaysQuote: true, Delimiter: '\t'})
+	if nil != err || "\"Name\"\t\"Quantity\"\t\"When\"\t\"Price\"\t\"D\"\n" != buf.String() {
+		t.Fatalf("%q %v", buf.String(), err)
+	}
+	if err = MarshalCSV(&buf, csvItem{}, nil); nil == err {
+		t.Fatal("not a slice")
+	}
+
+	// headers match like excel ones; bad rows are kept and every error returned
+	in := "\uFEFFname;qty;When;Price;D\n\"a;b\";3;2021-04-03;1.5;1h\n;x;;;\n# note\nc;4;;2;\n"
+	var got []csvItem
+	err = UnmarshalCSV(strings.NewReader(in), &got, &CSVOptions{Delimiter: ';', Comment: '#'})
+	errs, ok := err.(CSVErrors)
+	if !ok || 2 != len(errs) || 3 != len(got) || "c" != got[2].Name || !got[0].When.Equal(when) {
+		t.Fatal(got, err)
+	}
+	if 3 != errs[0].Line || "Name" != errs[0].Column || !errors.Is(errs[0], ErrRequiredValue) || "Quantity" != errs[1].Column || "x" != errs[1].Value {
+		t.Fatal(errs)
+	}
+
+	// rows without a header take fields in order
+	got = nil
+	err = UnmarshalCSV(strings.NewReader("a,1\nb,2\n"), &got, &CSVOptions{NoHeader: true})
+	if nil != err || 2 != len(got) || "b" != got[1].Name || 2 != got[1].Qty {
+		t.Fatal(got, err)
+	}
+
+	// scanning stops at the first error from a row or from fn
+	var row csvItem
+	total := 0
+	err = ScanCSV(strings.NewReader("Name,Qty\na,1\nb,2\n"), &row, nil, func() error {
+		total += row.Qty
+		return nil
+	})
+	if nil != err || 3 != total {
+		t.Fatal(total, err)
+	}
+	stop := errors.New("stop")
+	err = ScanCSV(strings.NewReader("Name,Qty\na,1\nb,2\n"), &row, nil, func() error {
+		return stop
+	})
+	if stop != err || "a" != row.Name {
+		t.Fatal(row, err)
+	}
+	if err = ScanCSV(strings.NewReader("Name,Qty\na,x\nb,2\n"), &row, nil, func() error { return nil }); nil == err {
+		t.Fatal("bad row")
+	}
+
+	// streaming sends each row then closes the channel
+	ch := make(chan *csvItem)
+	done := make(chan error)
+	go func() {
+		done <- StreamCSV(strings.NewReader("Name,Qty\na,1\nb,2\n"), ch, nil)
+	}()
+	var names []string
+	for r := range ch {
+		names = append(names, r.Name)
+	}
+	if err = <-done; nil != err || !AreStringSliceSame(names, []string{"a", "b"}) {
+		t.Fatal(names, err)
+	}
+}
+
+// gob envelope schema versions of a person
+type gobPersonV0 struct{ Name string }
+type gobPersonV1 struct{ First, Last string }
+type gobPerson struct {
+	First, Last string
+	Age         int
+}
+
+// Reader - has the name of a type in another package
+type Reader struct{ X int }
+
+// TestGobEnvelope - tests envelope round trips, migration chains and decoding any type
+func TestGobEnvelope(t *testing.T) {
+
+	// three versions; the migrations give a pointer and then a value
+	err := RegisterGobType(&gobPerson{}, 2)
+	if nil != err {
+		t.Fatal(err)
+	}
+	v0, err := GobEncode(&gobPersonV0{Name: "Ada"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	var p gobPerson
+	if err = GobDecodeEnvelope(v0, &p); !errors.Is(err, ErrGobNoMigration) {
+		t.Fatal(err)
+	}
+	err = RegisterGobMigration(gobPerson{}, 0, gobPersonV0{}, func(old interface{}) (interface{}, error) {
+		return &gobPersonV1{First: old.(*gobPersonV0).Name, Last: "?"}, nil
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err = GobDecodeEnvelope(v0, &p); !errors.Is(err, ErrGobNoMigration) {
+		t.Fatal(err)
+	}
+	err = RegisterGobMigration(&gobPerson{}, 1, gobPersonV1{}, func(old interface{}) (interface{}, error) {
+		v := old.(*gobPersonV1)
+		return gobPerson{First: v.First, Last: v.Last, Age: -1}, nil
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	// bare gob bytes are version 0 and go through the chain
+	p = gobPerson{}
+	if err = GobDecodeEnvelope(v0, &p); nil != err || !reflect.DeepEqual(gobPerson{"Ada", "?", -1}, p) {
+		t.Fatal(p, err)
+	}
+
+	// the current version round trips as is, through either decoder
+	buf, err := GobEncodeEnvelope(&gobPerson{"Grace", "Hopper", 85})
+	if nil != err {
+		t.Fatal(err)
+	}
+	envelope, err := OpenGobEnvelope(buf)
+	if nil != err || GobTypeName(gobPerson{}) != envelope.Type || 2 != envelope.Version || "github.com/stevenb256/utility.gobPerson" != envelope.Type {
+		t.Fatal(envelope, err)
+	}
+	p = gobPerson{}
+	if err = GobDecodeEnvelope(buf, &p); nil != err || !reflect.DeepEqual(gobPerson{"Grace", "Hopper", 85}, p) {
+		t.Fatal(p, err)
+	}
+	any, err := GobDecodeAny(buf)
+	if nil != err || !reflect.DeepEqual(&gobPerson{"Grace", "Hopper", 85}, any) {
+		t.Fatal(any, err)
+	}
+
+	// an older envelope decodes to the current type through GobDecodeAny too
+	old, err := GobEncode(&gobPersonV1{"Alan", "Turing"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	old, err = GobEncode(&GobEnvelope{Type: envelope.Type, Version: 1, Data: old})
+	if nil != err {
+		t.Fatal(err)
+	}
+	any, err = GobDecodeAny(append([]byte("\x00utlgob"), old...))
+	if nil != err || !reflect.DeepEqual(&gobPerson{"Alan", "Turing", -1}, any) {
+		t.Fatal(any, err)
+	}
+
+	// wrong types, newer versions and unknown types are errors
+	var v1 gobPersonV1
+	if err = GobDecodeEnvelope(buf, &v1); !errors.Is(err, ErrGobWrongType) {
+		t.Fatal(err)
+	}
+	newer, err := GobEncode(&GobEnvelope{Type: envelope.Type, Version: 3, Data: v0})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, err = GobDecodeAny(append([]byte("\x00utlgob"), newer...)); nil == err || !strings.Contains(err.Error(), "is newer than 2") {
+		t.Fatal(err)
+	}
+	if _, err = GobEncodeEnvelope(&gobPersonV0{}); !errors.Is(err, ErrGobNotRegistered) {
+		t.Fatal(err)
+	}
+	if _, err = GobDecodeAny(v0); !errors.Is(err, ErrGobNotRegistered) {
+		t.Fatal(err)
+	}
+
+	// types of the same name in different packages don't collide
+	if err = RegisterGobType(&Reader{}, 1); nil != err {
+		t.Fatal(err)
+	}
+	if err = RegisterGobType(&strings.Reader{}, 1); nil != err {
+		t.Fatal(err)
+	}
+	if GobTypeName(Reader{}) == GobTypeName(strings.Reader{}) {
+		t.Fatal(GobTypeName(Reader{}))
+	}
+
+	// a type registered by a name of its own keeps it wherever it lives
+	if err = RegisterGobTypeName(&gobRenamed{}, "people.Person", 1); nil != err {
+		t.Fatal(err)
+	}
+	buf, err = GobEncodeEnvelope(&gobRenamed{"Ada"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if envelope, err = OpenGobEnvelope(buf); nil != err || "people.Person" != envelope.Type || "people.Person" != GobTypeName(&gobRenamed{}) {
+		t.Fatal(envelope, err)
+	}
+	var renamed gobRenamed
+	if err = GobDecodeEnvelope(buf, &renamed); nil != err || "Ada" != renamed.Name {
+		t.Fatal(renamed, err)
+	}
+	if err = RegisterGobTypeName(&gobPersonV0{}, "people.Person", 1); nil == err {
+		t.Fatal("name in use")
+	}
+	if err = RegisterGobTypeName(&gobRenamed{}, "people.Other", 1); nil == err {
+		t.Fatal("type has a name")
+	}
+
+	// decoding while migrations are registered
+	done := make(chan bool)
+	go func() {
+		for i := 10; i < 200; i++ {
+			RegisterGobMigration(&gobPerson{}, i, gobPersonV1{}, nil)
+		}
+		close(done)
+	}()
+	for i := 0; i < 200; i++ {
+		if err = GobDecodeEnvelope(v0, &p); nil != err {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
+
+// gobRenamed - a type registered by a name of its own
+type gobRenamed struct{ Name string }
+
+// records of two types in a gob file
+type gobFileA struct {
+	N int
+	S string
+}
+type gobFileB struct{ F float64 }
+
+// TestGobFile - tests reading gob file records by number with and without an index
+func TestGobFile(t *testing.T) {
+
+	// every third record is of another type so type frames are spread out
+	path := filepath.Join(t.TempDir(), "records.gob")
+	write := func(n int) *GobFileWriter {
+		w, err := CreateGobFile(path)
+		if nil != err {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			if 0 == i%3 {
+				err = w.Encode(&gobFileB{float64(i)})
+			} else {
+				err = w.Encode(&gobFileA{N: i, S: Itoa(i)})
+			}
+			if nil != err {
+				t.Fatal(err)
+			}
+		}
+		if n != w.Len() {
+			t.Fatal(w.Len())
+		}
+		return w
+	}
+
+	// reads records out of order and checks them
+	check := func(n int, order []int) {
+		g, err := OpenGobFile(path)
+		if nil != err {
+			t.Fatal(err)
+		}
+		defer g.Close()
+		if n != g.Len() {
+			t.Fatal(n, g.Len())
+		}
+		for _, i := range order {
+			if 0 == i%3 {
+				var b gobFileB
+				if err = g.Read(i, &b); nil != err || float64(i) != b.F {
+					t.Fatal(i, b, err)
+				}
+			} else {
+				var a gobFileA
+				if err = g.Read(i, &a); nil != err || i != a.N || Itoa(i) != a.S {
+					t.Fatal(i, a, err)
+				}
+			}
+		}
+		var a gobFileA
+		if err = g.Read(n, &a); nil == err {
+			t.Fatal("out of range")
+		}
+	}
+
+	// a closed file is read through its index, even by a later reopen
+	err := write(10).Close()
+	if nil != err {
+		t.Fatal(err)
+	}
+	for pass := 0; pass < 2; pass++ {
+		check(10, []int{8, 0, 5, 9, 1, 1, 3})
+	}
+	file, err := os.Open(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	info, _ := file.Stat()
+	g := &GobFile{file: file, size: info.Size()}
+	if err = g.readIndex(); nil != err || 10 != len(g.records) {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	// the file also reads as a plain stream
+	file, err = os.Open(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	d := NewGobStreamDecoder(file)
+	var b gobFileB
+	var a gobFileA
+	if err = d.Decode(&b); nil != err || 0 != b.F {
+		t.Fatal(b, err)
+	}
+	if err = d.Decode(&a); nil != err || 1 != a.N {
+		t.Fatal(a, err)
+	}
+	file.Close()
+
+	// a file that was never closed is scanned for its records
+	w := write(7)
+	err = w.w.Flush()
+	if nil != err {
+		t.Fatal(err)
+	}
+	check(7, []int{6, 2, 0, 4})
+	w.file.Close()
+
+	// a record cut short by a crash is dropped; so is a damaged index
+	data, err := ioutil.ReadFile(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path, data[:len(data)-3], 0644)
+	if nil != err {
+		t.Fatal(err)
+	}
+	check(6, []int{5, 0, 3})
+	err = write(5).Close()
+	if nil != err {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadFile(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path, data[:len(data)-4], 0644)
+	if nil != err {
+		t.Fatal(err)
+	}
+	check(5, []int{4, 0, 2})
+
+	// sizes past the end of the file are errors, not huge allocations
+	huge := make([]byte, binary.MaxVarintLen64)
+	huge = huge[:binary.PutUvarint(huge, 1<<62)]
+	bad := append(append([]byte{gobFrameRecord}, huge...), "xx"...)
+	bad = append(append(bad, 0, 0, 0, 0, 0, 0, 0, 0), gobIndexMagic...)
+	if err = ioutil.WriteFile(path, bad, 0644); nil != err {
+		t.Fatal(err)
+	}
+	g, err = OpenGobFile(path)
+	if nil != err || 0 != g.Len() {
+		t.Fatal(err)
+	}
+	g.Close()
+	if err = write(4).Close(); nil != err {
+		t.Fatal(err)
+	}
+	g, err = OpenGobFile(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadFile(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	copy(data[g.records[1].offset+1:], huge)
+	g.Close()
+	if err = ioutil.WriteFile(path, data, 0644); nil != err {
+		t.Fatal(err)
+	}
+	g, err = OpenGobFile(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err = g.Read(1, &a); nil == err || !strings.Contains(err.Error(), "past the end") {
+		t.Fatal(err)
+	}
+	g.Close()
+
+	// garbage is an error
+	err = ioutil.WriteFile(path, []byte("not a gob file"), 0644)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, err = OpenGobFile(path); nil == err {
+		t.Fatal("garbage")
+	}
+}
+
+// codecObject - an object for codecs
+type codecObject struct {
+	S string
+	I int
+	L []float64
+	M map[string]int
+}
+
+// TestCodecs - tests each codec and saving and loading objects by file extension
+func TestCodecs(t *testing.T) {
+
+	// each codec round trips objects alone and in streams
+	in := codecObject{"a", 3, []float64{1.5, 2}, map[string]int{"x": 1}}
+	dir := t.TempDir()
+	for _, name := range []string{"gob", "json", "msgpack", "cbor"} {
+		codec, err := CodecByName(strings.ToUpper(name))
+		if nil != err || name != codec.Name() {
+			t.Fatal(name, err)
+		}
+		data, err := codec.Marshal(&in)
+		if nil != err {
+			t.Fatal(name, err)
+		}
+		var out codecObject
+		if err = codec.Unmarshal(data, &out); nil != err || !reflect.DeepEqual(in, out) {
+			t.Fatal(name, out, err)
+		}
+		var buf bytes.Buffer
+		encoder := codec.NewEncoder(&buf)
+		for _, s := range []string{"b", "c"} {
+			if err = encoder.Encode(&codecObject{S: s}); nil != err {
+				t.Fatal(name, err)
+			}
+		}
+		decoder := codec.NewDecoder(&buf)
+		var b, c codecObject
+		if err = decoder.Decode(&b); nil != err || "b" != b.S {
+			t.Fatal(name, b, err)
+		}
+		if err = decoder.Decode(&c); nil != err || "c" != c.S {
+			t.Fatal(name, c, err)
+		}
+		if err = decoder.Decode(&c); io.EOF != err {
+			t.Fatal(name, err)
+		}
+
+		// files are saved with the codec of their extension
+		path := filepath.Join(dir, "object."+name)
+		if err = SaveObject(path, &in); nil != err {
+			t.Fatal(name, err)
+		}
+		data, err = ioutil.ReadFile(path)
+		if nil != err {
+			t.Fatal(err)
+		}
+		var raw codecObject
+		if err = codec.Unmarshal(data, &raw); nil != err || !reflect.DeepEqual(in, raw) {
+			t.Fatal(name, raw, err)
+		}
+		var loaded codecObject
+		if err = LoadObject(path, &loaded); nil != err || !reflect.DeepEqual(in, loaded) {
+			t.Fatal(name, loaded, err)
+		}
+	}
+
+	// lookups by content type and path
+	if codec, err := CodecByContentType("application/json; charset=utf-8"); nil != err || JSONCodec != codec {
+		t.Fatal(codec, err)
+	}
+	if codec, err := CodecByContentType("application/x-msgpack"); nil != err || MsgPackCodec != codec {
+		t.Fatal(codec, err)
+	}
+	if codec, err := CodecForPath("/a/b/C.MPK"); nil != err || MsgPackCodec != codec {
+		t.Fatal(codec, err)
+	}
+
+	// unknown codecs and missing or bad files are errors
+	var out codecObject
+	if err := SaveObject(filepath.Join(dir, "object.txt"), &in); nil == err {
+		t.Fatal("no codec")
+	}
+	if err := LoadObject(filepath.Join(dir, "missing.json"), &out); nil == err {
+		t.Fatal("no file")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad.json"), []byte("{"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	if err := LoadObject(filepath.Join(dir, "bad.json"), &out); nil == err {
+		t.Fatal("bad json")
+	}
+	if _, err := CodecByName("yaml2"); nil == err {
+		t.Fatal("no codec")
+	}
+}
+
+// TestPackObject - tests packing round trips, wrong keys and changed headers
+func TestPackObject(t *testing.T) {
+
+	// every codec, compression and with and without a key
+	key := NewKey(bytes.Repeat([]byte{7}, 32))
+	other := NewKey(bytes.Repeat([]byte{8}, 32))
+	in := codecObject{S: strings.Repeat("abc", 100), I: 9, L: []float64{1}}
+	for _, codec := range []Codec{nil, JSONCodec, MsgPackCodec, CBORCodec} {
+		for _, compression := range []string{CompressNone, CompressGzip, CompressZstd, CompressSnappy} {
+			for _, k := range []Key{nil, key} {
+				data, err := PackObject(&in, &PackOptions{Codec: codec, Compression: compression, Key: k})
+				if nil != err {
+					t.Fatal(compression, err)
+				}
+				if CompressNone != compression && len(data) > 250 {
+					t.Fatal(compression, "not compressed", len(data))
+				}
+				var out codecObject
+				if err = UnpackObject(data, &out, k); nil != err || !reflect.DeepEqual(in, out) {
+					t.Fatal(compression, out, err)
+				}
+			}
+		}
+	}
+
+	// keys must match what was packed
+	data, err := PackObject(&in, &PackOptions{Compression: CompressGzip, Key: key})
+	if nil != err {
+		t.Fatal(err)
+	}
+	var out codecObject
+	if err = UnpackObject(data, &out, nil); !errors.Is(err, ErrPackedNeedsKey) {
+		t.Fatal(err)
+	}
+	if err = UnpackObject(data, &out, other); !errors.Is(err, ErrCantDecryptBytes) {
+		t.Fatal(err)
+	}
+
+	// the header is sealed with the payload; changing it is caught
+	changed := bytes.Replace(data, []byte("gzip"), []byte("zstd"), 1)
+	if err = UnpackObject(changed, &out, key); !errors.Is(err, ErrCantDecryptBytes) {
+		t.Fatal(err)
+	}
+	changed = append([]byte{}, data...)
+	changed[len(packMagic)+2+len("gob")+1+len("gzip")] = 0
+	if err = UnpackObject(changed, &out, key); !errors.Is(err, ErrPackedNotEncrypted) {
+		t.Fatal(err)
+	}
+	plain, err := PackObject(&in, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err = UnpackObject(plain, &out, key); !errors.Is(err, ErrPackedNotEncrypted) {
+		t.Fatal(err)
+	}
+
+	// files
+	path := filepath.Join(t.TempDir(), "object.bin")
+	if err = SavePackedObject(path, &in, &PackOptions{Compression: CompressZstd, Key: key}); nil != err {
+		t.Fatal(err)
+	}
+	out = codecObject{}
+	if err = LoadPackedObject(path, &out, key); nil != err || !reflect.DeepEqual(in, out) {
+		t.Fatal(out, err)
+	}
+
+	// not packed, cut short or unknown parts
+	for _, data := range [][]byte{[]byte("nope"), []byte("UPK"), []byte("UPK\x02"), []byte("UPK\x01\x03go")} {
+		if err = UnpackObject(data, &out, nil); !errors.Is(err, ErrNotPacked) {
+			t.Fatalf("%q %v", data, err)
+		}
+	}
+	if _, err = PackObject(&in, &PackOptions{Compression: "lz4"}); nil == err {
+		t.Fatal("no compression")
+	}
+
+	// expanding stops at the limit
+	RegisterCompression("copy", func(in []byte) ([]byte, error) {
+		return in, nil
+	}, func(in []byte) ([]byte, error) {
+		return in, nil
+	})
+	big := codecObject{S: strings.Repeat("z", 1<<20)}
+	for _, compression := range []string{CompressGzip, CompressZstd, CompressSnappy, "copy"} {
+		data, err = PackObject(&big, &PackOptions{Compression: compression})
+		if nil != err {
+			t.Fatal(compression, err)
+		}
+		if err = UnpackObjectWithOptions(data, &out, &PackOptions{MaxExpanded: 1 << 16}); !errors.Is(err, ErrPackedTooLarge) {
+			t.Fatal(compression, err)
+		}
+		if err = UnpackObjectWithOptions(data, &out, nil); nil != err || big.S != out.S {
+			t.Fatal(compression, err)
+		}
+	}
+}
+
+// TestWriteFile - tests replacing files with backups, permissions and links
+func TestWriteFile(t *testing.T) {
+
+	// reads a file or "" if it isn't there
+	read := func(path string) string {
+		data, _ := ioutil.ReadFile(path)
+		return string(data)
+	}
+	dir := t.TempDir()
+
+	// new directories and files get the defaults; the directory isn't world writable
+	path := filepath.Join(dir, "sub", "deeper", "a.txt")
+	if err := WriteFile(path, []byte("0")); nil != err {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Dir(path))
+	if nil != err || 0 != info.Mode().Perm()&^DefaultDirPerm {
+		t.Fatal(info.Mode(), err)
+	}
+	info, err = os.Stat(path)
+	if nil != err || 0 != info.Mode().Perm()&^DefaultFilePerm || "0" != read(path) {
+		t.Fatal(info.Mode(), err)
+	}
+	other := filepath.Join(dir, "private", "b.txt")
+	if err = WriteFileWithOptions(other, []byte("b"), &WriteFileOptions{DirPerm: 0700, Perm: 0600}); nil != err {
+		t.Fatal(err)
+	}
+	if info, err = os.Stat(filepath.Dir(other)); nil != err || 0700 != info.Mode().Perm() {
+		t.Fatal(info.Mode(), err)
+	}
+
+	// backups rotate newest first and the oldest drops off
+	for i := 1; i <= 4; i++ {
+		if err = WriteFileWithOptions(path, []byte(Itoa(i)), &WriteFileOptions{Perm: 0600, Backups: 2}); nil != err {
+			t.Fatal(err)
+		}
+	}
+	if "4" != read(path) || "3" != read(path+".1") || "2" != read(path+".2") || "" != read(path+".3") {
+		t.Fatal(read(path), read(path+".1"), read(path+".2"))
+	}
+
+	// replacing keeps the permissions of the file and leaves no temp files
+	if err = WriteFile(path, []byte("5")); nil != err {
+		t.Fatal(err)
+	}
+	if info, err = os.Stat(path); nil != err || 0600 != info.Mode().Perm() || "5" != read(path) {
+		t.Fatal(info.Mode(), err)
+	}
+	entries, err := ioutil.ReadDir(filepath.Dir(path))
+	if nil != err || 3 != len(entries) {
+		t.Fatal(entries, err)
+	}
+
+	// a link is kept and the file it points to replaced, backups beside the file
+	link := filepath.Join(dir, "link.txt")
+	if err = os.Symlink(path, link); nil != err {
+		t.Fatal(err)
+	}
+	if err = WriteFileWithOptions(link, []byte("6"), &WriteFileOptions{Backups: 1}); nil != err {
+		t.Fatal(err)
+	}
+	if info, err = os.Lstat(link); nil != err || 0 == info.Mode()&os.ModeSymlink {
+		t.Fatal(info.Mode(), err)
+	}
+	if "6" != read(path) || "6" != read(link) || "5" != read(path+".1") || "" != read(link+".1") {
+		t.Fatal(read(path), read(path+".1"))
+	}
+
+	// a rename that fails leaves the old file and no temp file
+	blocked := filepath.Join(dir, "blocked")
+	if err = os.MkdirAll(filepath.Join(blocked, "x"), 0755); nil != err {
+		t.Fatal(err)
+	}
+	if err = WriteFile(blocked, []byte("x")); nil == err {
+		t.Fatal("renamed over a directory")
+	}
+	entries, err = ioutil.ReadDir(dir)
+	if nil != err {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			t.Fatal(entry.Name())
+		}
+	}
+}
+
+// configDB - a section of a config
+type configDB struct {
+	Host string `json:"host" default:"localhost"`
+	Port int    `json:"port" default:"5432" validate:"min=1,max=65535"`
+}
+
+// configCache - a section of a config held by pointer
+type configCache struct {
+	Size int    `json:"size" default:"64"`
+	Mode string `json:"mode" default:"lru" validate:"oneof=lru|lfu"`
+}
+
+// configApp - a config
+type configApp struct {
+	Name    string        `json:"name" validate:"required"`
+	Mode    string        `json:"mode" default:"dev" validate:"oneof=dev|prod"`
+	Tags    []string      `json:"tags" default:"a,b" validate:"max=3"`
+	Timeout time.Duration `json:"timeout" default:"5s"`
+	DB      configDB      `json:"database"`
+	Cache   *configCache  `json:"cache"`
+	Workers int           `json:"workers" default:"1" validate:"min=1"`
+	TLS     *configTLS    `json:"tls"`
+}
+
+// configTLS - an optional section whose fields are required when it is given
+type configTLS struct {
+	Cert string `json:"cert" validate:"required"`
+	Key  string `json:"key" validate:"required"`
+}
+
+// configRule - a config type that refers to itself
+type configRule struct {
+	Name     string      `json:"name" default:"rule" validate:"required"`
+	Fallback *configRule `json:"fallback"`
+}
+
+// TestLoadConfig - tests defaults then files then env then validation
+func TestLoadConfig(t *testing.T) {
+
+	// a base file and an override; a later missing file is fine
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.json")
+	prod := filepath.Join(dir, "prod.yaml")
+	if err := ioutil.WriteFile(base, []byte(`{"name":"svc","database":{"host":"db","port":5000},"workers":2}`), 0644); nil != err {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(prod, []byte("mode: prod\ndatabase:\n  port: 6000\ncache:\n  mode: lfu\n"), 0644); nil != err {
+		t.Fatal(err)
+	}
+
+	// each layer wins over the one before; pointer sections get defaults and env too
+	t.Setenv("APP_DATABASE_HOST", "envhost")
+	t.Setenv("APP_TAGS", "x,y")
+	t.Setenv("APP_CACHE_SIZE", "128")
+	var c configApp
+	err := LoadConfig(&c, &ConfigOptions{Files: []string{base, prod, filepath.Join(dir, "missing.json")}, EnvPrefix: "app"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	want := configApp{Name: "svc", Mode: "prod", Tags: []string{"x", "y"}, Timeout: 5 * time.Second, DB: configDB{"envhost", 6000}, Cache: &configCache{128, "lfu"}, Workers: 2}
+	if !reflect.DeepEqual(want, c) {
+		t.Fatalf("%+v %+v", c, *c.Cache)
+	}
+
+	// defaults alone fill a nil section
+	var d configApp
+	if err = ApplyDefaults(&d); nil != err || nil == d.Cache || 64 != d.Cache.Size || "localhost" != d.DB.Host || 1 != d.Workers {
+		t.Fatal(d, err)
+	}
+
+	// every problem is reported with the path of its field
+	t.Setenv("APP_WORKERS", "zero")
+	t.Setenv("APP_TAGS", "1,2,3,4")
+	t.Setenv("APP_CACHE_MODE", "fifo")
+	if err = ioutil.WriteFile(prod, []byte("mode: test\ndatabase:\n  port: 70000\n"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	var e configApp
+	err = LoadConfig(&e, &ConfigOptions{Files: []string{base, prod}, EnvPrefix: "APP"})
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, err := range errs {
+		paths = append(paths, err.Path)
+	}
+	if !AreStringSliceSame(paths, []string{"workers", "mode", "tags", "database.port", "cache.mode"}) {
+		t.Fatal(err)
+	}
+	if !strings.Contains(errs[0].Error(), "APP_WORKERS") || "must be at most 65535" != errs[3].Err.Error() {
+		t.Fatal(err)
+	}
+
+	// a missing first file and a bad file are errors for the file
+	var f configApp
+	err = LoadConfig(&f, &ConfigOptions{Files: []string{filepath.Join(dir, "none.json")}})
+	if errs, ok = err.(ConfigErrors); !ok || filepath.Join(dir, "none.json") != errs[0].Path || "name" != errs[1].Path {
+		t.Fatal(err)
+	}
+	if err = LoadConfig(f, nil); nil == err {
+		t.Fatal("not a pointer")
+	}
+
+	// checking changes nothing and skips sections that were left out
+	g := configApp{Name: "svc", Mode: "dev", DB: configDB{"h", 1}, Workers: 1}
+	if err = ValidateObject(&g); nil != err || nil != g.Cache || nil != g.TLS {
+		t.Fatal(err, g)
+	}
+
+	// a section is made when env sets a field in it, then checked
+	os.Unsetenv("APP_WORKERS")
+	os.Unsetenv("APP_TAGS")
+	os.Unsetenv("APP_CACHE_MODE")
+	var h configApp
+	if err = LoadConfig(&h, &ConfigOptions{Files: []string{base}, EnvPrefix: "APP"}); nil != err || nil != h.TLS {
+		t.Fatal(err)
+	}
+	t.Setenv("APP_TLS_CERT", "cert.pem")
+	err = LoadConfig(&h, &ConfigOptions{Files: []string{base}, EnvPrefix: "APP"})
+	if errs, ok = err.(ConfigErrors); !ok || 1 != len(errs) || "tls.key" != errs[0].Path || nil == h.TLS || "cert.pem" != h.TLS.Cert {
+		t.Fatal(err)
+	}
+
+	// a type that refers to itself ends
+	var r configRule
+	if err = LoadConfig(&r, nil); nil != err || "rule" != r.Name || nil != r.Fallback {
+		t.Fatal(err, r)
+	}
+	r.Fallback = &configRule{}
+	if err = ValidateObject(&r); nil != err {
+		t.Fatal(err)
+	}
+}
+
+// configWatched - a watched config
+type configWatched struct {
+	Name string `json:"name" validate:"required"`
+	N    int    `json:"n"`
+}
+
+// TestWatchConfig - tests reloading on changes with events, polling and kubernetes style links
+func TestWatchConfig(t *testing.T) {
+
+	// waits for the watcher to get somewhere
+	wait := func(what string, done func() bool) {
+		for i := 0; i < 200 && !done(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if !done() {
+			t.Fatal("timed out waiting for", what)
+		}
+	}
+	write := func(path string, n int) {
+		if err := SaveJSONObject(path, &configWatched{Name: "a", N: n}); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	// files in a missing directory can't be watched by events so polling takes over
+	for _, mode := range []string{"events", "poll", "fallback"} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "config.json")
+		write(path, 1)
+		options := &ConfigWatchOptions{ConfigOptions: ConfigOptions{Files: []string{path}}, Poll: "poll" == mode, Interval: 20 * time.Millisecond, Settle: 20 * time.Millisecond}
+		later := filepath.Join(dir, "later", "config.json")
+		if "fallback" == mode {
+			options.Files = append(options.Files, later)
+		}
+		w, err := WatchConfig(&configWatched{}, options)
+		if nil != err {
+			t.Fatal(mode, err)
+		}
+		n := func() int {
+			return w.Get().(*configWatched).N
+		}
+		var calls int32
+		w.Subscribe(func(old, new interface{}) {
+			if old.(*configWatched).N >= new.(*configWatched).N {
+				t.Error("old and new", old, new)
+			}
+			atomic.AddInt32(&calls, 1)
+		})
+
+		// a change reloads; a bad file keeps the last good config
+		time.Sleep(30 * time.Millisecond)
+		write(path, 2)
+		wait(mode+" change", func() bool { return 2 == n() })
+		if err = WriteFile(path, []byte("{bad")); nil != err {
+			t.Fatal(err)
+		}
+		wait(mode+" error", func() bool { return nil != w.Err() })
+		if 2 != n() {
+			t.Fatal(mode, n())
+		}
+		write(path, 3)
+		wait(mode+" fix", func() bool { return 3 == n() && nil == w.Err() })
+		if 2 != atomic.LoadInt32(&calls) {
+			t.Fatal(mode, calls)
+		}
+		if "fallback" == mode {
+			if err = os.MkdirAll(filepath.Dir(later), 0755); nil != err {
+				t.Fatal(err)
+			}
+			write(later, 4)
+			wait("file in a new directory", func() bool { return 4 == n() })
+		}
+		w.Close()
+	}
+
+	// kubernetes mounts files as links through a "..data" link it swaps to a new directory
+	for _, poll := range []bool{false, true} {
+		dir := t.TempDir()
+		version := func(name string, n int) {
+			write(filepath.Join(dir, name, "config.json"), n)
+			if err := os.Symlink(name, filepath.Join(dir, "..data_tmp")); nil != err {
+				t.Fatal(err)
+			}
+			if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); nil != err {
+				t.Fatal(err)
+			}
+		}
+		version("..2021_01", 1)
+		path := filepath.Join(dir, "config.json")
+		if err := os.Symlink(filepath.Join("..data", "config.json"), path); nil != err {
+			t.Fatal(err)
+		}
+		w, err := WatchConfig(&configWatched{}, &ConfigWatchOptions{ConfigOptions: ConfigOptions{Files: []string{path}}, Poll: poll, Interval: 20 * time.Millisecond, Settle: 20 * time.Millisecond})
+		if nil != err {
+			t.Fatal(err)
+		}
+		time.Sleep(30 * time.Millisecond)
+		version("..2021_02", 2)
+		wait("link swap", func() bool { return 2 == w.Get().(*configWatched).N })
+		w.Close()
+	}
+}
+
+// jsonServer - an element of a json list
+type jsonServer struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// JSONBase - fields embedded by pointer
+type JSONBase struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+	Owner string
+}
+
+// JSONOther - fields embedded beside JSONBase
+type JSONOther struct {
+	Owner string
+	Note  string `json:"label"`
+}
+
+// jsonStrict - a json object embedding by pointer
+type jsonStrict struct {
+	*JSONBase
+	*JSONOther
+	Name    string            `json:"name"`
+	Servers []jsonServer      `json:"servers"`
+	Extra   map[string]string `json:"extra"`
+	Label   string            `json:"label"`
+}
+
+// TestStrictJSON - tests where json errors are found and embedded fields
+func TestStrictJSON(t *testing.T) {
+
+	// errors give the line, column, path and text of the problem
+	cases := []struct {
+		text    string
+		options *JSONOptions
+		line    int
+		column  int
+		path    string
+		snippet string
+	}{
+		{"{\n \"name\": \"a\",\n \"servers\": [{\"host\": \"x\", \"port\": 1}, {\"host\": \"y\",\n   \"port\": \"bad\"}]\n}", nil, 4, 12, "servers[1].port", `   "port": "bad"}]`},
+		{`{"name": "a", "nmae": 2}`, StrictJSON, 1, 15, "nmae", `{"name": "a", "nmae": 2}`},
+		{`{"name": "a", "name": "b"}`, StrictJSON, 1, 15, "name", `{"name": "a", "name": "b"}`},
+		{`{"extra": {"k": "1", "k": "2"}}`, StrictJSON, 1, 22, "extra.k", `{"extra": {"k": "1", "k": "2"}}`},
+		{`{"servers": [{"host": "a",]}`, nil, 1, 26, "servers[0]", `{"servers": [{"host": "a",]}`},
+		{`{"name": "a"} x`, nil, 1, 15, "", `{"name": "a"} x`},
+	}
+	for _, c := range cases {
+		var v jsonStrict
+		err := DecodeJSON([]byte(c.text), &v, c.options)
+		jsonErr, ok := err.(*JSONError)
+		if !ok {
+			t.Fatal(c.text, err)
+		}
+		if c.line != jsonErr.Line || c.column != jsonErr.Column || c.path != jsonErr.Path || c.snippet != jsonErr.Snippet {
+			t.Fatal(c.text, jsonErr.Line, jsonErr.Column, jsonErr.Path, jsonErr.Snippet)
+		}
+		if !strings.HasSuffix(jsonErr.Error(), "\n\t"+c.snippet+"\n\t"+strings.Repeat(" ", c.column-1)+"^") {
+			t.Fatal(jsonErr.Error())
+		}
+	}
+
+	// duplicate keys are fine unless asked
+	var v jsonStrict
+	if err := DecodeJSON([]byte(`{"name": "a", "name": "b"}`), &v, nil); nil != err || "b" != v.Name {
+		t.Fatal(err)
+	}
+	if err := DecodeJSON([]byte(`{"name": "a", "name": "b"}`), &v, &JSONOptions{DisallowUnknownFields: true}); nil != err {
+		t.Fatal(err)
+	}
+
+	// fields embedded by pointer are known; the shallow label wins and the
+	// tied Owner is dropped, just as encoding/json does
+	fields := GetTaggedFields(reflect.TypeOf(jsonStrict{}), "json")
+	var names []string
+	for _, f := range fields {
+		names = append(names, f.Name)
+	}
+	if !AreStringSliceSame(names, []string{"id", "name", "servers", "extra", "label"}) || !reflect.DeepEqual([]int{0, 0}, fields[0].Index) {
+		t.Fatal(names)
+	}
+	v = jsonStrict{}
+	if err := DecodeJSON([]byte(`{"id": "7", "label": "top", "name": "n"}`), &v, StrictJSON); nil != err || nil == v.JSONBase || "7" != v.ID || "top" != v.Label {
+		t.Fatal(err)
+	}
+	if err := DecodeJSON([]byte(`{"Owner": "x"}`), &v, StrictJSON); nil == err || "Owner" != err.(*JSONError).Path {
+		t.Fatal(err)
+	}
+
+	// a nil embedded pointer reads as zero and is made when set
+	var b bytes.Buffer
+	e := NewCSVEncoder(&b, nil)
+	if err := e.Encode(&csvEmbedded{Name: "a"}); nil != err {
+		t.Fatal(err)
+	}
+	if err := e.Flush(); nil != err || "ID,Name\n0,a\n" != b.String() {
+		t.Fatal(err, b.String())
+	}
+	var rows []csvEmbedded
+	if err := UnmarshalCSV(strings.NewReader("ID,Name\n3,b\n"), &rows, nil); nil != err || 1 != len(rows) || nil == rows[0].CSVID || 3 != rows[0].ID {
+		t.Fatal(err, rows)
+	}
+}
+
+// CSVID - a field embedded by pointer
+type CSVID struct {
+	ID int
+}
+
+// csvEmbedded - a csv row embedding by pointer
+type csvEmbedded struct {
+	*CSVID
+	Name string
+}
+
+// configFormatted - a config read from every format
+type configFormatted struct {
+	Name  string             `json:"name"`
+	Port  int                `json:"port"`
+	Ratio float64            `json:"ratio"`
+	Tags  []string           `json:"tags"`
+	DB    configDB           `json:"database"`
+	Rates map[string]float64 `json:"rates"`
+	Any   interface{}        `json:"any"`
+}
+
+// TestConfigFormats - tests reading and writing json, jsonc, json5, yaml and toml
+func TestConfigFormats(t *testing.T) {
+
+	// the same config in every format
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.json":  `{"name": "svc", "port": 8, "ratio": 0.5, "tags": ["a", "b"], "database": {"host": "h", "port": 1}}`,
+		"a.jsonc": "{\n  // the name\n  \"name\": \"svc\", /* a port */ \"port\": 8,\n  \"ratio\": 0.5,\n  \"tags\": [\"a\", \"b\",],\n  \"database\": {\"host\": \"h\", \"port\": 1,},\n}\n",
+		"a.json5": "{\n  name: 's\\\n\\x76c',\n  port: 0x8,\n  ratio: .5,\n  tags: ['a', \"b\"],\n  database: {host: 'h', port: +1.},\n}",
+		"a.yaml":  "name: svc\nport: 8\nratio: 0.5\ntags: [a, b]\ndatabase:\n  host: h\n  port: 1\n",
+		"a.toml":  "name = \"svc\"\nport = 8\nratio = 0.5\ntags = [\"a\", \"b\"]\n[database]\nhost = \"h\"\nport = 1\n",
+	}
+	want := configFormatted{Name: "svc", Port: 8, Ratio: 0.5, Tags: []string{"a", "b"}, DB: configDB{"h", 1}}
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(text), 0644); nil != err {
+			t.Fatal(err)
+		}
+		var c configFormatted
+		if err := LoadConfigFile(path, &c, StrictJSON); nil != err || !reflect.DeepEqual(want, c) {
+			t.Fatal(name, err, c)
+		}
+
+		// saved in the format of the extension and read back the same
+		saved := filepath.Join(dir, "saved"+filepath.Ext(name))
+		if err := SaveConfigFile(saved, &c); nil != err {
+			t.Fatal(name, err)
+		}
+		var d configFormatted
+		if err := LoadConfigFile(saved, &d, StrictJSON); nil != err || !reflect.DeepEqual(want, d) {
+			t.Fatal(name, err, d)
+		}
+		data, _ := ioutil.ReadFile(saved)
+		if bytes.Contains(data, []byte("//")) {
+			t.Fatal(name, string(data))
+		}
+	}
+
+	// json5 numbers json has no form for are read into floats
+	var c configFormatted
+	path := filepath.Join(dir, "numbers.json5")
+	if err := ioutil.WriteFile(path, []byte("{ratio: -Infinity, rates: {'up': +Infinity, \"x y\": NaN}, any: [1, NaN]}"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	if err := LoadConfigFile(path, &c, StrictJSON); nil != err {
+		t.Fatal(err)
+	}
+	if !math.IsInf(c.Ratio, -1) || !math.IsInf(c.Rates["up"], 1) || !math.IsNaN(c.Rates["x y"]) || !math.IsNaN(c.Any.([]interface{})[1].(float64)) {
+		t.Fatal(c)
+	}
+	if text, err := JSONCToJSON([]byte("[Infinity, -0x10, 5.e1]")); nil != err || `["Infinity", -16, 5e1]` != string(text) {
+		t.Fatal(string(text), err)
+	}
+
+	// errors are at the lines and columns of the file, not of the json made from it
+	cases := []struct {
+		text   string
+		line   int
+		column int
+		path   string
+	}{
+		{"{\n  name: 'svc', port: 'x'}", 2, 22, "port"},
+		{"{name: 'a',\n port: NaN}", 2, 8, "port"},
+		{"{tags: ['a' /* open }", 1, 13, ""},
+		{"{name: 'a\n}", 1, 8, ""},
+	}
+	for _, test := range cases {
+		if err := ioutil.WriteFile(path, []byte(test.text), 0644); nil != err {
+			t.Fatal(err)
+		}
+		err := LoadConfigFile(path, &c, nil)
+		var jsonErr *JSONError
+		if !errors.As(err, &jsonErr) || test.line != jsonErr.Line || test.column != jsonErr.Column || test.path != jsonErr.Path {
+			t.Fatal(test.text, err)
+		}
+	}
+}
+
+// SchemaBase - fields embedded by pointer into a schema
+type SchemaBase struct {
+	ID string `json:"id" validate:"required"`
+}
+
+// schemaPart - a named struct kept in $defs
+type schemaPart struct {
+	Size int `json:"size" validate:"min=1"`
+}
+
+// schemaShape - a struct described by a schema
+type schemaShape struct {
+	*SchemaBase
+	Name   string            `json:"name" validate:"min=2" description:"what it is"`
+	Labels map[string]string `json:"labels" validate:"min=1,max=2"`
+	Next   *schemaShape      `json:"next"`
+	Parts  []schemaPart      `json:"parts"`
+}
+
+// TestJSONSchema - tests generating schemas from types and validating json with them
+func TestJSONSchema(t *testing.T) {
+
+	// embedded fields are properties of their own, maps count properties and
+	// named structs are referred to
+	schema := GenerateJSONSchema(&schemaShape{})
+	data, err := json.Marshal(schema)
+	if nil != err {
+		t.Fatal(err)
+	}
+	var generic map[string]interface{}
+	json.Unmarshal(data, &generic)
+	properties := generic["properties"].(map[string]interface{})
+	if _, found := properties["SchemaBase"]; found || nil == properties["id"] || !AreStringSliceSame([]string{"id"}, schema.Required) {
+		t.Fatal(string(data))
+	}
+	labels := properties["labels"].(map[string]interface{})
+	if 1.0 != labels["minProperties"] || 2.0 != labels["maxProperties"] || nil != labels["minItems"] {
+		t.Fatal(labels)
+	}
+	if "#/$defs/schemaPart" != schema.Properties["parts"].Items.Ref || "#" != schema.Properties["next"].AnyOf[0].Ref || "what it is" != schema.Properties["name"].Description {
+		t.Fatal(string(data))
+	}
+
+	// documents are checked against it
+	cases := []struct {
+		text   string
+		errors []string
+	}{
+		{`{"id": "a", "name": "ab", "labels": {"x": "1"}, "parts": [{"size": 1}], "next": {"id": "b"}}`, nil},
+		{`{"name": "a", "labels": {}, "parts": [{"size": 0}], "next": {"id": "b", "other": 1}}`, []string{"id: is required", "labels: has 0 properties, want at least 1", "name: is 1 long, want at least 2", "next.other: is not a known property", "parts[0].size: is 0, want at least 1"}},
+		{`{"id": "a", "labels": {"x": "1", "y": "2", "z": "3"}, "next": 5}`, []string{"labels: has 3 properties, want at most 2", "next: is number, want object"}},
+	}
+	for _, c := range cases {
+		err := ValidateJSON(schema, []byte(c.text))
+		var got []string
+		if errs, ok := err.(ConfigErrors); ok {
+			for _, e := range errs {
+				got = append(got, e.Path+": "+e.Err.Error())
+			}
+		} else if nil != err {
+			t.Fatal(err)
+		}
+		if !AreStringSliceSame(c.errors, got) {
+			t.Fatal(c.text, got)
+		}
+	}
+
+	// structs of one name from different places get their own $defs
+	type FileHeader struct {
+		Size int `json:"size"`
+	}
+	type headers struct {
+		Zip   zip.FileHeader `json:"zip"`
+		Local FileHeader     `json:"local"`
+	}
+	schema = GenerateJSONSchema(headers{})
+	if 2 != len(schema.Defs) || "#/$defs/FileHeader" != schema.Properties["zip"].AllOf[0].Ref ||
+		"#/$defs/github.com.stevenb256.utility.FileHeader" != schema.Properties["local"].AllOf[0].Ref {
+		t.Fatal(schema.Defs, schema.Properties["local"].AllOf[0].Ref)
+	}
+	if err = ValidateJSON(schema, []byte(`{"local": {"size": "big"}}`)); nil == err || "local.size" != err.(ConfigErrors)[0].Path {
+		t.Fatal(err)
+	}
+
+	// anyOf reports the branch that came closest, $ref is followed and oneOf
+	// wants exactly one match
+	var written JSONSchema
+	err = json.Unmarshal([]byte(`{
+		"$defs": {"port": {"type": "integer", "maximum": 10}},
+		"properties": {
+			"server": {"anyOf": [{"type": "string"}, {"type": "object", "properties": {"port": {"$ref": "#/$defs/port"}}}]},
+			"size": {"oneOf": [{"type": "integer"}, {"type": "number"}]},
+			"bad": {"$ref": "#/$defs/none"}
+		}
+	}`), &written)
+	if nil != err {
+		t.Fatal(err)
+	}
+	cases = []struct {
+		text   string
+		errors []string
+	}{
+		{`{"server": "host", "size": 1.5}`, nil},
+		{`{"server": {"port": 20}}`, []string{"server.port: is 20, want at most 10"}},
+		{`{"server": {"port": "x"}}`, []string{"server.port: is string, want integer"}},
+		{`{"server": 5}`, []string{"server: is number, want string"}},
+		{`{"size": 1}`, []string{"size: matches 2 of oneOf, want 1"}},
+		{`{"size": "x"}`, []string{"size: matches 0 of oneOf, want 1"}},
+		{`{"bad": 1}`, []string{"bad: can't resolve $ref '#/$defs/none'"}},
+	}
+	for _, c := range cases {
+		err := ValidateJSON(&written, []byte(c.text))
+		var got []string
+		if errs, ok := err.(ConfigErrors); ok {
+			for _, e := range errs {
+				got = append(got, e.Path+": "+e.Err.Error())
+			}
+		} else if nil != err {
+			t.Fatal(err)
+		}
+		if !AreStringSliceSame(c.errors, got) {
+			t.Fatal(c.text, got)
+		}
+	}
+
+	// refs that lead back to themselves at the same place are errors
+	path := filepath.Join(t.TempDir(), "loop.json")
+	for _, text := range []string{
+		`{"$defs": {"a": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`,
+		`{"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"anyOf": [{"$ref": "#/$defs/a"}]}}, "properties": {"x": {"$ref": "#/$defs/a"}}}`,
+		`{"$ref": "#"}`,
+	} {
+		if err = ioutil.WriteFile(path, []byte(text), 0644); nil != err {
+			t.Fatal(err)
+		}
+		loop, err := LoadJSONSchema(path)
+		if nil != err {
+			t.Fatal(err)
+		}
+		err = ValidateJSON(loop, []byte(`{"x": 1}`))
+		if nil == err || !strings.Contains(err.Error(), "refers to itself") {
+			t.Fatal(text, err)
+		}
+	}
+
+	// a ref may lead back to itself deeper in the document
+	list := &JSONSchema{Defs: map[string]*JSONSchema{"node": {Properties: map[string]*JSONSchema{"next": {Ref: "#/$defs/node"}, "n": {Type: JSONSchemaType{"integer"}}}}}, Ref: "#/$defs/node"}
+	if err = ValidateJSON(list, []byte(`{"n": 1, "next": {"n": 2, "next": {"n": "x"}}}`)); nil == err || "next.next.n" != err.(ConfigErrors)[0].Path {
+		t.Fatal(err)
+	}
+}
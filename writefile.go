@@ -0,0 +1,152 @@
+package utl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	l "github.com/stevenb256/log"
+)
+
+// DefaultFilePerm is given to new files when no permissions are asked for
+const DefaultFilePerm os.FileMode = 0644
+
+// DefaultDirPerm is given to new directories when no permissions are asked for
+const DefaultDirPerm os.FileMode = 0755
+
+// WriteFileOptions controls how WriteFileWithOptions replaces a file
+type WriteFileOptions struct {
+	Perm    os.FileMode // permissions of the file; keeps those of an existing file or DefaultFilePerm when 0
+	DirPerm os.FileMode // permissions of directories made for the file; DefaultDirPerm when 0
+	Backups int         // previous versions kept as path.1 (newest) to path.N
+}
+
+// WriteFileWithOptions replaces path atomically: buffer goes to a temp file in
+// the same directory which is synced and renamed over path, so readers see the
+// old or new contents and never part of either. When path is a symlink the
+// file it points to is replaced and the link kept
+func WriteFileWithOptions(path string, buffer []byte, options *WriteFileOptions) error {
+
+	// locals
+	o := WriteFileOptions{}
+	if nil != options {
+		o = *options
+	}
+	if 0 == o.DirPerm {
+		o.DirPerm = DefaultDirPerm
+	}
+
+	// replace the target of a link rather than the link
+	if target, err := filepath.EvalSymlinks(path); nil == err {
+		path = target
+	}
+	dir := filepath.Dir(path)
+
+	// make sure directory exists
+	err := os.MkdirAll(dir, o.DirPerm)
+	if l.Check(err) {
+		return err
+	}
+
+	// keep permissions of the file being replaced
+	perm := o.Perm
+	if 0 == perm {
+		perm = DefaultFilePerm
+		if info, err := os.Stat(path); nil == err {
+			perm = info.Mode().Perm()
+		}
+	}
+
+	// write temp file
+	temp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if l.Check(err) {
+		return err
+	}
+	err = writeTemp(temp, buffer, perm)
+	if nil != err {
+		os.Remove(temp.Name())
+		return l.Fail(err)
+	}
+
+	// keep old versions
+	if o.Backups > 0 {
+		err = rotateBackups(path, o.Backups)
+		if nil != err {
+			os.Remove(temp.Name())
+			return l.Fail(err)
+		}
+	}
+
+	// swap it in
+	err = os.Rename(temp.Name(), path)
+	if nil != err {
+		os.Remove(temp.Name())
+		return l.Fail(err)
+	}
+	err = syncDir(dir)
+	if l.Check(err) {
+		return err
+	}
+
+	// done
+	return nil
+}
+
+// writeTemp writes, syncs and closes a temp file
+func writeTemp(temp *os.File, buffer []byte, perm os.FileMode) error {
+	_, err := temp.Write(buffer)
+	if nil == err {
+		err = temp.Chmod(perm)
+	}
+	if nil == err {
+		err = temp.Sync()
+	}
+	if e := temp.Close(); nil == err {
+		err = e
+	}
+	return err
+}
+
+// rotateBackups shifts path.1..path.n-1 up one and copies path to path.1; the
+// current file stays in place until it is replaced
+func rotateBackups(path string, n int) error {
+
+	// nothing to back up yet
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	// shift older versions
+	os.Remove(fmt.Sprintf("%s.%d", path, n))
+	for i := n - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(from); nil == err {
+			err = os.Rename(from, fmt.Sprintf("%s.%d", path, i+1))
+			if nil != err {
+				return err
+			}
+		}
+	}
+
+	// newest backup shares the current file or is a copy of it
+	backup := path + ".1"
+	if nil == os.Link(path, backup) {
+		return nil
+	}
+	return CopyFile(path, backup)
+}
+
+// syncDir flushes a directory so a rename in it survives a crash; windows
+// can't open directories for this and doesn't need it
+func syncDir(dir string) error {
+	if "windows" == runtime.GOOS {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if nil != err {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}